@@ -0,0 +1,107 @@
+package core
+
+import (
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/aggregation"
+	"github.com/rihtim/core/dataprovider"
+)
+
+const aggregateCommand = "_aggregate"
+
+/**
+ * Executes an aggregation pipeline on the collection. Pipeline is passed as the json
+ * encoded 'pipeline' parameter of the GET requests or in the body of the POST requests.
+ * The 'where' parameter is applied as the first $match stage. Interceptors of the collection
+ * are executed for the aggregations as well.
+ *
+ * GET  /orders/_aggregate?pipeline=[{"$group": {"_id": "$country", "total": {"$sum": "$amount"}}}]
+ * POST /orders/_aggregate {"pipeline": [{"$match": {"status": "paid"}}, {"$sort": {"amount": -1}}, {"$limit": 5}]}
+ * GET  /users/{id}/orders/_aggregate?pipeline=[...]
+ *
+ * Ex: {"results": [{"_id": "TR", "total": 120}, {"_id": "DE", "total": 80}]}
+ */
+var handleAggregate = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	var pipeline aggregation.Pipeline
+	if strings.EqualFold(request.Command, methods.Get) {
+		value, hasPipeline := request.GetParameter("pipeline")
+		if !hasPipeline {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'pipeline' is required."}
+			return
+		}
+		pipeline, err = aggregation.ParseJSON([]byte(value))
+	} else if strings.EqualFold(request.Command, methods.Post) {
+		if request.Body == nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Request body must have the 'pipeline' field."}
+			return
+		}
+		pipeline, err = parseBodyPipeline(request)
+	} else {
+		err = &utils.Error{Code: http.StatusMethodNotAllowed, Message: "Method not allowed on the resource type."}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	// aggregations of the sub resources are restricted to the objects of the parent
+	collectionRequest := aggregatedRequestOf(request)
+	if len(strings.Split(collectionRequest.Res, "/")) == 4 {
+		if collectionRequest, err = resolveSubResource(collectionRequest, db); err != nil {
+			return
+		}
+	}
+	if collectionRequest, err = excludeDeleted(collectionRequest, db); err != nil {
+		return
+	}
+
+	if value, hasWhere := collectionRequest.GetParameter("where"); hasWhere && value != "" {
+		var where map[string]interface{}
+		if decodeErr := json.Unmarshal([]byte(value), &where); decodeErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'where' must be a json object."}
+			return
+		}
+		pipeline = append(aggregation.Pipeline{{Operator: "$match", Value: where}}, pipeline...)
+	}
+
+	class := strings.Split(collectionRequest.Res, "/")[1]
+	results, err := aggregation.Aggregate(db, class, pipeline)
+	if err != nil {
+		return
+	}
+	if results == nil {
+		results = make([]map[string]interface{}, 0)
+	}
+	response.Body = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
+
+// pipeline is parsed from the raw json body if it's kept, so the fields of the $sort stages keep their order
+func parseBodyPipeline(request messages.Message) (pipeline aggregation.Pipeline, err *utils.Error) {
+	var body struct {
+		Pipeline json.RawMessage `json:"pipeline"`
+	}
+	if request.RawBody != nil && json.Unmarshal(request.RawBody, &body) == nil && body.Pipeline != nil {
+		return aggregation.ParseJSON(body.Pipeline)
+	}
+	return aggregation.Parse(request.Body["pipeline"])
+}
+
+// returns the query of the collection the aggregation is executed on
+func aggregatedRequestOf(request messages.Message) (collectionRequest messages.Message) {
+	collectionRequest = request
+	collectionRequest.Command = methods.Get
+	collectionRequest.Res = strings.TrimSuffix(request.Res, "/"+aggregateCommand)
+	return
+}
+
+// checks if the resource is the aggregation of a collection. ex: /orders/_aggregate, /users/1/orders/_aggregate
+func isAggregation(res string) bool {
+	parts := strings.Split(res, "/")
+	return (len(parts) == 3 || len(parts) == 5) && parts[len(parts)-1] == aggregateCommand
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/aggregation"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregate(t *testing.T) {
+
+	Convey("Given the orders of users", t, func() {
+		provider := newMemoryProvider()
+		provider.Create("users", map[string]interface{}{"name": "alice"})
+		provider.Create("orders", map[string]interface{}{"userId": "1", "status": "paid", "amount": 10.0})
+		provider.Create("orders", map[string]interface{}{"userId": "1", "status": "paid", "amount": 20.0})
+		provider.Create("orders", map[string]interface{}{"userId": "9", "status": "paid", "amount": 40.0})
+		provider.Create("orders", map[string]interface{}{"userId": "1", "status": "refunded", "amount": 80.0})
+
+		AddSubResource("users", "orders", "userId")
+		aggregation.QueryPageSize = 2
+		defer func() {
+			subResources = make(map[string]SubResource)
+			aggregation.QueryPageSize = 1000
+		}()
+
+		total := func(request messages.Message) (interface{}, int) {
+			response, _, err := Execute(request, provider)
+			if err != nil {
+				return nil, err.Code
+			}
+			results := response.Body["results"].([]map[string]interface{})
+			So(results, ShouldHaveLength, 1)
+			return results[0]["total"], 0
+		}
+
+		group := `[{"$group": {"_id": null, "total": {"$sum": "$amount"}}}]`
+
+		Convey("Pipeline of the GET requests should be executed on all the pages", func() {
+			value, code := total(messages.Message{Res: "/orders/_aggregate", Command: methods.Get, Parameters: map[string][]string{"pipeline": {group}}})
+			So(code, ShouldEqual, 0)
+			So(value, ShouldEqual, 150)
+		})
+
+		Convey("Where parameter should be applied before the pipeline", func() {
+			value, _ := total(messages.Message{Res: "/orders/_aggregate", Command: methods.Get, Parameters: map[string][]string{"pipeline": {group}, "where": {`{"status":"paid"}`}}})
+			So(value, ShouldEqual, 70)
+		})
+
+		Convey("Pipeline of the POST requests should be read from the body", func() {
+			body := map[string]interface{}{"pipeline": []interface{}{
+				map[string]interface{}{"$match": map[string]interface{}{"status": "refunded"}},
+				map[string]interface{}{"$group": map[string]interface{}{"_id": nil, "total": map[string]interface{}{"$sum": "$amount"}}},
+			}}
+			value, _ := total(messages.Message{Res: "/orders/_aggregate", Command: methods.Post, Body: body})
+			So(value, ShouldEqual, 80)
+		})
+
+		Convey("Aggregations of the sub resources should include the objects of the parent", func() {
+			value, _ := total(messages.Message{Res: "/users/1/orders/_aggregate", Command: methods.Get, Parameters: map[string][]string{"pipeline": {group}}})
+			So(value, ShouldEqual, 110)
+		})
+
+		Convey("Sorts of the POST requests should keep the order of the fields in the body", func() {
+			request := messages.Message{Res: "/orders/_aggregate", Command: methods.Post, Body: map[string]interface{}{"pipeline": []interface{}{}},
+				RawBody: []byte(`{"pipeline": [{"$sort": {"userId": -1, "amount": -1}}, {"$limit": 1}]}`)}
+			response, _, err := Execute(request, provider)
+			So(err, ShouldBeNil)
+			So(response.Body["results"].([]map[string]interface{})[0]["amount"], ShouldEqual, 40)
+		})
+
+		Convey("Interceptors of the collection should be executed for the aggregations", func() {
+			DataProvider = provider
+			Interceptors = &interceptors.CoreInterceptorController{}
+			defer func() { Interceptors = &interceptors.CoreInterceptorController{}; DataProvider = nil }()
+
+			Interceptors.Add("/orders", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				if req.Parameters["pipeline"] == nil {
+					err = &utils.Error{Code: http.StatusForbidden, Message: "Forbidden."}
+					return
+				}
+				editedReq = req
+				editedReq.Parameters = map[string][]string{"pipeline": req.Parameters["pipeline"], "where": {`{"status":"refunded"}`}}
+				return
+			}, nil)
+
+			response, _, err := HandleRequest(messages.Message{Res: "/orders/_aggregate", Command: methods.Get, Parameters: map[string][]string{"pipeline": {group}}}, requestscope.Init())
+			So(err, ShouldBeNil)
+			So(response.Body["results"].([]map[string]interface{})[0]["total"], ShouldEqual, 80)
+
+			_, _, err = HandleRequest(messages.Message{Res: "/orders/_aggregate", Command: methods.Post, Body: map[string]interface{}{"pipeline": []interface{}{}}}, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+
+			response, _, err = HandleRequest(messages.Message{Res: "/users/1/orders/_aggregate", Command: methods.Get, Parameters: map[string][]string{"pipeline": {group}}}, requestscope.Init())
+			So(err, ShouldBeNil)
+			So(response.Body["results"].([]map[string]interface{})[0]["total"], ShouldEqual, 80)
+		})
+
+		Convey("Missing and invalid pipelines should be rejected", func() {
+			_, code := total(messages.Message{Res: "/orders/_aggregate", Command: methods.Get})
+			So(code, ShouldEqual, http.StatusBadRequest)
+
+			_, code = total(messages.Message{Res: "/orders/_aggregate", Command: methods.Get, Parameters: map[string][]string{"pipeline": {`[{"$out": "copy"}]`}}})
+			So(code, ShouldEqual, http.StatusBadRequest)
+
+			_, code = total(messages.Message{Res: "/orders/_aggregate", Command: methods.Delete})
+			So(code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
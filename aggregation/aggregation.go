@@ -0,0 +1,224 @@
+package aggregation
+
+import (
+	"bytes"
+	"strconv"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+/**
+ * Stage of a pipeline. Each stage has a single operator.
+ *
+ * {"$match": {"status": "paid", "amount": {"$gte": 10}}}
+ * {"$group": {"_id": "$country", "total": {"$sum": "$amount"}, "orders": {"$sum": 1}}}
+ * {"$sort": {"total": -1}}
+ * {"$skip": 10}
+ * {"$limit": 5}
+ * {"$project": {"country": "$_id", "total": 1, "_id": 0}}
+ * {"$addFields": {"net": {"$subtract": ["$total", "$tax"]}}}
+ */
+type Stage struct {
+	Operator string
+	Value    interface{}
+	// fields of the $sort stages in the order of the json. empty if the order is unknown
+	Keys []string
+}
+
+type Pipeline []Stage
+
+/**
+ * Optional interface for the providers that can execute the pipelines natively.
+ * Pipelines are evaluated in process for the other providers.
+ */
+type Aggregator interface {
+	Aggregate(collection string, pipeline Pipeline) (results []map[string]interface{}, err *utils.Error)
+}
+
+// number of the documents fetched in each query by the in process evaluator
+var QueryPageSize = 1000
+
+// in process evaluation fails if the collection has more documents than this after the leading $match stages
+var MaxDocuments = 100000
+
+var stageOperators = map[string]bool{
+	"$match":     true,
+	"$group":     true,
+	"$sort":      true,
+	"$skip":      true,
+	"$limit":     true,
+	"$project":   true,
+	"$addFields": true,
+}
+
+// parses and validates the decoded json array of the stages
+func Parse(value interface{}) (pipeline Pipeline, err *utils.Error) {
+
+	stages, isArray := value.([]interface{})
+	if !isArray {
+		err = invalid("Pipeline must be an array of stages.")
+		return
+	}
+
+	pipeline = make(Pipeline, 0, len(stages))
+	for i, rawStage := range stages {
+		stage, isObject := rawStage.(map[string]interface{})
+		if !isObject || len(stage) != 1 {
+			err = invalid("Stage " + strconv.Itoa(i) + " must be an object with a single operator.")
+			return
+		}
+		for operator, stageValue := range stage {
+			if !stageOperators[operator] {
+				err = invalid("Stage operator '" + operator + "' is not supported.")
+				return
+			}
+			if err = validateStage(operator, stageValue); err != nil {
+				return
+			}
+			pipeline = append(pipeline, Stage{Operator: operator, Value: stageValue})
+		}
+	}
+	return
+}
+
+/**
+ * Parses and validates the json encoded array of the stages. Unlike Parse, fields
+ * of the $sort stages are sorted in the order they are written in the json.
+ *
+ * Ex: aggregation.ParseJSON([]byte(`[{"$sort": {"country": 1, "total": -1}}]`))
+ */
+func ParseJSON(data []byte) (pipeline Pipeline, err *utils.Error) {
+
+	var value interface{}
+	if decodeErr := json.Unmarshal(data, &value); decodeErr != nil {
+		err = invalid("Pipeline must be a json array.")
+		return
+	}
+	if pipeline, err = Parse(value); err != nil {
+		return
+	}
+
+	// stages are validated, so they are objects with a single operator
+	var stages []map[string]json.RawMessage
+	json.Unmarshal(data, &stages)
+	for i := range pipeline {
+		if pipeline[i].Operator == "$sort" {
+			pipeline[i].Keys = objectKeys(stages[i]["$sort"])
+		}
+	}
+	return
+}
+
+// returns the keys of the json object in their order
+func objectKeys(data []byte) (keys []string) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, tokenErr := decoder.Token(); tokenErr != nil {
+		return
+	}
+	for decoder.More() {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			return
+		}
+		keys = append(keys, token.(string))
+
+		var value json.RawMessage
+		if decodeErr := decoder.Decode(&value); decodeErr != nil {
+			return
+		}
+	}
+	return
+}
+
+func validateStage(operator string, value interface{}) (err *utils.Error) {
+
+	switch operator {
+	case "$skip", "$limit":
+		if number, isNumber := toFloat(value); !isNumber || number < 0 || number != float64(int(number)) {
+			err = invalid("Value of '" + operator + "' must be a non negative integer.")
+		}
+	case "$group":
+		group, isObject := value.(map[string]interface{})
+		if !isObject {
+			return invalid("Value of '$group' must be an object.")
+		}
+		if _, hasID := group["_id"]; !hasID {
+			return invalid("'$group' must have the '_id' field.")
+		}
+		for field, accumulator := range group {
+			if field == "_id" {
+				continue
+			}
+			spec, isObject := accumulator.(map[string]interface{})
+			if !isObject || len(spec) != 1 {
+				return invalid("Accumulator of '" + field + "' must be an object with a single operator.")
+			}
+			for accumulatorOperator := range spec {
+				if !accumulators[accumulatorOperator] {
+					return invalid("Accumulator '" + accumulatorOperator + "' is not supported.")
+				}
+			}
+		}
+	case "$sort":
+		sort, isObject := value.(map[string]interface{})
+		if !isObject {
+			return invalid("Value of '$sort' must be an object.")
+		}
+		for field, direction := range sort {
+			if number, _ := toFloat(direction); number != 1 && number != -1 {
+				return invalid("Sort direction of '" + field + "' must be 1 or -1.")
+			}
+		}
+	default:
+		if _, isObject := value.(map[string]interface{}); !isObject {
+			err = invalid("Value of '" + operator + "' must be an object.")
+		}
+	}
+	return
+}
+
+/**
+ * Executes the pipeline on the collection. Leading $match stages are passed to the
+ * provider as the 'where' parameter and the rest is evaluated in process if the
+ * provider can't execute the pipelines.
+ */
+func Aggregate(db dataprovider.Provider, collection string, pipeline Pipeline) (results []map[string]interface{}, err *utils.Error) {
+
+	if aggregator, isAggregator := db.(Aggregator); isAggregator {
+		return aggregator.Aggregate(collection, pipeline)
+	}
+
+	// leading matches are combined into a single filter
+	var filters []interface{}
+	remaining := pipeline
+	for len(remaining) > 0 && remaining[0].Operator == "$match" {
+		filters = append(filters, remaining[0].Value)
+		remaining = remaining[1:]
+	}
+
+	parameters := make(map[string][]string)
+	if len(filters) == 1 {
+		where, _ := json.Marshal(filters[0])
+		parameters["where"] = []string{string(where)}
+	} else if len(filters) > 1 {
+		where, _ := json.Marshal(map[string]interface{}{"$and": filters})
+		parameters["where"] = []string{string(where)}
+	}
+
+	documents, exceeded, err := dataprovider.QueryAll(db, collection, parameters, QueryPageSize, MaxDocuments)
+	if err != nil {
+		return
+	}
+	if exceeded {
+		err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Collection has too many documents to aggregate."}
+		return
+	}
+	return Evaluate(documents, remaining)
+}
+
+func invalid(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusBadRequest, Message: message}
+}
@@ -0,0 +1,114 @@
+package aggregation
+
+import (
+	"testing"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type nativeProvider struct {
+	dataprovider.Provider
+	pipelines []Pipeline
+}
+
+func (p *nativeProvider) Aggregate(collection string, pipeline Pipeline) (results []map[string]interface{}, err *utils.Error) {
+	p.pipelines = append(p.pipelines, pipeline)
+	return []map[string]interface{}{{"_id": "native"}}, nil
+}
+
+func parse(value string) (Pipeline, *utils.Error) {
+	var decoded interface{}
+	json.Unmarshal([]byte(value), &decoded)
+	return Parse(decoded)
+}
+
+func TestAggregation(t *testing.T) {
+
+	orders := []map[string]interface{}{
+		{"_id": "1", "country": "TR", "amount": 10.0, "tax": 1.0, "status": "paid", "customer": map[string]interface{}{"name": "alice"}},
+		{"_id": "2", "country": "DE", "amount": 30.0, "tax": 3.0, "status": "paid", "customer": map[string]interface{}{"name": "bob"}},
+		{"_id": "3", "country": "TR", "amount": 20.0, "tax": 2.0, "status": "paid", "customer": map[string]interface{}{"name": "carol"}},
+		{"_id": "4", "country": "TR", "amount": 50.0, "tax": 5.0, "status": "refunded"},
+	}
+
+	evaluate := func(value string) []map[string]interface{} {
+		pipeline, err := parse(value)
+		So(err, ShouldBeNil)
+		results, err := Evaluate(orders, pipeline)
+		So(err, ShouldBeNil)
+		return results
+	}
+
+	Convey("Matched documents should be grouped with the accumulators", t, func() {
+		results := evaluate(`[
+			{"$match": {"status": "paid"}},
+			{"$group": {"_id": "$country", "total": {"$sum": "$amount"}, "orders": {"$count": {}}, "average": {"$avg": "$amount"}, "largest": {"$max": "$amount"}, "names": {"$push": "$customer.name"}}},
+			{"$sort": {"total": -1}}
+		]`)
+
+		So(results, ShouldHaveLength, 2)
+		So(results[0]["_id"], ShouldEqual, "TR")
+		So(results[0]["total"], ShouldEqual, 30)
+		So(results[0]["orders"], ShouldEqual, 2)
+		So(results[0]["average"], ShouldEqual, 15)
+		So(results[0]["largest"], ShouldEqual, 20)
+		So(results[0]["names"], ShouldResemble, []interface{}{"alice", "carol"})
+		So(results[1]["_id"], ShouldEqual, "DE")
+	})
+
+	Convey("Computed fields, projections and paging should be applied in order", t, func() {
+		results := evaluate(`[
+			{"$addFields": {"net": {"$subtract": ["$amount", "$tax"]}}},
+			{"$match": {"net": {"$gte": 9}, "$or": [{"country": "DE"}, {"status": {"$in": ["refunded"]}}]}},
+			{"$sort": {"net": 1}},
+			{"$skip": 1},
+			{"$limit": 1},
+			{"$project": {"net": 1, "label": {"$concat": ["$country", "-", "$status"]}}}
+		]`)
+
+		So(results, ShouldHaveLength, 1)
+		So(results[0], ShouldResemble, map[string]interface{}{"_id": "4", "net": 45.0, "label": "TR-refunded"})
+	})
+
+	Convey("Fields of the json sorts should be sorted in their order", t, func() {
+		pipeline, err := ParseJSON([]byte(`[{"$sort": {"status": 1, "amount": -1}}]`))
+		So(err, ShouldBeNil)
+		So(pipeline[0].Keys, ShouldResemble, []string{"status", "amount"})
+
+		results, _ := Evaluate(orders, pipeline)
+		ids := make([]interface{}, len(results))
+		for i, result := range results {
+			ids[i] = result["_id"]
+		}
+		So(ids, ShouldResemble, []interface{}{"2", "3", "1", "4"})
+	})
+
+	Convey("Invalid pipelines should be rejected", t, func() {
+		for _, value := range []string{
+			`{"$match": {}}`,
+			`[{"$lookup": {}}]`,
+			`[{"$match": {}, "$sort": {}}]`,
+			`[{"$limit": -1}]`,
+			`[{"$group": {"total": {"$sum": 1}}}]`,
+			`[{"$group": {"_id": null, "total": {"$median": "$amount"}}}]`,
+			`[{"$sort": {"amount": 2}}]`,
+		} {
+			_, err := parse(value)
+			So(err, ShouldNotBeNil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		}
+	})
+
+	Convey("Pipelines should be passed to the providers executing them natively", t, func() {
+		provider := &nativeProvider{}
+		pipeline, _ := parse(`[{"$match": {"status": "paid"}}]`)
+		results, err := Aggregate(provider, "orders", pipeline)
+
+		So(err, ShouldBeNil)
+		So(results[0]["_id"], ShouldEqual, "native")
+		So(provider.pipelines, ShouldHaveLength, 1)
+	})
+}
@@ -0,0 +1,250 @@
+package aggregation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"github.com/rihtim/core/utils"
+)
+
+var accumulators = map[string]bool{
+	"$sum":   true,
+	"$avg":   true,
+	"$min":   true,
+	"$max":   true,
+	"$count": true,
+	"$first": true,
+	"$last":  true,
+	"$push":  true,
+}
+
+// evaluates the pipeline on the documents in process
+func Evaluate(documents []map[string]interface{}, pipeline Pipeline) (results []map[string]interface{}, err *utils.Error) {
+
+	results = documents
+	for _, stage := range pipeline {
+		switch stage.Operator {
+		case "$match":
+			filter := stage.Value.(map[string]interface{})
+			matched := make([]map[string]interface{}, 0, len(results))
+			for _, document := range results {
+				if Matches(document, filter) {
+					matched = append(matched, document)
+				}
+			}
+			results = matched
+		case "$group":
+			results = group(results, stage.Value.(map[string]interface{}))
+		case "$sort":
+			results = sortDocuments(results, stage.Value.(map[string]interface{}), stage.Keys)
+		case "$skip":
+			skip, _ := toFloat(stage.Value)
+			if int(skip) >= len(results) {
+				results = make([]map[string]interface{}, 0)
+			} else {
+				results = results[int(skip):]
+			}
+		case "$limit":
+			limit, _ := toFloat(stage.Value)
+			if int(limit) < len(results) {
+				results = results[:int(limit)]
+			}
+		case "$project":
+			results = project(results, stage.Value.(map[string]interface{}))
+		case "$addFields":
+			fields := stage.Value.(map[string]interface{})
+			added := make([]map[string]interface{}, len(results))
+			for i, document := range results {
+				added[i] = make(map[string]interface{}, len(document)+len(fields))
+				for key, value := range document {
+					added[i][key] = value
+				}
+				for field, expression := range fields {
+					added[i][field] = evaluate(document, expression)
+				}
+			}
+			results = added
+		}
+	}
+	return
+}
+
+type groupState struct {
+	id     interface{}
+	values map[string]interface{}
+	counts map[string]int
+}
+
+func group(documents []map[string]interface{}, spec map[string]interface{}) (results []map[string]interface{}) {
+
+	fields := make([]string, 0, len(spec))
+	for field := range spec {
+		if field != "_id" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	order := make([]string, 0)
+	groups := make(map[string]*groupState)
+
+	for _, document := range documents {
+		id := evaluate(document, spec["_id"])
+		key := fmt.Sprintf("%#v", normalize(id))
+		state, exists := groups[key]
+		if !exists {
+			state = &groupState{id: id, values: make(map[string]interface{}), counts: make(map[string]int)}
+			groups[key] = state
+			order = append(order, key)
+		}
+
+		for _, field := range fields {
+			for operator, expression := range spec[field].(map[string]interface{}) {
+				accumulate(state, field, operator, evaluate(document, expression))
+			}
+		}
+	}
+
+	results = make([]map[string]interface{}, 0, len(order))
+	for _, key := range order {
+		state := groups[key]
+		result := map[string]interface{}{"_id": state.id}
+		for _, field := range fields {
+			for operator := range spec[field].(map[string]interface{}) {
+				result[field] = state.values[field]
+				if operator == "$avg" {
+					if state.counts[field] == 0 {
+						result[field] = nil
+					} else {
+						sum, _ := toFloat(state.values[field])
+						result[field] = sum / float64(state.counts[field])
+					}
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+func accumulate(state *groupState, field, operator string, value interface{}) {
+
+	current, initialized := state.values[field]
+	switch operator {
+	case "$sum", "$avg":
+		number, isNumber := toFloat(value)
+		sum, _ := toFloat(current)
+		if isNumber {
+			sum += number
+			state.counts[field]++
+		}
+		state.values[field] = sum
+	case "$count":
+		count, _ := toFloat(current)
+		state.values[field] = count + 1
+	case "$min":
+		if value != nil && (!initialized || current == nil || compare(value, current) < 0) {
+			state.values[field] = value
+		} else if !initialized {
+			state.values[field] = nil
+		}
+	case "$max":
+		if value != nil && (!initialized || current == nil || compare(value, current) > 0) {
+			state.values[field] = value
+		} else if !initialized {
+			state.values[field] = nil
+		}
+	case "$first":
+		if !initialized {
+			state.values[field] = value
+		}
+	case "$last":
+		state.values[field] = value
+	case "$push":
+		list, _ := current.([]interface{})
+		state.values[field] = append(list, value)
+	}
+}
+
+func sortDocuments(documents []map[string]interface{}, spec map[string]interface{}, keys []string) []map[string]interface{} {
+
+	// fields are sorted in the order of the keys if the order in the json is unknown
+	fields := keys
+	if len(fields) != len(spec) {
+		fields = make([]string, 0, len(spec))
+		for field := range spec {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+
+	sorted := make([]map[string]interface{}, len(documents))
+	copy(sorted, documents)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for _, field := range fields {
+			direction, _ := toFloat(spec[field])
+			comparison := compare(lookup(sorted[i], field), lookup(sorted[j], field))
+			if comparison != 0 {
+				return comparison*int(direction) < 0
+			}
+		}
+		return false
+	})
+	return sorted
+}
+
+func project(documents []map[string]interface{}, spec map[string]interface{}) []map[string]interface{} {
+
+	// projection excludes the fields if all the fields except _id are 0
+	exclusion := true
+	for field, value := range spec {
+		if number, isNumber := toFloat(value); field != "_id" && !(isNumber && number == 0) {
+			exclusion = false
+		}
+	}
+
+	projected := make([]map[string]interface{}, len(documents))
+	for i, document := range documents {
+		result := make(map[string]interface{})
+		if exclusion {
+			for key, value := range document {
+				result[key] = value
+			}
+			for field := range spec {
+				delete(result, field)
+			}
+		} else {
+			if id, hasID := document["_id"]; hasID {
+				result["_id"] = id
+			}
+			for field, value := range spec {
+				number, isNumber := toFloat(value)
+				switch {
+				case isNumber && number == 0:
+					delete(result, field)
+				case isNumber || value == true:
+					if fieldValue := lookup(document, field); fieldValue != nil {
+						result[field] = fieldValue
+					}
+				default:
+					result[field] = evaluate(document, value)
+				}
+			}
+		}
+		projected[i] = result
+	}
+	return projected
+}
+
+// returns the value of the dotted field path
+func lookup(document map[string]interface{}, path string) interface{} {
+	var current interface{} = document
+	for _, part := range strings.Split(path, ".") {
+		object, isObject := current.(map[string]interface{})
+		if !isObject {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
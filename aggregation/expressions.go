@@ -0,0 +1,284 @@
+package aggregation
+
+import (
+	"fmt"
+	"strings"
+	"encoding/json"
+)
+
+/**
+ * Evaluates an expression on the document. Strings starting with '$' are field
+ * references and the objects with a single operator are arithmetic expressions.
+ *
+ * Ex: "$amount", {"$multiply": ["$price", "$quantity"]}, {"$concat": ["$first", " ", "$last"]}
+ */
+func evaluate(document map[string]interface{}, expression interface{}) interface{} {
+
+	switch value := expression.(type) {
+	case string:
+		if strings.HasPrefix(value, "$") {
+			return lookup(document, value[1:])
+		}
+		return value
+	case map[string]interface{}:
+		if len(value) == 1 {
+			for operator, operands := range value {
+				if strings.HasPrefix(operator, "$") {
+					return operate(document, operator, operands)
+				}
+			}
+		}
+		evaluated := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			evaluated[key] = evaluate(document, field)
+		}
+		return evaluated
+	case []interface{}:
+		evaluated := make([]interface{}, len(value))
+		for i, item := range value {
+			evaluated[i] = evaluate(document, item)
+		}
+		return evaluated
+	}
+	return expression
+}
+
+func operate(document map[string]interface{}, operator string, operands interface{}) interface{} {
+
+	list, isList := operands.([]interface{})
+	if !isList {
+		list = []interface{}{operands}
+	}
+	values := make([]interface{}, len(list))
+	for i, operand := range list {
+		values[i] = evaluate(document, operand)
+	}
+
+	if operator == "$concat" {
+		var builder strings.Builder
+		for _, value := range values {
+			if value == nil {
+				return nil
+			}
+			builder.WriteString(fmt.Sprint(value))
+		}
+		return builder.String()
+	}
+
+	numbers := make([]float64, len(values))
+	for i, value := range values {
+		number, isNumber := toFloat(value)
+		if !isNumber {
+			return nil
+		}
+		numbers[i] = number
+	}
+
+	switch operator {
+	case "$add", "$multiply":
+		result := 0.0
+		if operator == "$multiply" {
+			result = 1
+		}
+		for _, number := range numbers {
+			if operator == "$add" {
+				result += number
+			} else {
+				result *= number
+			}
+		}
+		return result
+	case "$subtract", "$divide":
+		if len(numbers) != 2 {
+			return nil
+		}
+		if operator == "$subtract" {
+			return numbers[0] - numbers[1]
+		}
+		if numbers[1] == 0 {
+			return nil
+		}
+		return numbers[0] / numbers[1]
+	}
+	return nil
+}
+
+/**
+ * Checks if the document matches the filter of a $match stage. Filter has the same
+ * format with the 'where' parameter of the queries.
+ *
+ * Ex: {"status": "paid", "amount": {"$gte": 10}, "$or": [{"country": "TR"}, {"country": "DE"}]}
+ */
+func Matches(document map[string]interface{}, filter map[string]interface{}) bool {
+
+	for field, condition := range filter {
+		switch field {
+		case "$and", "$or":
+			filters, _ := condition.([]interface{})
+			matchedAny, matchedAll := false, true
+			for _, rawFilter := range filters {
+				subFilter, _ := rawFilter.(map[string]interface{})
+				if Matches(document, subFilter) {
+					matchedAny = true
+				} else {
+					matchedAll = false
+				}
+			}
+			if (field == "$and" && !matchedAll) || (field == "$or" && !matchedAny) {
+				return false
+			}
+		default:
+			if !matchesCondition(lookup(document, field), condition) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesCondition(value interface{}, condition interface{}) bool {
+
+	operators, isObject := condition.(map[string]interface{})
+	if !isObject || !hasOperators(operators) {
+		return compare(value, condition) == 0
+	}
+
+	for operator, operand := range operators {
+		matched := false
+		switch operator {
+		case "$eq":
+			matched = compare(value, operand) == 0
+		case "$ne":
+			matched = compare(value, operand) != 0
+		case "$gt":
+			matched = value != nil && compare(value, operand) > 0
+		case "$gte":
+			matched = value != nil && compare(value, operand) >= 0
+		case "$lt":
+			matched = value != nil && compare(value, operand) < 0
+		case "$lte":
+			matched = value != nil && compare(value, operand) <= 0
+		case "$in", "$nin":
+			candidates, _ := operand.([]interface{})
+			for _, candidate := range candidates {
+				if compare(value, candidate) == 0 {
+					matched = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			matched = (value != nil) == (operand == true)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func hasOperators(object map[string]interface{}) bool {
+	for key := range object {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// values are ordered by the type first: null < numbers < strings < booleans < others
+func compare(a, b interface{}) int {
+
+	rankA, rankB := rank(a), rank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch rankA {
+	case 1:
+		numberA, _ := toFloat(a)
+		numberB, _ := toFloat(b)
+		if numberA < numberB {
+			return -1
+		} else if numberA > numberB {
+			return 1
+		}
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 3:
+		if a == b {
+			return 0
+		} else if b == true {
+			return -1
+		}
+		return 1
+	case 4:
+		return strings.Compare(fmt.Sprintf("%#v", normalize(a)), fmt.Sprintf("%#v", normalize(b)))
+	}
+	return 0
+}
+
+func rank(value interface{}) int {
+	if value == nil {
+		return 0
+	}
+	if _, isNumber := toFloat(value); isNumber {
+		return 1
+	}
+	switch value.(type) {
+	case string:
+		return 2
+	case bool:
+		return 3
+	}
+	return 4
+}
+
+// converts the numbers to float64 so the same values decoded differently are grouped together
+func normalize(value interface{}) interface{} {
+	if number, isNumber := toFloat(value); isNumber {
+		return number
+	}
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(typed))
+		for key, field := range typed {
+			normalized[key] = normalize(field)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalized[i] = normalize(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+func toFloat(value interface{}) (number float64, isNumber bool) {
+	switch typed := value.(type) {
+	case float64:
+		return typed, true
+	case float32:
+		return float64(typed), true
+	case int:
+		return float64(typed), true
+	case int32:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case uint:
+		return float64(typed), true
+	case uint32:
+		return float64(typed), true
+	case uint64:
+		return float64(typed), true
+	case json.Number:
+		parsed, err := typed.Float64()
+		return parsed, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,346 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+	"reflect"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+)
+
+// request scope key of the objects before the request, set by the BEFORE_EXEC interceptor for the FINAL interceptor
+const SnapshotScopeKey = "auditSnapshots"
+
+// maximum number of the entries returned by the query endpoint
+var QueryMaxLimit = 1000
+
+type Entry struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	Principal  string                 `json:"principal,omitempty"`
+	IP         string                 `json:"ip,omitempty"`
+	Collection string                 `json:"collection,omitempty"`
+	Resource   string                 `json:"resource"`
+	Command    string                 `json:"command"`
+	Status     int                    `json:"status,omitempty"`
+	Before     map[string]interface{} `json:"before,omitempty"`
+	After      map[string]interface{} `json:"after,omitempty"`
+	Changes    map[string]Change      `json:"changes,omitempty"`
+	// body of the function requests
+	Input map[string]interface{} `json:"input,omitempty"`
+}
+
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type Filter struct {
+	Collection string
+	Resource   string
+	Principal  string
+	Command    string
+	From       time.Time
+	To         time.Time
+	Skip       int
+	Limit      int
+}
+
+// marks the extras of the function interceptors
+type functionRule struct{}
+
+/**
+ * Records the successful mutations with the objects before and after the request.
+ * Objects are read before the execution by a BEFORE_EXEC interceptor, the objects after
+ * the request are the ones before updated with the requests and the responses. Entries
+ * are written by a FINAL interceptor, so writing doesn't delay the responses and the
+ * entries of the rolled back transactions are not written.
+ *
+ * Ex: auditor := audit.New(&audit.ProviderSink{Provider: core.DataProvider, Collection: "auditLogs"})
+ *     auditor.PrincipalKey = "userId"
+ *     auditor.Register(core.Interceptors, "/posts")
+ *     auditor.Register(core.Interceptors, "/posts/{id}")
+ *     auditor.RegisterFunction(core.Interceptors, "/functions/transfer", methods.Post)
+ *     core.Functions.Add("/functions/auditLogs", methods.Get, auditor.Query, nil)
+ */
+type Auditor struct {
+	Sink Sink
+	// request scope key of the authenticated user
+	PrincipalKey string
+	// used to get the current time, for testing purposes
+	Now func() time.Time
+	// page size of the queries reading the objects of the bulk operations. 1000 if not set
+	PageSize int
+
+	protected bool
+}
+
+func New(sink Sink) *Auditor {
+	return &Auditor{Sink: sink}
+}
+
+/**
+ * Adds the interceptors for the mutations of the collection or the model path. Requests
+ * of the collection of a ProviderSink are rejected, so the entries can't be read or
+ * rewritten through the collection.
+ */
+func (a *Auditor) Register(controller interceptors.InterceptorController, path string) {
+	for _, method := range []string{methods.Post, methods.Put, methods.Patch, methods.Delete} {
+		controller.Add(path, method, interceptors.BEFORE_EXEC, a.Snapshot, nil)
+		controller.Add(path, method, interceptors.FINAL, a.Record, nil)
+	}
+
+	if sink, isProviderSink := a.Sink.(*ProviderSink); isProviderSink && sink.Collection != "" && !a.protected {
+		a.protected = true
+		for _, sinkPath := range []string{"/" + sink.Collection, "/" + sink.Collection + "/{id}"} {
+			controller.Add(sinkPath, methods.Any, interceptors.BEFORE_EXEC, rejectRequest, nil)
+		}
+	}
+}
+
+func rejectRequest(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+	err = &utils.Error{Code: http.StatusNotFound, Message: "Collection doesn't exist."}
+	return
+}
+
+// adds the interceptor recording the requests of the function with their bodies
+func (a *Auditor) RegisterFunction(controller interceptors.InterceptorController, path, method string) {
+	controller.Add(path, method, interceptors.FINAL, a.Record, functionRule{})
+}
+
+/**
+ * BEFORE_EXEC interceptor reading the objects changed by the request. Objects of the
+ * bulk operations are read by the 'ids' or the 'where' parameter.
+ */
+func (a *Auditor) Snapshot(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	if strings.EqualFold(req.Command, methods.Post) {
+		return
+	}
+
+	parts := strings.Split(req.Res, "/")
+	snapshots := make(map[string]map[string]interface{})
+	if len(parts) == 3 {
+		if object, getErr := dp.Get(parts[1], parts[2]); getErr == nil {
+			snapshots[parts[2]] = object
+		}
+	} else if len(parts) == 2 {
+		if ids, hasIds := req.Parameters["ids"]; hasIds {
+			for _, value := range ids {
+				for _, id := range strings.Split(value, ",") {
+					if id = strings.TrimSpace(id); id == "" {
+						continue
+					}
+					if object, getErr := dp.Get(parts[1], id); getErr == nil {
+						snapshots[id] = object
+					}
+				}
+			}
+		} else if where, hasWhere := req.Parameters["where"]; hasWhere {
+			pageSize := a.PageSize
+			if pageSize <= 0 {
+				pageSize = 1000
+			}
+			if objects, _, queryErr := dataprovider.QueryAll(dp, parts[1], map[string][]string{"where": where}, pageSize, 0); queryErr == nil {
+				for _, object := range objects {
+					snapshots[fmt.Sprint(object[dataprovider.IdField])] = object
+				}
+			}
+		}
+	}
+
+	editedRs = rs.Copy()
+	editedRs.Set(SnapshotScopeKey, snapshots)
+	return
+}
+
+// FINAL interceptor writing the entries of the request
+func (a *Auditor) Record(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	entry := Entry{
+		Timestamp: a.now(),
+		IP:        req.IP,
+		Resource:  req.Res,
+		Command:   strings.ToLower(req.Command),
+		Status:    resp.Status,
+	}
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	if a.PrincipalKey != "" && rs.Get(a.PrincipalKey) != nil {
+		entry.Principal = fmt.Sprint(rs.Get(a.PrincipalKey))
+	}
+
+	if _, isFunction := extras.(functionRule); isFunction {
+		entry.Input = req.Body
+		a.write(entry)
+		return
+	}
+
+	parts := strings.Split(req.Res, "/")
+	entry.Collection = parts[1]
+	snapshots, _ := rs.Get(SnapshotScopeKey).(map[string]map[string]interface{})
+	isPost := strings.EqualFold(req.Command, methods.Post)
+	isDelete := strings.EqualFold(req.Command, methods.Delete)
+
+	// objects after the request are built from the requests and the responses, the objects may be changed again
+	// before FINAL runs. fields of the request body replace the fields of the object and null removes the field
+	after := func(before, body map[string]interface{}) map[string]interface{} {
+		if isDelete {
+			return nil
+		}
+		object := make(map[string]interface{}, len(before)+len(req.Body)+len(body))
+		for key, value := range before {
+			object[key] = value
+		}
+		for key, value := range req.Body {
+			if value == nil {
+				delete(object, key)
+			} else {
+				object[key] = value
+			}
+		}
+		// fields set by the provider. ex: updatedAt
+		for key, value := range body {
+			object[key] = value
+		}
+		return object
+	}
+
+	switch {
+	case len(parts) == 3:
+		entry.Before = snapshots[parts[2]]
+		entry.After = after(entry.Before, resp.Body)
+		a.write(entry)
+	case len(parts) == 2 && isPost && req.Items == nil:
+		entry.After = resp.Body
+		entry.Resource = req.Res + "/" + fmt.Sprint(resp.Body[dataprovider.IdField])
+		a.write(entry)
+	case len(parts) == 2:
+		// bulk operations have an entry for each succeeded item
+		for _, item := range dataprovider.ObjectsOf(resp.Body) {
+			status, _ := item["status"].(int)
+			id, hasID := item[dataprovider.IdField]
+			if !hasID || status >= http.StatusMultipleChoices {
+				continue
+			}
+			itemEntry := entry
+			itemEntry.Resource = req.Res + "/" + fmt.Sprint(id)
+			itemEntry.Status = status
+			itemEntry.Before = snapshots[fmt.Sprint(id)]
+			if isPost {
+				itemEntry.After, _ = item["body"].(map[string]interface{})
+			} else {
+				body, _ := item["body"].(map[string]interface{})
+				itemEntry.After = after(itemEntry.Before, body)
+			}
+			a.write(itemEntry)
+		}
+	default:
+		// sub resources are recorded with the request and the response
+		entry.Input = req.Body
+		entry.After = resp.Body
+		a.write(entry)
+	}
+	return
+}
+
+func (a *Auditor) write(entry Entry) {
+	entry.Changes = Diff(entry.Before, entry.After)
+	if err := a.Sink.Write(entry); err != nil {
+		log.Error("Writing audit entry of '" + entry.Resource + "' failed. Reason: " + err.Message)
+	}
+}
+
+/**
+ * Function returning the entries matching the parameters, newest first. Sink must
+ * implement the Querier interface.
+ *
+ * GET /auditLogs?collection=posts&principal=5&command=put&from=2024-01-01T00:00:00Z&to=...&skip=0&limit=100
+ */
+func (a *Auditor) Query(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	querier, isQuerier := a.Sink.(Querier)
+	if !isQuerier {
+		err = &utils.Error{Code: http.StatusNotImplemented, Message: "Audit sink can't be queried."}
+		return
+	}
+
+	filter := Filter{Limit: 100}
+	filter.Collection, _ = req.GetParameter("collection")
+	filter.Resource, _ = req.GetParameter("resource")
+	filter.Principal, _ = req.GetParameter("principal")
+	filter.Command, _ = req.GetParameter("command")
+	filter.Command = strings.ToLower(filter.Command)
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value, hasValue := req.GetParameter(name); hasValue {
+			parsed, parseErr := time.Parse(time.RFC3339, value)
+			if parseErr != nil {
+				err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter '" + name + "' must be an RFC 3339 time."}
+				return
+			}
+			*target = parsed
+		}
+	}
+	for name, target := range map[string]*int{"skip": &filter.Skip, "limit": &filter.Limit} {
+		if value, hasValue, parseErr := req.GetIntParameter(name); parseErr != nil || (hasValue && value < 0) {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter '" + name + "' must be a non negative number."}
+			return
+		} else if hasValue {
+			*target = value
+		}
+	}
+	if filter.Limit == 0 || filter.Limit > QueryMaxLimit {
+		filter.Limit = QueryMaxLimit
+	}
+
+	entries, err := querier.Query(filter)
+	if err != nil {
+		return
+	}
+	results := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		results[i] = entry
+	}
+	resp.Body = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
+
+/**
+ * Returns the fields changed between the objects. Nested objects are compared as a
+ * whole.
+ *
+ * Ex: Diff({"title": "a", "draft": true}, {"title": "b", "draft": true}) => {"title": {"before": "a", "after": "b"}}
+ */
+func Diff(before, after map[string]interface{}) (changes map[string]Change) {
+
+	changes = make(map[string]Change)
+	for field, value := range before {
+		if afterValue, exists := after[field]; !exists || !reflect.DeepEqual(value, afterValue) {
+			changes[field] = Change{Before: value, After: afterValue}
+		}
+	}
+	for field, value := range after {
+		if _, exists := before[field]; !exists {
+			changes[field] = Change{After: value}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return
+}
+
+func (a *Auditor) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+	return time.Now()
+}
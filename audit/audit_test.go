@@ -0,0 +1,232 @@
+package audit
+
+import (
+	"sort"
+	"time"
+	"strconv"
+	"testing"
+	"net/http"
+	"path/filepath"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type objectProvider struct {
+	dataprovider.Provider
+	objects    map[string]map[string]interface{}
+	created    []map[string]interface{}
+	parameters map[string][]string
+}
+
+func (p *objectProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	object, exists := p.objects[id]
+	if !exists {
+		return nil, &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+	}
+	response = make(map[string]interface{})
+	for key, value := range object {
+		response[key] = value
+	}
+	return
+}
+
+func (p *objectProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	p.created = append(p.created, data)
+	return data, nil
+}
+
+func (p *objectProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	p.parameters = parameters
+	results := make([]interface{}, len(p.created))
+	for i, object := range p.created {
+		results[i] = object
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+// provider querying the objects ordered by id with the skip and limit parameters
+type pagedProvider struct {
+	*objectProvider
+	queries int
+}
+
+func (p *pagedProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	p.queries++
+	ids := make([]string, 0, len(p.objects))
+	for id := range p.objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	skip, _ := strconv.Atoi(parameters["skip"][0])
+	limit, _ := strconv.Atoi(parameters["limit"][0])
+	results := make([]interface{}, 0)
+	for i := skip; i < len(ids) && i < skip+limit; i++ {
+		results = append(results, p.objects[ids[i]])
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+type recordingSink struct {
+	entries []Entry
+}
+
+func (s *recordingSink) Write(entry Entry) (err *utils.Error) {
+	s.entries = append(s.entries, entry)
+	return
+}
+
+func TestAuditor(t *testing.T) {
+
+	Convey("Given an auditor", t, func() {
+		provider := &objectProvider{objects: map[string]map[string]interface{}{
+			"1": {"_id": "1", "title": "first", "draft": true},
+			"2": {"_id": "2", "title": "second"},
+		}}
+		sink := &recordingSink{}
+		auditor := New(sink)
+		auditor.PrincipalKey = "userId"
+		auditor.Now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+		rs := requestscope.Init()
+		rs.Set("userId", 5)
+
+		execute := func(req messages.Message, resp messages.Message, update func()) {
+			_, _, editedRs, err := auditor.Snapshot(rs, nil, req, messages.Message{}, provider)
+			So(err, ShouldBeNil)
+			if update != nil {
+				update()
+			}
+			auditor.Record(editedRs, nil, req, resp, provider)
+		}
+
+		Convey("Updates should be recorded with the changes", func() {
+			req := messages.Message{Res: "/posts/1", Command: methods.Put, IP: "10.0.0.1", Body: map[string]interface{}{"title": "changed", "draft": nil}}
+			execute(req, messages.Message{Body: map[string]interface{}{"updatedAt": "2024-01-01"}}, func() {
+				// changes after the request are not recorded
+				provider.objects["1"]["title"] = "changed again"
+			})
+
+			So(sink.entries, ShouldHaveLength, 1)
+			entry := sink.entries[0]
+			So(entry.Principal, ShouldEqual, "5")
+			So(entry.IP, ShouldEqual, "10.0.0.1")
+			So(entry.Collection, ShouldEqual, "posts")
+			So(entry.Command, ShouldEqual, "put")
+			So(entry.Before["title"], ShouldEqual, "first")
+			So(entry.After["title"], ShouldEqual, "changed")
+			So(entry.Changes, ShouldResemble, map[string]Change{
+				"title":     {Before: "first", After: "changed"},
+				"draft":     {Before: true},
+				"updatedAt": {After: "2024-01-01"},
+			})
+		})
+
+		Convey("Objects matching the filter of the bulk operations should be read page by page", func() {
+			paged := &pagedProvider{objectProvider: provider}
+			auditor.PageSize = 1
+			req := messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"where": {`{}`}}}
+			_, _, editedRs, err := auditor.Snapshot(rs, nil, req, messages.Message{}, paged)
+			So(err, ShouldBeNil)
+			So(editedRs.Get(SnapshotScopeKey), ShouldHaveLength, 2)
+			So(paged.queries, ShouldEqual, 3)
+		})
+
+		Convey("Collection of the provider sink should be hidden", func() {
+			controller := &interceptors.CoreInterceptorController{}
+			New(&ProviderSink{Provider: provider, Collection: "auditLogs"}).Register(controller, "/posts")
+			for _, res := range []string{"/auditLogs", "/auditLogs/1"} {
+				_, _, _, err := controller.Execute(res, methods.Put, interceptors.BEFORE_EXEC, rs, messages.Message{Res: res, Command: methods.Put}, messages.Message{}, provider)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			}
+		})
+
+		Convey("Creates should be recorded with the created object", func() {
+			req := messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "new"}}
+			execute(req, messages.Message{Status: http.StatusCreated, Body: map[string]interface{}{"_id": "3", "title": "new"}}, nil)
+
+			So(sink.entries[0].Resource, ShouldEqual, "/posts/3")
+			So(sink.entries[0].Status, ShouldEqual, http.StatusCreated)
+			So(sink.entries[0].Changes["title"], ShouldResemble, Change{After: "new"})
+		})
+
+		Convey("Bulk deletes should be recorded for each deleted object", func() {
+			req := messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"ids": {"1,2,9"}}}
+			resp := messages.Message{Body: map[string]interface{}{"results": []interface{}{
+				map[string]interface{}{"_id": "1", "status": 200},
+				map[string]interface{}{"_id": "2", "status": 200},
+				map[string]interface{}{"_id": "9", "status": 404},
+			}}}
+			execute(req, resp, func() { provider.objects = map[string]map[string]interface{}{} })
+
+			So(sink.entries, ShouldHaveLength, 2)
+			So(sink.entries[1].Resource, ShouldEqual, "/posts/2")
+			So(sink.entries[1].Before["title"], ShouldEqual, "second")
+			So(sink.entries[1].After, ShouldBeNil)
+		})
+
+		Convey("Functions should be recorded with the request body", func() {
+			req := messages.Message{Res: "/functions/transfer", Command: methods.Post, Body: map[string]interface{}{"amount": 10}}
+			auditor.Record(rs, functionRule{}, req, messages.Message{}, provider)
+
+			So(sink.entries[0].Input, ShouldResemble, map[string]interface{}{"amount": 10})
+			So(sink.entries[0].Changes, ShouldBeNil)
+		})
+	})
+}
+
+func TestSinks(t *testing.T) {
+
+	entries := []Entry{
+		{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Collection: "posts", Resource: "/posts/1", Command: "put", Principal: "5"},
+		{Timestamp: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Collection: "posts", Resource: "/posts/2", Command: "delete", Principal: "6"},
+		{Timestamp: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Collection: "users", Resource: "/users/1", Command: "put", Principal: "5"},
+	}
+
+	query := func(sink Sink, parameters map[string][]string) ([]interface{}, *utils.Error) {
+		for _, entry := range entries {
+			So(sink.Write(entry), ShouldBeNil)
+		}
+		resp, _, err := New(sink).Query(messages.Message{Parameters: parameters}, requestscope.Init(), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body["results"].([]interface{}), nil
+	}
+
+	Convey("File sink should return the matching entries newest first", t, func() {
+		sink, err := NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+		So(err, ShouldBeNil)
+		defer sink.Close()
+
+		results, _ := query(sink, map[string][]string{"principal": {"5"}})
+		So(results, ShouldHaveLength, 2)
+		So(results[0].(Entry).Resource, ShouldEqual, "/users/1")
+
+		results, _ = query(sink, map[string][]string{"collection": {"posts"}, "from": {"2024-01-02T00:00:00Z"}})
+		So(results, ShouldHaveLength, 2)
+	})
+
+	Convey("Provider sink should store the entries in the collection", t, func() {
+		provider := &objectProvider{}
+		results, _ := query(&ProviderSink{Provider: provider, Collection: "auditLogs"}, map[string][]string{"command": {"PUT"}, "limit": {"1"}})
+
+		So(provider.created[0]["timestamp"], ShouldEqual, "2024-01-01T00:00:00.000Z")
+		So(results, ShouldHaveLength, 1)
+		So(results[0].(Entry).Resource, ShouldEqual, "/users/1")
+		So(provider.parameters["sort"], ShouldResemble, []string{"-timestamp"})
+		So(provider.parameters["limit"], ShouldResemble, []string{"1"})
+	})
+
+	Convey("Invalid parameters and sinks without query should be rejected", t, func() {
+		_, err := query(&ProviderSink{Provider: &objectProvider{}}, map[string][]string{"from": {"yesterday"}})
+		So(err.Code, ShouldEqual, http.StatusBadRequest)
+
+		_, err = query(&recordingSink{}, nil)
+		So(err.Code, ShouldEqual, http.StatusNotImplemented)
+	})
+}
@@ -0,0 +1,226 @@
+package audit
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"bufio"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// destination of the audit entries
+type Sink interface {
+	Write(entry Entry) (err *utils.Error)
+}
+
+// optional interface for the sinks that can be queried by the query endpoint
+type Querier interface {
+	Query(filter Filter) (entries []Entry, err *utils.Error)
+}
+
+/**
+ * Writes the entries to a collection of the data provider. Timestamps are stored in
+ * a fixed width format, so they can be compared as strings by the providers. Queries
+ * are filtered, sorted and paged by the provider.
+ *
+ * Ex: audit.New(&audit.ProviderSink{Provider: core.DataProvider, Collection: "auditLogs"})
+ */
+type ProviderSink struct {
+	Provider   dataprovider.Provider
+	Collection string
+}
+
+const timestampLayout = "2006-01-02T15:04:05.000Z"
+
+func (s *ProviderSink) Write(entry Entry) (err *utils.Error) {
+
+	encoded, encodeErr := json.Marshal(entry)
+	if encodeErr != nil {
+		return &utils.Error{Code: http.StatusInternalServerError, Message: "Encoding audit entry failed. Reason: " + encodeErr.Error()}
+	}
+	var object map[string]interface{}
+	json.Unmarshal(encoded, &object)
+	object["timestamp"] = entry.Timestamp.UTC().Format(timestampLayout)
+
+	_, err = s.Provider.Create(s.Collection, object)
+	return
+}
+
+func (s *ProviderSink) Query(filter Filter) (entries []Entry, err *utils.Error) {
+
+	where := make(map[string]interface{})
+	if filter.Collection != "" {
+		where["collection"] = filter.Collection
+	}
+	if filter.Resource != "" {
+		where["resource"] = filter.Resource
+	}
+	if filter.Principal != "" {
+		where["principal"] = filter.Principal
+	}
+	if filter.Command != "" {
+		where["command"] = filter.Command
+	}
+	timestamp := make(map[string]interface{})
+	if !filter.From.IsZero() {
+		timestamp["$gte"] = filter.From.UTC().Format(timestampLayout)
+	}
+	if !filter.To.IsZero() {
+		timestamp["$lt"] = filter.To.UTC().Format(timestampLayout)
+	}
+	if len(timestamp) > 0 {
+		where["timestamp"] = timestamp
+	}
+	encoded, _ := json.Marshal(where)
+
+	parameters := map[string][]string{
+		"where":                    {string(encoded)},
+		dataprovider.SortParameter: {"-timestamp"},
+		"skip":                     {strconv.Itoa(filter.Skip)},
+	}
+	if filter.Limit > 0 {
+		parameters["limit"] = []string{strconv.Itoa(filter.Limit)}
+	}
+	response, err := s.Provider.Query(s.Collection, parameters)
+	if err != nil {
+		return
+	}
+
+	results := dataprovider.ObjectsOf(response)
+	matched := make([]Entry, 0, len(results))
+	for _, result := range results {
+		var entry Entry
+		if decodeErr := dataprovider.FromObject(result, &entry); decodeErr != nil {
+			continue
+		}
+		// entries are checked again for the providers ignoring the filter
+		if filter.Matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+
+	// entries are paged again only if the provider ignored the limit
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		return filter.page(matched), nil
+	}
+	sortEntries(matched)
+	return matched, nil
+}
+
+/**
+ * Appends the entries to a file as json lines. Query reads the whole file, so it's
+ * suitable for the small logs and the development.
+ *
+ * Ex: sink, err := audit.NewFileSink("/var/log/app/audit.jsonl")
+ */
+type FileSink struct {
+	path string
+	lock sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (sink *FileSink, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return
+	}
+	return &FileSink{path: path, file: file}, nil
+}
+
+func (s *FileSink) Write(entry Entry) (err *utils.Error) {
+
+	encoded, encodeErr := json.Marshal(entry)
+	if encodeErr != nil {
+		return &utils.Error{Code: http.StatusInternalServerError, Message: "Encoding audit entry failed. Reason: " + encodeErr.Error()}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, writeErr := s.file.Write(append(encoded, '\n')); writeErr != nil {
+		return &utils.Error{Code: http.StatusInternalServerError, Message: "Writing audit entry failed. Reason: " + writeErr.Error()}
+	}
+	return
+}
+
+func (s *FileSink) Query(filter Filter) (entries []Entry, err *utils.Error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, openErr := os.Open(s.path)
+	if openErr != nil {
+		return nil, &utils.Error{Code: http.StatusInternalServerError, Message: "Reading audit log failed. Reason: " + openErr.Error()}
+	}
+	defer file.Close()
+
+	entries = make([]Entry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if decodeErr := json.Unmarshal(scanner.Bytes(), &entry); decodeErr != nil {
+			continue
+		}
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		return nil, &utils.Error{Code: http.StatusInternalServerError, Message: "Reading audit log failed. Reason: " + scanErr.Error()}
+	}
+	return filter.page(entries), nil
+}
+
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// returns the entries in the page, newest first
+func (f Filter) page(entries []Entry) []Entry {
+
+	sortEntries(entries)
+
+	if f.Skip >= len(entries) {
+		return make([]Entry, 0)
+	}
+	entries = entries[f.Skip:]
+	if f.Limit > 0 && f.Limit < len(entries) {
+		entries = entries[:f.Limit]
+	}
+	return entries
+}
+
+func sortEntries(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+}
+
+func (f Filter) Matches(entry Entry) bool {
+	if f.Collection != "" && entry.Collection != f.Collection {
+		return false
+	}
+	if f.Resource != "" && entry.Resource != f.Resource {
+		return false
+	}
+	if f.Principal != "" && entry.Principal != f.Principal {
+		return false
+	}
+	if f.Command != "" && !strings.EqualFold(entry.Command, f.Command) {
+		return false
+	}
+	if !f.From.IsZero() && entry.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Timestamp.Before(f.To) {
+		return false
+	}
+	return true
+}
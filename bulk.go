@@ -0,0 +1,181 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// maximum number of the objects in a bulk request
+var BulkMaxItems = 1000
+
+var bulkCollections = make(map[string]bool)
+
+/**
+ * Allows the POST requests with array bodies, the PATCH and the DELETE requests on the
+ * collection. Bulk requests change many objects on the collection path, bypassing the
+ * interceptors of the model paths, so they are allowed only for the collections
+ * enabled explicitly.
+ *
+ * Ex: core.EnableBulk("orders")
+ */
+func EnableBulk(collection string) {
+	bulkCollections[collection] = true
+}
+
+// returns true if the method is a bulk method enabled for the collection of the resource
+func isBulkAllowed(res, method string) bool {
+	if !strings.EqualFold(method, methods.Post) && !strings.EqualFold(method, methods.Patch) && !strings.EqualFold(method, methods.Delete) {
+		return false
+	}
+	parts := strings.Split(res, "/")
+	return bulkCollections[parts[len(parts)-1]]
+}
+
+/**
+ * Bulk operations on the collections. Response contains the result of each item
+ * in order. Status is 207 if any of the items fails.
+ *
+ * POST   /posts                     creates the objects in the array body
+ * PATCH  /posts?ids=1,2             updates the objects with the body
+ * PATCH  /posts?where={"draft":true}
+ * DELETE /posts?ids=1,2             deletes the objects
+ *
+ * Ex: {"results": [{"_id": "1", "status": 200, "body": {...}}, {"_id": "2", "status": 404, "message": "..."}], "succeeded": 1, "failed": 1}
+ */
+var handleBulkCreate = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	if err = checkBulkSize(len(request.Items)); err != nil {
+		return
+	}
+
+	results, err := dataprovider.CreateMany(db, class, request.Items)
+	if err == nil {
+		response = bulkResponse(results, http.StatusCreated)
+	}
+	return
+}
+
+var handleBulkUpdate = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	if request.Body == nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Request body must be an object."}
+		return
+	}
+
+	class := strings.Split(request.Res, "/")[1]
+	ids, err := bulkTargets(request, class, db)
+	if err != nil {
+		return
+	}
+
+	results, err := dataprovider.UpdateMany(db, class, ids, request.Body)
+	if err == nil {
+		response = bulkResponse(results, http.StatusOK)
+	}
+	return
+}
+
+var handleBulkDelete = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	ids, err := bulkTargets(request, class, db)
+	if err != nil {
+		return
+	}
+
+	var results []dataprovider.BulkResult
+	if isSoftDeleted(class) {
+		results, err = dataprovider.UpdateMany(db, class, ids, deletionMark())
+	} else {
+		results, err = dataprovider.DeleteMany(db, class, ids)
+	}
+	if err == nil {
+		response = bulkResponse(results, http.StatusOK)
+	}
+	return
+}
+
+// returns the ids in the 'ids' parameter or the ids of the objects matching the 'where' parameter
+func bulkTargets(request messages.Message, class string, db dataprovider.Provider) (ids []string, err *utils.Error) {
+
+	if values, hasIds := request.Parameters["ids"]; hasIds {
+		ids = make([]string, 0)
+		for _, value := range values {
+			for _, id := range strings.Split(value, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+	} else if where, hasWhere := request.Parameters["where"]; hasWhere {
+		// all the matching objects are read, the request is rejected if they don't fit in a bulk request
+		objects, exceeded, queryErr := queryAll(db, class, map[string][]string{"where": where}, BulkMaxItems)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		if exceeded {
+			err = checkBulkSize(BulkMaxItems + 1)
+			return
+		}
+		seen := make(map[string]bool, len(objects))
+		ids = make([]string, 0, len(objects))
+		for _, object := range objects {
+			if id := idOf(object); id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	} else {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Bulk operations require the 'ids' or 'where' parameter."}
+		return
+	}
+
+	err = checkBulkSize(len(ids))
+	return
+}
+
+func checkBulkSize(size int) *utils.Error {
+	if BulkMaxItems > 0 && size > BulkMaxItems {
+		return &utils.Error{Code: http.StatusBadRequest, Message: "Bulk requests can have at most " + strconv.Itoa(BulkMaxItems) + " items."}
+	}
+	return nil
+}
+
+func bulkResponse(results []dataprovider.BulkResult, successStatus int) (response messages.Message) {
+
+	items := make([]interface{}, len(results))
+	failed := 0
+	for i, result := range results {
+		item := make(map[string]interface{})
+		if result.ID != "" {
+			item[dataprovider.IdField] = result.ID
+		}
+		if result.Err != nil {
+			failed++
+			item["status"] = result.Err.Code
+			item["message"] = result.Err.Message
+		} else {
+			item["status"] = successStatus
+			item["body"] = result.Response
+		}
+		items[i] = item
+	}
+
+	response.Body = map[string]interface{}{
+		dataprovider.ResultsField: items,
+		"succeeded":               len(results) - failed,
+		"failed":                  failed,
+	}
+	response.Status = successStatus
+	if failed > 0 {
+		response.Status = http.StatusMultiStatus
+	}
+	return
+}
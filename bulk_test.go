@@ -0,0 +1,107 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBulkOperations(t *testing.T) {
+
+	Convey("Given a collection with objects", t, func() {
+		provider := newMemoryProvider()
+		provider.Create("posts", map[string]interface{}{"draft": true})
+		provider.Create("posts", map[string]interface{}{"draft": true})
+		provider.Create("posts", map[string]interface{}{"draft": false})
+		EnableBulk("posts")
+		defer func() { bulkCollections = make(map[string]bool) }()
+
+		resultsOf := func(response messages.Message) []interface{} {
+			return response.Body["results"].([]interface{})
+		}
+
+		Convey("Array bodies should be created item by item", func() {
+			request := messages.Message{Res: "/posts", Command: methods.Post, Items: []map[string]interface{}{{"title": "a"}, {"title": "b"}}}
+			response, _, err := Execute(request, provider)
+			So(err, ShouldBeNil)
+			So(response.Status, ShouldEqual, http.StatusCreated)
+			So(response.Body["succeeded"], ShouldEqual, 2)
+			So(resultsOf(response)[1].(map[string]interface{})["_id"], ShouldEqual, "5")
+			So(provider.collections["posts"], ShouldHaveLength, 5)
+		})
+
+		Convey("Objects matching the filter should be updated", func() {
+			request := messages.Message{
+				Res:        "/posts",
+				Command:    methods.Patch,
+				Parameters: map[string][]string{"where": {`{"draft": true}`}},
+				Body:       map[string]interface{}{"draft": false},
+			}
+			response, _, err := Execute(request, provider)
+			So(err, ShouldBeNil)
+			So(response.Status, ShouldEqual, http.StatusOK)
+			So(resultsOf(response), ShouldHaveLength, 2)
+			So(provider.collections["posts"]["1"]["draft"], ShouldEqual, false)
+			So(provider.collections["posts"]["2"]["draft"], ShouldEqual, false)
+		})
+
+		Convey("Objects matching the filter should be read page by page", func() {
+			QueryPageSize = 1
+			defer func() { QueryPageSize = 1000 }()
+			request := messages.Message{
+				Res:        "/posts",
+				Command:    methods.Delete,
+				Parameters: map[string][]string{"where": {`{"draft": true}`}},
+			}
+			response, _, err := Execute(request, provider)
+			So(err, ShouldBeNil)
+			So(resultsOf(response), ShouldHaveLength, 2)
+			So(provider.collections["posts"], ShouldHaveLength, 1)
+		})
+
+		Convey("Filters matching more objects than the limit should be rejected", func() {
+			BulkMaxItems = 1
+			defer func() { BulkMaxItems = 1000 }()
+			request := messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"where": {`{"draft": true}`}}}
+			_, _, err := Execute(request, provider)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+			So(provider.collections["posts"], ShouldHaveLength, 3)
+		})
+
+		Convey("Bulk methods should be rejected on the collections without bulk", func() {
+			bulkCollections = make(map[string]bool)
+			_, _, err := Execute(messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"ids": {"1"}}}, provider)
+			So(err.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			_, _, err = Execute(messages.Message{Res: "/posts", Command: methods.Post, Items: []map[string]interface{}{{"title": "a"}}}, provider)
+			So(err.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			So(provider.collections["posts"], ShouldHaveLength, 3)
+		})
+
+		Convey("Partial failures should be reported with multi status", func() {
+			request := messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"ids": {"1,9"}}}
+			response, _, err := Execute(request, provider)
+			So(err, ShouldBeNil)
+			So(response.Status, ShouldEqual, http.StatusMultiStatus)
+			So(response.Body["succeeded"], ShouldEqual, 1)
+			So(response.Body["failed"], ShouldEqual, 1)
+			failure := resultsOf(response)[1].(map[string]interface{})
+			So(failure["_id"], ShouldEqual, "9")
+			So(failure["status"], ShouldEqual, http.StatusNotFound)
+			So(provider.collections["posts"], ShouldHaveLength, 2)
+		})
+
+		Convey("Bulk operations without targets should be rejected", func() {
+			_, _, err := Execute(messages.Message{Res: "/posts", Command: methods.Delete}, provider)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Bulk requests over the limit should be rejected", func() {
+			BulkMaxItems = 1
+			defer func() { BulkMaxItems = 1000 }()
+			_, _, err := Execute(messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"ids": {"1", "2"}}}, provider)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+	"net/url"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/hex"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+)
+
+// request scope key of the cache key, set by the BEFORE_EXEC interceptor for the AFTER_EXEC interceptor
+const KeyScopeKey = "cacheKey"
+
+type Rule struct {
+	TTL time.Duration
+	// request headers included in the cache key. ex: Accept-Language
+	VaryHeaders []string
+	// request scope key of the authenticated user. responses are cached per user if set
+	PrincipalKey string
+	// entries are invalidated by these tags in addition to the collection of the request
+	Tags []string
+}
+
+/**
+ * Caches the responses of the GET requests. Rules are passed to the interceptors
+ * as extras, so each rich url can have its own ttl and key. Cached responses are
+ * invalidated by the collection when it's mutated. Responses of the sub resources are
+ * invalidated by the child collection as well.
+ *
+ * Ex: responseCache.Register(core.Interceptors, "/posts", cache.Rule{TTL: time.Minute})
+ */
+type Cache struct {
+	Store Store
+	// used to get the current time, for testing purposes
+	Now func() time.Time
+}
+
+func New(store Store) *Cache {
+	return &Cache{Store: store}
+}
+
+/**
+ * Adds the interceptors of the rule for the path. Must be called after the
+ * authentication interceptors of the path are added, otherwise cached responses
+ * are returned before the request is authenticated.
+ */
+func (c *Cache) Register(controller interceptors.InterceptorController, path string, rule Rule) {
+	controller.Add(path, methods.Get, interceptors.BEFORE_EXEC, c.Lookup, rule)
+	controller.Add(path, methods.Get, interceptors.AFTER_EXEC, c.Save, rule)
+}
+
+// BEFORE_EXEC interceptor returning the cached response if exists
+func (c *Cache) Lookup(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	rule, isRule := extras.(Rule)
+	if !isRule || !strings.EqualFold(req.Command, methods.Get) {
+		return
+	}
+
+	key := c.Key(rule, rs, req)
+	editedRs = rs.Copy()
+	editedRs.Set(KeyScopeKey, key)
+
+	// clients can bypass the cache
+	if cacheControl, _ := req.GetHeader("Cache-Control"); strings.Contains(cacheControl, "no-cache") {
+		return
+	}
+
+	entry, found := c.Store.Get(key)
+	if !found {
+		return
+	}
+
+	var body map[string]interface{}
+	if len(entry.Body) > 0 {
+		if decodeErr := json.Unmarshal(entry.Body, &body); decodeErr != nil {
+			c.Store.Delete(key)
+			return
+		}
+	}
+
+	editedResp = messages.Message{Status: entry.Status, Body: body, Headers: make(map[string][]string)}
+	for name, values := range entry.Headers {
+		editedResp.Headers[name] = values
+	}
+	age := int(c.now().Sub(entry.StoredAt).Seconds())
+	if age < 0 {
+		age = 0
+	}
+	editedResp.Headers["Age"] = []string{strconv.Itoa(age)}
+	editedResp.Headers["X-Cache"] = []string{"HIT"}
+	return
+}
+
+// AFTER_EXEC interceptor storing the response
+func (c *Cache) Save(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	rule, isRule := extras.(Rule)
+	key, hasKey := rs.Get(KeyScopeKey).(string)
+	if !isRule || !hasKey || rule.TTL <= 0 {
+		return
+	}
+
+	// streams and failed responses are not cached
+	if resp.RawBodyReader != nil || resp.RawBody != nil || (resp.Status != 0 && resp.Status != http.StatusOK) {
+		return
+	}
+
+	body, encodeErr := json.Marshal(resp.Body)
+	if encodeErr != nil {
+		return
+	}
+
+	editedResp = resp
+	editedResp.Headers = make(map[string][]string)
+	for name, values := range resp.Headers {
+		editedResp.Headers[name] = values
+	}
+	editedResp.Headers["Cache-Control"] = []string{cacheControl(rule)}
+
+	now := c.now()
+	entry := Entry{
+		Status:    resp.Status,
+		Headers:   make(map[string][]string),
+		StoredAt:  now,
+		ExpiresAt: now.Add(rule.TTL),
+		Tags:      append(Collections(req.Res), rule.Tags...),
+	}
+	if resp.Body != nil {
+		entry.Body = body
+	}
+	for name, values := range editedResp.Headers {
+		entry.Headers[name] = values
+	}
+	c.Store.Set(key, entry)
+
+	editedResp.Headers["X-Cache"] = []string{"MISS"}
+	return
+}
+
+// removes the cached responses with the tag. collection names are used as tags
+func (c *Cache) Invalidate(tag string) {
+	c.Store.DeleteTag(tag)
+}
+
+// key of the request derived from the path, parameters, vary headers and the principal
+func (c *Cache) Key(rule Rule, rs requestscope.RequestScope, req messages.Message) string {
+
+	parts := []string{strings.ToLower(req.Command), req.Res, url.Values(req.Parameters).Encode()}
+	for _, header := range rule.VaryHeaders {
+		value, _ := req.GetHeader(http.CanonicalHeaderKey(header))
+		parts = append(parts, header+"="+value)
+	}
+	if rule.PrincipalKey != "" {
+		parts = append(parts, "principal="+principal(rs.Get(rule.PrincipalKey)))
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// returns the collection of the resource. ex: 'posts' for '/posts/123'
+func Collection(res string) string {
+	parts := strings.Split(res, "/")
+	if len(parts) < 2 {
+		return res
+	}
+	return parts[1]
+}
+
+// returns the collections the resource is read from. ex: 'users' and 'posts' for the sub resource '/users/1/posts'
+func Collections(res string) (collections []string) {
+	collections = []string{Collection(res)}
+	if parts := strings.Split(res, "/"); len(parts) >= 4 && parts[3] != "" && !strings.HasPrefix(parts[3], "_") {
+		collections = append(collections, parts[3])
+	}
+	return
+}
+
+func principal(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if user, isMap := value.(map[string]interface{}); isMap {
+		if id, hasID := user["_id"]; hasID {
+			return fmt.Sprint(id)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+func cacheControl(rule Rule) string {
+	visibility := "public"
+	if rule.PrincipalKey != "" {
+		visibility = "private"
+	}
+	return visibility + ", max-age=" + strconv.Itoa(int(rule.TTL.Seconds()))
+}
+
+func (c *Cache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
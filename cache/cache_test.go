@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+
+	Convey("Given a cache registered for a collection", t, func() {
+		now := time.Unix(1700000000, 0)
+		store := NewMemoryStore(100)
+		store.Now = func() time.Time { return now }
+		responseCache := New(store)
+		responseCache.Now = store.Now
+
+		controller := &interceptors.CoreInterceptorController{}
+		responseCache.Register(controller, "/posts", Rule{TTL: time.Minute, VaryHeaders: []string{"Accept-Language"}})
+
+		request := messages.Message{
+			Res:        "/posts",
+			Command:    methods.Get,
+			Headers:    map[string][]string{"Accept-Language": {"en"}},
+			Parameters: map[string][]string{"limit": {"10"}},
+		}
+		response := messages.Message{Body: map[string]interface{}{"results": []interface{}{"a"}}}
+
+		lookup := func(request messages.Message) (messages.Message, requestscope.RequestScope) {
+			_, cached, rs, err := controller.Execute(request.Res, request.Command, interceptors.BEFORE_EXEC, requestscope.Init(), request, messages.Message{}, nil)
+			So(err, ShouldBeNil)
+			return cached, rs
+		}
+		save := func(request messages.Message, rs requestscope.RequestScope) messages.Message {
+			_, saved, _, err := controller.Execute(request.Res, request.Command, interceptors.AFTER_EXEC, rs, request, response, nil)
+			So(err, ShouldBeNil)
+			return saved
+		}
+
+		cached, rs := lookup(request)
+		So(cached.IsEmpty(), ShouldBeTrue)
+		saved := save(request, rs)
+
+		Convey("Stored responses should have cache headers", func() {
+			So(saved.Headers["Cache-Control"], ShouldResemble, []string{"public, max-age=60"})
+			So(saved.Headers["X-Cache"], ShouldResemble, []string{"MISS"})
+		})
+
+		Convey("Same request should be served from the cache", func() {
+			now = now.Add(10 * time.Second)
+			cached, _ := lookup(request)
+			So(cached.Body["results"], ShouldResemble, []interface{}{"a"})
+			So(cached.Headers["Age"], ShouldResemble, []string{"10"})
+			So(cached.Headers["X-Cache"], ShouldResemble, []string{"HIT"})
+		})
+
+		Convey("Requests with other parameters or vary headers should miss", func() {
+			other := request
+			other.Parameters = map[string][]string{"limit": {"20"}}
+			cached, _ := lookup(other)
+			So(cached.IsEmpty(), ShouldBeTrue)
+
+			other = request
+			other.Headers = map[string][]string{"Accept-Language": {"tr"}}
+			cached, _ = lookup(other)
+			So(cached.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("Expired responses should miss", func() {
+			now = now.Add(time.Minute)
+			cached, _ := lookup(request)
+			So(cached.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("Invalidated collections should miss", func() {
+			responseCache.Invalidate("posts")
+			cached, _ := lookup(request)
+			So(cached.IsEmpty(), ShouldBeTrue)
+			So(store.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Responses of the sub resources should be invalidated by the child collection", func() {
+			other := request
+			other.Res = "/users/1/posts"
+			_, _, rs, _ := responseCache.Lookup(requestscope.Init(), Rule{TTL: time.Minute}, other, messages.Message{}, nil)
+			responseCache.Save(rs, Rule{TTL: time.Minute}, other, response, nil)
+			So(store.Len(), ShouldEqual, 2)
+
+			responseCache.Invalidate("posts")
+			So(store.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Failed responses should not be stored", func() {
+			other := request
+			other.Res = "/posts/missing"
+			_, _, rs, _ := responseCache.Lookup(requestscope.Init(), Rule{TTL: time.Minute}, other, messages.Message{}, nil)
+			responseCache.Save(rs, Rule{TTL: time.Minute}, other, messages.Message{Status: http.StatusNotFound}, nil)
+			So(store.Len(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Responses should be cached per principal if configured", t, func() {
+		responseCache := New(NewMemoryStore(100))
+		rule := Rule{TTL: time.Minute, PrincipalKey: "user"}
+		request := messages.Message{Res: "/feed", Command: methods.Get}
+
+		alice := requestscope.Init()
+		alice.Set("user", map[string]interface{}{"_id": "alice"})
+		bob := requestscope.Init()
+		bob.Set("user", map[string]interface{}{"_id": "bob"})
+
+		_, _, rs, _ := responseCache.Lookup(alice, rule, request, messages.Message{}, nil)
+		_, saved, _, _ := responseCache.Save(rs, rule, request, messages.Message{Body: map[string]interface{}{"owner": "alice"}}, nil)
+		So(saved.Headers["Cache-Control"], ShouldResemble, []string{"private, max-age=60"})
+
+		_, cached, _, _ := responseCache.Lookup(bob, rule, request, messages.Message{}, nil)
+		So(cached.IsEmpty(), ShouldBeTrue)
+		_, cached, _, _ = responseCache.Lookup(alice, rule, request, messages.Message{}, nil)
+		So(cached.Body["owner"], ShouldEqual, "alice")
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+
+	Convey("Least recently used entries should be evicted", t, func() {
+		store := NewMemoryStore(2)
+		store.Set("a", Entry{Tags: []string{"posts"}})
+		store.Set("b", Entry{Tags: []string{"posts"}})
+		store.Get("a")
+		store.Set("c", Entry{Tags: []string{"users"}})
+
+		_, found := store.Get("b")
+		So(found, ShouldBeFalse)
+		_, found = store.Get("a")
+		So(found, ShouldBeTrue)
+
+		store.DeleteTag("posts")
+		So(store.Len(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"sync"
+	"time"
+	"container/list"
+)
+
+type Entry struct {
+	Status  int
+	Headers map[string][]string
+	// json encoded body of the response
+	Body      []byte
+	StoredAt  time.Time
+	ExpiresAt time.Time
+	// used for invalidating the entries of a collection
+	Tags []string
+}
+
+type Store interface {
+	Get(key string) (entry Entry, found bool)
+	Set(key string, entry Entry)
+	Delete(key string)
+	DeleteTag(tag string)
+}
+
+/**
+ * In memory store keeping at most MaxEntries entries. Least recently used entries
+ * are evicted first and expired entries are removed when they are read.
+ */
+type MemoryStore struct {
+	MaxEntries int
+	// used to get the current time, for testing purposes
+	Now     func() time.Time
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]bool
+}
+
+type storedEntry struct {
+	key   string
+	entry Entry
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		MaxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		tags:       make(map[string]map[string]bool),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (entry Entry, found bool) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, found := s.entries[key]
+	if !found {
+		return
+	}
+	entry = element.Value.(*storedEntry).entry
+	if !entry.ExpiresAt.IsZero() && !s.now().Before(entry.ExpiresAt) {
+		s.remove(element)
+		return Entry{}, false
+	}
+	s.order.MoveToFront(element)
+	return
+}
+
+func (s *MemoryStore) Set(key string, entry Entry) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, exists := s.entries[key]; exists {
+		s.remove(element)
+	}
+	s.entries[key] = s.order.PushFront(&storedEntry{key, entry})
+	for _, tag := range entry.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]bool)
+		}
+		s.tags[tag][key] = true
+	}
+
+	for s.MaxEntries > 0 && s.order.Len() > s.MaxEntries {
+		s.remove(s.order.Back())
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, exists := s.entries[key]; exists {
+		s.remove(element)
+	}
+}
+
+func (s *MemoryStore) DeleteTag(tag string) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.tags[tag] {
+		if element, exists := s.entries[key]; exists {
+			s.remove(element)
+		}
+	}
+	delete(s.tags, tag)
+}
+
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(element *list.Element) {
+	stored := s.order.Remove(element).(*storedEntry)
+	delete(s.entries, stored.key)
+	for _, tag := range stored.entry.Tags {
+		if keys := s.tags[tag]; keys != nil {
+			delete(keys, stored.key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
+
+func (s *MemoryStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
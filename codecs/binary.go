@@ -0,0 +1,72 @@
+package codecs
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+)
+
+// nesting limit of the binary decoders to prevent stack exhaustion
+const maxDecodeDepth = 512
+
+// the lengths in the payload are not trusted for preallocation
+func capacity(length int) int {
+	if length < 0 || length > 1024 {
+		return 1024
+	}
+	return length
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func readUint(r *bufio.Reader, size int) (value uint64, err error) {
+	buffer := make([]byte, 8)
+	if _, err = io.ReadFull(r, buffer[8-size:]); err != nil {
+		return 0, unexpected(err)
+	}
+	return binary.BigEndian.Uint64(buffer), nil
+}
+
+func readBytes(r *bufio.Reader, length uint64) (value []byte, err error) {
+	var buffer bytes.Buffer
+	copied, err := io.CopyN(&buffer, r, int64(length))
+	if err != nil || uint64(copied) != length {
+		return nil, unexpected(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func readString(r *bufio.Reader, length uint64) (value interface{}, err error) {
+	data, err := readBytes(r, length)
+	if err != nil {
+		return
+	}
+	return string(data), nil
+}
+
+func keyString(key interface{}) string {
+	if text, isString := key.(string); isString {
+		return text
+	}
+	if data, isBytes := key.([]byte); isBytes {
+		return string(data)
+	}
+	return fmt.Sprint(key)
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,295 @@
+package codecs
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"bufio"
+	"errors"
+	"encoding/json"
+)
+
+// CBOR (RFC 8949) codec for the generic data model. tags are decoded as their content.
+type CBOR struct{}
+
+const (
+	cborUnsigned byte = iota << 5
+	cborNegative
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const cborIndefinite = 31
+
+var errCBORBreak = errors.New("cbor: break")
+
+func (c *CBOR) ContentType() string {
+	return "application/cbor"
+}
+
+func (c *CBOR) MediaTypes() []string {
+	return []string{"application/cbor"}
+}
+
+func (c *CBOR) Decode(r io.Reader) (value interface{}, err error) {
+	value, err = decodeCBOR(bufio.NewReader(r), 0)
+	if err == errCBORBreak {
+		err = errors.New("cbor: unexpected break")
+	}
+	return
+}
+
+func (c *CBOR) Encode(w io.Writer, value interface{}) (err error) {
+	normalized, err := Normalize(value)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(w)
+	if err = encodeCBOR(writer, normalized); err != nil {
+		return
+	}
+	return writer.Flush()
+}
+
+func encodeCBOR(w *bufio.Writer, value interface{}) (err error) {
+
+	switch v := value.(type) {
+	case nil:
+		err = w.WriteByte(cborSimple | 22)
+	case bool:
+		if v {
+			err = w.WriteByte(cborSimple | 21)
+		} else {
+			err = w.WriteByte(cborSimple | 20)
+		}
+	case json.Number:
+		switch number := numberValue(v).(type) {
+		case int64:
+			if number >= 0 {
+				err = writeCBORHead(w, cborUnsigned, uint64(number))
+			} else {
+				err = writeCBORHead(w, cborNegative, uint64(-(number + 1)))
+			}
+		case uint64:
+			err = writeCBORHead(w, cborUnsigned, number)
+		case float64:
+			err = writeHeader(w, cborSimple|27, 8, math.Float64bits(number))
+		}
+	case string:
+		if err = writeCBORHead(w, cborText, uint64(len(v))); err == nil {
+			_, err = w.WriteString(v)
+		}
+	case []interface{}:
+		err = writeCBORHead(w, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err != nil {
+				return
+			}
+			err = encodeCBOR(w, item)
+		}
+	case map[string]interface{}:
+		err = writeCBORHead(w, cborMap, uint64(len(v)))
+		for _, key := range sortedKeys(v) {
+			if err != nil {
+				return
+			}
+			if err = encodeCBOR(w, key); err == nil {
+				err = encodeCBOR(w, v[key])
+			}
+		}
+	default:
+		err = fmt.Errorf("cbor: unsupported type %T", value)
+	}
+	return
+}
+
+// writes the major type with the argument in the shortest form
+func writeCBORHead(w *bufio.Writer, majorType byte, argument uint64) error {
+	switch {
+	case argument < 24:
+		return w.WriteByte(majorType | byte(argument))
+	case argument <= math.MaxUint8:
+		return writeHeader(w, majorType|24, 1, argument)
+	case argument <= math.MaxUint16:
+		return writeHeader(w, majorType|25, 2, argument)
+	case argument <= math.MaxUint32:
+		return writeHeader(w, majorType|26, 4, argument)
+	}
+	return writeHeader(w, majorType|27, 8, argument)
+}
+
+func decodeCBOR(r *bufio.Reader, depth int) (value interface{}, err error) {
+
+	if depth > maxDecodeDepth {
+		return nil, errors.New("cbor: maximum nesting depth exceeded")
+	}
+
+	initial, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	majorType := initial & 0xe0
+	info := initial & 0x1f
+
+	if majorType == cborSimple {
+		return decodeCBORSimple(r, info)
+	}
+
+	indefinite := info == cborIndefinite
+	var argument uint64
+	if !indefinite {
+		if argument, err = readCBORArgument(r, info); err != nil {
+			return
+		}
+	}
+
+	switch majorType {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			return argument, nil
+		}
+		return int64(argument), nil
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			return -1 - float64(argument), nil
+		}
+		return -1 - int64(argument), nil
+	case cborBytes, cborText:
+		var data []byte
+		if indefinite {
+			data, err = readCBORChunks(r, majorType, depth)
+		} else {
+			data, err = readBytes(r, argument)
+		}
+		if err != nil {
+			return
+		}
+		if majorType == cborText {
+			return string(data), nil
+		}
+		return data, nil
+	case cborArray:
+		array := make([]interface{}, 0, capacity(int(argument)))
+		for i := uint64(0); indefinite || i < argument; i++ {
+			item, decodeErr := decodeCBOR(r, depth+1)
+			if indefinite && decodeErr == errCBORBreak {
+				break
+			}
+			if decodeErr != nil {
+				return nil, unexpected(decodeErr)
+			}
+			array = append(array, item)
+		}
+		return array, nil
+	case cborMap:
+		object := make(map[string]interface{}, capacity(int(argument)))
+		for i := uint64(0); indefinite || i < argument; i++ {
+			key, decodeErr := decodeCBOR(r, depth+1)
+			if indefinite && decodeErr == errCBORBreak {
+				break
+			}
+			if decodeErr != nil {
+				return nil, unexpected(decodeErr)
+			}
+			item, decodeErr := decodeCBOR(r, depth+1)
+			if decodeErr != nil {
+				return nil, unexpected(decodeErr)
+			}
+			object[keyString(key)] = item
+		}
+		return object, nil
+	case cborTag:
+		if indefinite {
+			return nil, errors.New("cbor: invalid tag")
+		}
+		value, err = decodeCBOR(r, depth+1)
+		return value, unexpected(err)
+	}
+	return
+}
+
+func decodeCBORSimple(r *bufio.Reader, info byte) (value interface{}, err error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		bits, readErr := readUint(r, 2)
+		return halfToFloat(uint16(bits)), readErr
+	case 26:
+		bits, readErr := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(bits))), readErr
+	case 27:
+		bits, readErr := readUint(r, 8)
+		return math.Float64frombits(bits), readErr
+	case cborIndefinite:
+		return nil, errCBORBreak
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func readCBORArgument(r *bufio.Reader, info byte) (argument uint64, err error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return readUint(r, 1<<(info-24))
+	}
+	return 0, fmt.Errorf("cbor: invalid additional information %d", info)
+}
+
+// reads the chunks of an indefinite length byte or text string
+func readCBORChunks(r *bufio.Reader, majorType byte, depth int) (data []byte, err error) {
+	for {
+		chunk, decodeErr := decodeCBOR(r, depth+1)
+		if decodeErr == errCBORBreak {
+			return data, nil
+		}
+		if decodeErr != nil {
+			return nil, unexpected(decodeErr)
+		}
+		switch c := chunk.(type) {
+		case string:
+			if majorType != cborText {
+				return nil, errors.New("cbor: invalid chunk")
+			}
+			data = append(data, c...)
+		case []byte:
+			if majorType != cborBytes {
+				return nil, errors.New("cbor: invalid chunk")
+			}
+			data = append(data, c...)
+		default:
+			return nil, errors.New("cbor: invalid chunk")
+		}
+	}
+}
+
+func halfToFloat(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1f
+	mantissa := float64(bits & 0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 31:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
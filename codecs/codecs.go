@@ -0,0 +1,203 @@
+package codecs
+
+import (
+	"io"
+	"sort"
+	"bytes"
+	"strconv"
+	"strings"
+	"encoding/json"
+)
+
+type Codec interface {
+	// value of the Content-Type header in responses. ex: "application/json; charset=utf-8"
+	ContentType() string
+	// media types accepted in Content-Type and Accept headers. ex: "application/json"
+	MediaTypes() []string
+	Decode(r io.Reader) (value interface{}, err error)
+	Encode(w io.Writer, value interface{}) (err error)
+}
+
+type Registry struct {
+	// codec used when the client doesn't specify a media type
+	Default Codec
+	codecs  []Codec
+}
+
+func NewRegistry(defaultCodec Codec, codecs ...Codec) *Registry {
+	registry := &Registry{Default: defaultCodec}
+	registry.Register(defaultCodec)
+	for _, codec := range codecs {
+		registry.Register(codec)
+	}
+	return registry
+}
+
+// registry of all built-in codecs with json as default
+func DefaultRegistry() *Registry {
+	return NewRegistry(&JSON{}, &MessagePack{}, &CBOR{}, &YAML{}, &Form{}, &XML{})
+}
+
+// registers the codec. codecs registered later override the media types of the former ones
+func (r *Registry) Register(codec Codec) {
+	r.codecs = append([]Codec{codec}, r.codecs...)
+}
+
+/**
+ * Returns the codec for the value of a Content-Type header. Parameters are ignored
+ * and structured syntax suffixes are supported.
+ *
+ * Ex: "application/json; charset=utf-8", "application/vnd.api+json"
+ */
+func (r *Registry) ForContentType(contentType string) (codec Codec, found bool) {
+
+	mediaType := parseMediaType(contentType)
+	if mediaType == "" {
+		return r.Default, r.Default != nil
+	}
+
+	if codec, found = r.find(mediaType); found {
+		return
+	}
+	if plus := strings.LastIndex(mediaType, "+"); plus != -1 {
+		codec, found = r.find("application/" + mediaType[plus+1:])
+	}
+	return
+}
+
+/**
+ * Returns the codec preferred by the value of an Accept header. Quality values
+ * and wildcards are supported. Returns the default codec if the header is empty.
+ *
+ * Ex: "application/cbor;q=0.9, application/json", "application/*"
+ */
+func (r *Registry) Negotiate(accept string) (codec Codec, found bool) {
+
+	if strings.TrimSpace(accept) == "" {
+		return r.Default, r.Default != nil
+	}
+
+	for _, mediaRange := range parseAccept(accept) {
+		if mediaRange.quality <= 0 {
+			continue
+		}
+		if mediaRange.mediaType == "*/*" {
+			return r.Default, r.Default != nil
+		}
+		if strings.HasSuffix(mediaRange.mediaType, "/*") {
+			prefix := strings.TrimSuffix(mediaRange.mediaType, "*")
+			if r.Default != nil && hasMediaTypePrefix(r.Default, prefix) {
+				return r.Default, true
+			}
+			for _, registered := range r.codecs {
+				if hasMediaTypePrefix(registered, prefix) {
+					return registered, true
+				}
+			}
+			continue
+		}
+		if codec, found = r.ForContentType(mediaRange.mediaType); found {
+			return
+		}
+	}
+	return
+}
+
+func (r *Registry) find(mediaType string) (codec Codec, found bool) {
+	for _, registered := range r.codecs {
+		for _, registeredType := range registered.MediaTypes() {
+			if registeredType == mediaType {
+				return registered, true
+			}
+		}
+	}
+	return
+}
+
+func hasMediaTypePrefix(codec Codec, prefix string) bool {
+	for _, mediaType := range codec.MediaTypes() {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMediaType(value string) string {
+	if semicolon := strings.Index(value, ";"); semicolon != -1 {
+		value = value[:semicolon]
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// parses the accept header and sorts the media ranges by quality and specificity
+func parseAccept(accept string) (ranges []mediaRange) {
+
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		entry := mediaRange{mediaType: parseMediaType(params[0]), quality: 1}
+		if entry.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			keyValue := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(keyValue) == 2 && strings.EqualFold(keyValue[0], "q") {
+				if quality, parseErr := strconv.ParseFloat(keyValue[1], 64); parseErr == nil {
+					entry.quality = quality
+				}
+			}
+		}
+		ranges = append(ranges, entry)
+	}
+
+	specificity := func(mediaType string) int {
+		if mediaType == "*/*" {
+			return 0
+		}
+		if strings.HasSuffix(mediaType, "/*") {
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].quality != ranges[j].quality {
+			return ranges[i].quality > ranges[j].quality
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return
+}
+
+/**
+ * Converts the value to the generic data model used by the codecs: nil, bool, string,
+ * json.Number, []interface{} and map[string]interface{}. Structs and custom types
+ * are converted with their json representation.
+ */
+func Normalize(value interface{}) (normalized interface{}, err error) {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&normalized)
+	return
+}
+
+// converts json numbers to int64 if possible, float64 otherwise
+func numberValue(number json.Number) interface{} {
+	if integer, err := number.Int64(); err == nil {
+		return integer
+	}
+	if unsigned, err := strconv.ParseUint(string(number), 10, 64); err == nil {
+		return unsigned
+	}
+	float, _ := number.Float64()
+	return float
+}
@@ -0,0 +1,120 @@
+package codecs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given the default registry", t, func() {
+		registry := DefaultRegistry()
+
+		Convey("Empty content type should resolve to json", func() {
+			codec, found := registry.ForContentType("")
+			So(found, ShouldBeTrue)
+			So(codec, ShouldHaveSameTypeAs, &JSON{})
+		})
+
+		Convey("Content type parameters and suffixes should be supported", func() {
+			codec, found := registry.ForContentType("application/vnd.api+json; charset=utf-8")
+			So(found, ShouldBeTrue)
+			So(codec, ShouldHaveSameTypeAs, &JSON{})
+
+			codec, found = registry.ForContentType("application/x-msgpack")
+			So(found, ShouldBeTrue)
+			So(codec, ShouldHaveSameTypeAs, &MessagePack{})
+		})
+
+		Convey("Unknown content types should not be found", func() {
+			_, found := registry.ForContentType("application/pdf")
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("Accept header should be negotiated by quality", func() {
+			codec, found := registry.Negotiate("application/json;q=0.5, application/cbor")
+			So(found, ShouldBeTrue)
+			So(codec, ShouldHaveSameTypeAs, &CBOR{})
+		})
+
+		Convey("Wildcards should prefer the default codec", func() {
+			codec, found := registry.Negotiate("text/html, */*;q=0.8")
+			So(found, ShouldBeTrue)
+			So(codec, ShouldHaveSameTypeAs, &JSON{})
+
+			codec, found = registry.Negotiate("text/*")
+			So(found, ShouldBeTrue)
+			So(codec, ShouldHaveSameTypeAs, &JSON{})
+		})
+
+		Convey("Unsupported accept headers should not be negotiated", func() {
+			_, found := registry.Negotiate("image/png, application/json;q=0")
+			So(found, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCodecs(t *testing.T) {
+
+	value := map[string]interface{}{
+		"name":   "john",
+		"age":    42,
+		"score":  -1.5,
+		"active": true,
+		"tags":   []interface{}{"a", "b"},
+		"nested": map[string]interface{}{"empty": nil, "big": int64(1) << 40},
+	}
+
+	for _, codec := range []Codec{&JSON{}, &MessagePack{}, &CBOR{}, &YAML{}} {
+
+		Convey("Given the "+codec.MediaTypes()[0]+" codec", t, func() {
+			var buffer bytes.Buffer
+			So(codec.Encode(&buffer, value), ShouldBeNil)
+
+			decoded, err := codec.Decode(&buffer)
+			So(err, ShouldBeNil)
+
+			Convey("Decoded value should be equal to the encoded one", func() {
+				normalizedDecoded, _ := Normalize(decoded)
+				normalizedValue, _ := Normalize(value)
+				So(normalizedDecoded, ShouldResemble, normalizedValue)
+			})
+		})
+	}
+
+	Convey("Given the xml codec", t, func() {
+		codec := &XML{}
+		var buffer bytes.Buffer
+		So(codec.Encode(&buffer, map[string]interface{}{"name": "john", "tags": []interface{}{"a", "b"}, "1st": nil}), ShouldBeNil)
+
+		Convey("It should encode the elements", func() {
+			So(buffer.String(), ShouldEqual, `<response><field name="1st" nil="true"></field><name>john</name><tags><item>a</item><item>b</item></tags></response>`)
+		})
+
+		Convey("It should decode the encoded value", func() {
+			decoded, err := codec.Decode(&buffer)
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, map[string]interface{}{"name": "john", "tags": []interface{}{"a", "b"}, "1st": nil})
+		})
+	})
+
+	Convey("Given the form codec", t, func() {
+		codec := &Form{}
+
+		Convey("It should decode single and repeated fields", func() {
+			decoded, err := codec.Decode(strings.NewReader("name=john&tag=a&tag=b"))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, map[string]interface{}{"name": "john", "tag": []interface{}{"a", "b"}})
+		})
+	})
+
+	Convey("Given a truncated msgpack payload", t, func() {
+		_, err := (&MessagePack{}).Decode(bytes.NewReader([]byte{0x92, 0x01}))
+
+		Convey("Decoding should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
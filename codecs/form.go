@@ -0,0 +1,93 @@
+package codecs
+
+import (
+	"io"
+	"fmt"
+	"net/url"
+	"encoding/json"
+)
+
+/**
+ * Codec for application/x-www-form-urlencoded bodies. Fields with a single value
+ * are decoded as strings, repeated fields as arrays. Nested objects are encoded
+ * as json strings.
+ */
+type Form struct{}
+
+func (c *Form) ContentType() string {
+	return "application/x-www-form-urlencoded; charset=utf-8"
+}
+
+func (c *Form) MediaTypes() []string {
+	return []string{"application/x-www-form-urlencoded"}
+}
+
+func (c *Form) Decode(r io.Reader) (value interface{}, err error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return
+	}
+	return FormValues(values), nil
+}
+
+func (c *Form) Encode(w io.Writer, value interface{}) (err error) {
+
+	normalized, err := Normalize(value)
+	if err != nil {
+		return
+	}
+	object, isObject := normalized.(map[string]interface{})
+	if !isObject {
+		return fmt.Errorf("form: only objects can be encoded, got %T", normalized)
+	}
+
+	values := url.Values{}
+	for key, item := range object {
+		if array, isArray := item.([]interface{}); isArray {
+			for _, element := range array {
+				values.Add(key, formValue(element))
+			}
+		} else {
+			values.Set(key, formValue(item))
+		}
+	}
+	_, err = io.WriteString(w, values.Encode())
+	return
+}
+
+// converts form values to the generic data model
+func FormValues(values map[string][]string) map[string]interface{} {
+	object := make(map[string]interface{}, len(values))
+	for key, items := range values {
+		if len(items) == 1 {
+			object[key] = items[0]
+			continue
+		}
+		array := make([]interface{}, len(items))
+		for i, item := range items {
+			array[i] = item
+		}
+		object[key] = array
+	}
+	return object
+}
+
+func formValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,37 @@
+package codecs
+
+import (
+	"io"
+	"encoding/json"
+)
+
+type JSON struct {
+	// decodes numbers as json.Number instead of float64
+	UseNumber bool
+}
+
+func (c *JSON) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (c *JSON) MediaTypes() []string {
+	return []string{"application/json", "text/json"}
+}
+
+func (c *JSON) Decode(r io.Reader) (value interface{}, err error) {
+	decoder := json.NewDecoder(r)
+	if c.UseNumber {
+		decoder.UseNumber()
+	}
+	err = decoder.Decode(&value)
+	return
+}
+
+func (c *JSON) Encode(w io.Writer, value interface{}) (err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
@@ -0,0 +1,254 @@
+package codecs
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"bufio"
+	"errors"
+	"encoding/json"
+	"encoding/binary"
+)
+
+// MessagePack codec for the generic data model. extension types are not supported.
+type MessagePack struct{}
+
+func (c *MessagePack) ContentType() string {
+	return "application/msgpack"
+}
+
+func (c *MessagePack) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+
+func (c *MessagePack) Decode(r io.Reader) (value interface{}, err error) {
+	return decodeMessagePack(bufio.NewReader(r), 0)
+}
+
+func (c *MessagePack) Encode(w io.Writer, value interface{}) (err error) {
+	normalized, err := Normalize(value)
+	if err != nil {
+		return
+	}
+	writer := bufio.NewWriter(w)
+	if err = encodeMessagePack(writer, normalized); err != nil {
+		return
+	}
+	return writer.Flush()
+}
+
+func encodeMessagePack(w *bufio.Writer, value interface{}) (err error) {
+
+	switch v := value.(type) {
+	case nil:
+		err = w.WriteByte(0xc0)
+	case bool:
+		if v {
+			err = w.WriteByte(0xc3)
+		} else {
+			err = w.WriteByte(0xc2)
+		}
+	case json.Number:
+		switch number := numberValue(v).(type) {
+		case int64:
+			err = encodeMessagePackInt(w, number)
+		case uint64:
+			err = writeHeader(w, 0xcf, 8, number)
+		case float64:
+			err = writeHeader(w, 0xcb, 8, math.Float64bits(number))
+		}
+	case string:
+		length := uint64(len(v))
+		switch {
+		case length < 32:
+			err = w.WriteByte(0xa0 | byte(length))
+		case length <= math.MaxUint8:
+			err = writeHeader(w, 0xd9, 1, length)
+		case length <= math.MaxUint16:
+			err = writeHeader(w, 0xda, 2, length)
+		default:
+			err = writeHeader(w, 0xdb, 4, length)
+		}
+		if err == nil {
+			_, err = w.WriteString(v)
+		}
+	case []interface{}:
+		length := uint64(len(v))
+		switch {
+		case length < 16:
+			err = w.WriteByte(0x90 | byte(length))
+		case length <= math.MaxUint16:
+			err = writeHeader(w, 0xdc, 2, length)
+		default:
+			err = writeHeader(w, 0xdd, 4, length)
+		}
+		for _, item := range v {
+			if err != nil {
+				return
+			}
+			err = encodeMessagePack(w, item)
+		}
+	case map[string]interface{}:
+		length := uint64(len(v))
+		switch {
+		case length < 16:
+			err = w.WriteByte(0x80 | byte(length))
+		case length <= math.MaxUint16:
+			err = writeHeader(w, 0xde, 2, length)
+		default:
+			err = writeHeader(w, 0xdf, 4, length)
+		}
+		for _, key := range sortedKeys(v) {
+			if err != nil {
+				return
+			}
+			if err = encodeMessagePack(w, key); err == nil {
+				err = encodeMessagePack(w, v[key])
+			}
+		}
+	default:
+		err = fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+	return
+}
+
+func encodeMessagePackInt(w *bufio.Writer, v int64) error {
+	switch {
+	case v >= 0 && v < 128:
+		return w.WriteByte(byte(v))
+	case v >= -32 && v < 0:
+		return w.WriteByte(byte(int8(v)))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return writeHeader(w, 0xd0, 1, uint64(uint8(int8(v))))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return writeHeader(w, 0xd1, 2, uint64(uint16(int16(v))))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return writeHeader(w, 0xd2, 4, uint64(uint32(int32(v))))
+	}
+	return writeHeader(w, 0xd3, 8, uint64(v))
+}
+
+// writes the type byte followed by the big endian value in the given size
+func writeHeader(w *bufio.Writer, typeByte byte, size int, value uint64) (err error) {
+	if err = w.WriteByte(typeByte); err != nil {
+		return
+	}
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, value)
+	_, err = w.Write(buffer[8-size:])
+	return
+}
+
+func decodeMessagePack(r *bufio.Reader, depth int) (value interface{}, err error) {
+
+	if depth > maxDecodeDepth {
+		return nil, errors.New("msgpack: maximum nesting depth exceeded")
+	}
+
+	typeByte, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+
+	switch {
+	case typeByte <= 0x7f:
+		return int64(typeByte), nil
+	case typeByte >= 0xe0:
+		return int64(int8(typeByte)), nil
+	case typeByte&0xf0 == 0x80:
+		return decodeMessagePackMap(r, int(typeByte&0x0f), depth)
+	case typeByte&0xf0 == 0x90:
+		return decodeMessagePackArray(r, int(typeByte&0x0f), depth)
+	case typeByte&0xe0 == 0xa0:
+		return readString(r, uint64(typeByte&0x1f))
+	}
+
+	switch typeByte {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, readErr := readUint(r, 1<<(typeByte-0xc4))
+		if readErr != nil {
+			return nil, readErr
+		}
+		return readBytes(r, length)
+	case 0xca:
+		bits, readErr := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(bits))), readErr
+	case 0xcb:
+		bits, readErr := readUint(r, 8)
+		return math.Float64frombits(bits), readErr
+	case 0xcc, 0xcd, 0xce:
+		number, readErr := readUint(r, 1<<(typeByte-0xcc))
+		return int64(number), readErr
+	case 0xcf:
+		number, readErr := readUint(r, 8)
+		if number > math.MaxInt64 {
+			return number, readErr
+		}
+		return int64(number), readErr
+	case 0xd0:
+		number, readErr := readUint(r, 1)
+		return int64(int8(number)), readErr
+	case 0xd1:
+		number, readErr := readUint(r, 2)
+		return int64(int16(number)), readErr
+	case 0xd2:
+		number, readErr := readUint(r, 4)
+		return int64(int32(number)), readErr
+	case 0xd3:
+		number, readErr := readUint(r, 8)
+		return int64(number), readErr
+	case 0xd9, 0xda, 0xdb:
+		length, readErr := readUint(r, 1<<(typeByte-0xd9))
+		if readErr != nil {
+			return nil, readErr
+		}
+		return readString(r, length)
+	case 0xdc, 0xdd:
+		length, readErr := readUint(r, 2<<(typeByte-0xdc))
+		if readErr != nil {
+			return nil, readErr
+		}
+		return decodeMessagePackArray(r, int(length), depth)
+	case 0xde, 0xdf:
+		length, readErr := readUint(r, 2<<(typeByte-0xde))
+		if readErr != nil {
+			return nil, readErr
+		}
+		return decodeMessagePackMap(r, int(length), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%x", typeByte)
+}
+
+func decodeMessagePackArray(r *bufio.Reader, length int, depth int) (value interface{}, err error) {
+	array := make([]interface{}, 0, capacity(length))
+	for i := 0; i < length; i++ {
+		item, decodeErr := decodeMessagePack(r, depth+1)
+		if decodeErr != nil {
+			return nil, unexpected(decodeErr)
+		}
+		array = append(array, item)
+	}
+	return array, nil
+}
+
+func decodeMessagePackMap(r *bufio.Reader, length int, depth int) (value interface{}, err error) {
+	object := make(map[string]interface{}, capacity(length))
+	for i := 0; i < length; i++ {
+		key, decodeErr := decodeMessagePack(r, depth+1)
+		if decodeErr != nil {
+			return nil, unexpected(decodeErr)
+		}
+		item, decodeErr := decodeMessagePack(r, depth+1)
+		if decodeErr != nil {
+			return nil, unexpected(decodeErr)
+		}
+		object[keyString(key)] = item
+	}
+	return object, nil
+}
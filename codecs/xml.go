@@ -0,0 +1,207 @@
+package codecs
+
+import (
+	"io"
+	"errors"
+	"strings"
+	"encoding/xml"
+)
+
+/**
+ * Codec for xml bodies. Objects are written as elements named by their keys,
+ * arrays as elements with 'item' children and nil values as elements with the
+ * nil="true" attribute. Keys that are not valid element names are written as
+ * 'field' elements with a 'name' attribute.
+ *
+ * Ex: {"name": "john", "tags": ["a", "b"]} => <response><name>john</name><tags><item>a</item><item>b</item></tags></response>
+ */
+type XML struct {
+	// name of the root element. defaults to "response"
+	Root string
+}
+
+func (c *XML) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (c *XML) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+func (c *XML) Decode(r io.Reader) (value interface{}, err error) {
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			return nil, tokenErr
+		}
+		if start, isStart := token.(xml.StartElement); isStart {
+			return decodeXMLElement(decoder, start, 0)
+		}
+	}
+}
+
+func (c *XML) Encode(w io.Writer, value interface{}) (err error) {
+
+	normalized, err := Normalize(value)
+	if err != nil {
+		return
+	}
+
+	root := c.Root
+	if root == "" {
+		root = "response"
+	}
+
+	encoder := xml.NewEncoder(w)
+	if err = encodeXMLElement(encoder, root, normalized); err != nil {
+		return
+	}
+	return encoder.Flush()
+}
+
+func encodeXMLElement(encoder *xml.Encoder, name string, value interface{}) (err error) {
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !isXMLName(name) {
+		start.Name.Local = "field"
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "name"}, Value: name})
+	}
+	if value == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "nil"}, Value: "true"})
+	}
+
+	if err = encoder.EncodeToken(start); err != nil {
+		return
+	}
+
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			if err = encodeXMLElement(encoder, key, v[key]); err != nil {
+				return
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err = encodeXMLElement(encoder, "item", item); err != nil {
+				return
+			}
+		}
+	default:
+		if err = encoder.EncodeToken(xml.CharData(formValue(v))); err != nil {
+			return
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement, depth int) (value interface{}, err error) {
+
+	if depth > maxDecodeDepth {
+		return nil, errors.New("xml: maximum nesting depth exceeded")
+	}
+
+	var text strings.Builder
+	names := make([]string, 0)
+	values := make([]interface{}, 0)
+
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			return nil, unexpected(tokenErr)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, decodeErr := decodeXMLElement(decoder, t, depth+1)
+			if decodeErr != nil {
+				return nil, decodeErr
+			}
+			names = append(names, xmlKey(t))
+			values = append(values, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			return xmlValue(start, text.String(), names, values), nil
+		}
+	}
+}
+
+func xmlValue(start xml.StartElement, text string, names []string, values []interface{}) interface{} {
+
+	if len(names) == 0 {
+		if xmlAttr(start, "nil") == "true" {
+			return nil
+		}
+		return text
+	}
+
+	isArray := true
+	for _, name := range names {
+		if name != "item" {
+			isArray = false
+			break
+		}
+	}
+	if isArray {
+		return values
+	}
+
+	// repeated elements are collected in arrays
+	object := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		existing, exists := object[name]
+		if !exists {
+			object[name] = values[i]
+			continue
+		}
+		if array, isRepeated := existing.(xmlRepeated); isRepeated {
+			object[name] = append(array, values[i])
+		} else {
+			object[name] = xmlRepeated{existing, values[i]}
+		}
+	}
+	for name, item := range object {
+		if array, isRepeated := item.(xmlRepeated); isRepeated {
+			object[name] = []interface{}(array)
+		}
+	}
+	return object
+}
+
+type xmlRepeated []interface{}
+
+func xmlKey(start xml.StartElement) string {
+	if start.Name.Local == "field" {
+		if name := xmlAttr(start, "name"); name != "" {
+			return name
+		}
+	}
+	return start.Name.Local
+}
+
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, c := range name {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+		isOther := (c >= '0' && c <= '9') || c == '-' || c == '.'
+		if !isLetter && (i == 0 || !isOther) {
+			return false
+		}
+	}
+	return true
+}
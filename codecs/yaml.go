@@ -0,0 +1,80 @@
+package codecs
+
+import (
+	"io"
+	"fmt"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+)
+
+type YAML struct{}
+
+func (c *YAML) ContentType() string {
+	return "application/yaml; charset=utf-8"
+}
+
+func (c *YAML) MediaTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml"}
+}
+
+func (c *YAML) Decode(r io.Reader) (value interface{}, err error) {
+	var decoded interface{}
+	if err = yaml.NewDecoder(r).Decode(&decoded); err != nil {
+		return
+	}
+	return fromYAML(decoded), nil
+}
+
+func (c *YAML) Encode(w io.Writer, value interface{}) (err error) {
+	normalized, err := Normalize(value)
+	if err != nil {
+		return
+	}
+	encoder := yaml.NewEncoder(w)
+	if err = encoder.Encode(toYAML(normalized)); err != nil {
+		return
+	}
+	return encoder.Close()
+}
+
+// converts the maps with non-string keys to the generic data model
+func fromYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = fromYAML(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[fmt.Sprint(key)] = fromYAML(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fromYAML(item)
+		}
+		return v
+	case int:
+		return int64(v)
+	}
+	return value
+}
+
+// converts json numbers to native numbers so they are not encoded as strings
+func toYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = toYAML(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = toYAML(item)
+		}
+	case json.Number:
+		return numberValue(v)
+	}
+	return value
+}
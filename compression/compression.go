@@ -0,0 +1,207 @@
+package compression
+
+import (
+	"io"
+	"errors"
+	"strconv"
+	"strings"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrUnsupportedEncoding = errors.New("Content encoding is not supported.")
+
+type Encoding struct {
+	// token used in the Accept-Encoding and Content-Encoding headers
+	Name      string
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var Gzip = Encoding{
+	Name:      "gzip",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+}
+
+// http deflate is the zlib format
+var Deflate = Encoding{
+	Name:      "deflate",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+	NewReader: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+}
+
+var Brotli = Encoding{
+	Name:      "br",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil },
+	NewReader: func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil },
+}
+
+var Zstd = Encoding{
+	Name: "zstd",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	},
+}
+
+/**
+ * Compresses the responses with the encoding negotiated by the Accept-Encoding header
+ * and decodes the compressed request bodies. Responses smaller than the minimum size
+ * or with a content type out of the allowlist are sent uncompressed.
+ */
+type Compressor struct {
+	// supported encodings in the order of preference
+	Encodings []Encoding
+	// responses smaller than this are not compressed
+	MinSize int
+	// compressed content types. entries ending with '/' match the type prefix and
+	// entries starting with '+' match the structured syntax suffix
+	ContentTypes []string
+}
+
+func NewCompressor() *Compressor {
+	return &Compressor{
+		Encodings: []Encoding{Brotli, Zstd, Gzip, Deflate},
+		MinSize:   1024,
+		ContentTypes: []string{
+			"text/",
+			"+json",
+			"+xml",
+			"application/json",
+			"application/xml",
+			"application/yaml",
+			"application/x-yaml",
+			"application/javascript",
+			"application/x-www-form-urlencoded",
+			"image/svg+xml",
+		},
+	}
+}
+
+/**
+ * Returns the encoding with the highest quality value in the header. Order of the
+ * encodings of the compressor is used for the ties.
+ *
+ * Ex: "gzip;q=0.8, br" returns br
+ */
+func (c *Compressor) Negotiate(acceptEncoding string) (encoding Encoding, acceptable bool) {
+
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = parsed
+				}
+			}
+		}
+		qualities[name] = quality
+	}
+
+	best := 0.0
+	for _, candidate := range c.Encodings {
+		quality, listed := qualities[candidate.Name]
+		if !listed {
+			quality = qualities["*"]
+		}
+		if quality > best {
+			best = quality
+			encoding = candidate
+			acceptable = true
+		}
+	}
+	return
+}
+
+func (c *Compressor) IsCompressible(contentType string) bool {
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, allowed := range c.ContentTypes {
+		switch {
+		case strings.HasSuffix(allowed, "/"):
+			if strings.HasPrefix(mediaType, allowed) {
+				return true
+			}
+		case strings.HasPrefix(allowed, "+"):
+			if strings.HasSuffix(mediaType, allowed) {
+				return true
+			}
+		case mediaType == allowed:
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns a reader decoding the body by the Content-Encoding header. Multiple encodings
+ * are decoded in the reverse order they are applied.
+ *
+ * Ex: "gzip" or "deflate, gzip"
+ */
+func (c *Compressor) NewReader(contentEncoding string, body io.Reader) (reader io.ReadCloser, err error) {
+
+	names := strings.Split(contentEncoding, ",")
+	closers := make([]io.Closer, 0, len(names))
+	reader = io.NopCloser(body)
+
+	for i := len(names) - 1; i >= 0; i-- {
+		name := strings.ToLower(strings.TrimSpace(names[i]))
+		if name == "" || name == "identity" {
+			continue
+		}
+		encoding, found := c.encoding(name)
+		if !found {
+			err = ErrUnsupportedEncoding
+			return
+		}
+		if reader, err = encoding.NewReader(reader); err != nil {
+			return
+		}
+		closers = append(closers, reader)
+	}
+
+	if len(closers) > 1 {
+		reader = multiCloser{reader, closers}
+	}
+	return
+}
+
+func (c *Compressor) encoding(name string) (encoding Encoding, found bool) {
+	for _, candidate := range c.Encodings {
+		if candidate.Name == name {
+			return candidate, true
+		}
+	}
+	return
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() (err error) {
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		if closeErr := m.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
@@ -0,0 +1,152 @@
+package compression
+
+import (
+	"io"
+	"bytes"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNegotiate(t *testing.T) {
+
+	compressor := NewCompressor()
+
+	Convey("Quality values should be respected", t, func() {
+		encoding, acceptable := compressor.Negotiate("gzip;q=0.8, deflate")
+		So(acceptable, ShouldBeTrue)
+		So(encoding.Name, ShouldEqual, "deflate")
+	})
+
+	Convey("Preference order should be used for the ties", t, func() {
+		encoding, _ := compressor.Negotiate("gzip, br")
+		So(encoding.Name, ShouldEqual, "br")
+	})
+
+	Convey("Wildcard should match the unlisted encodings", t, func() {
+		encoding, _ := compressor.Negotiate("br;q=0, *")
+		So(encoding.Name, ShouldEqual, "zstd")
+	})
+
+	Convey("Unknown or refused encodings should not be acceptable", t, func() {
+		_, acceptable := compressor.Negotiate("compress, gzip;q=0")
+		So(acceptable, ShouldBeFalse)
+		_, acceptable = compressor.Negotiate("")
+		So(acceptable, ShouldBeFalse)
+	})
+}
+
+func TestResponseWriter(t *testing.T) {
+
+	compressor := NewCompressor()
+	compressor.MinSize = 16
+	body := strings.Repeat("compressible ", 20)
+
+	serve := func(acceptEncoding string, handler func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		recorder := httptest.NewRecorder()
+		w := compressor.NewWriter(recorder, r)
+		handler(w)
+		w.Close()
+		return recorder
+	}
+
+	for _, encoding := range compressor.Encodings {
+
+		Convey("Given a client accepting "+encoding.Name, t, func() {
+			recorder := serve(encoding.Name, func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", "260")
+				io.WriteString(w, body)
+			})
+
+			Convey("Response should be compressed", func() {
+				So(recorder.Header().Get("Content-Encoding"), ShouldEqual, encoding.Name)
+				So(recorder.Header().Get("Content-Length"), ShouldEqual, "")
+				So(recorder.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+
+				reader, err := compressor.NewReader(encoding.Name, recorder.Body)
+				So(err, ShouldBeNil)
+				decoded, _ := io.ReadAll(reader)
+				So(string(decoded), ShouldEqual, body)
+			})
+		})
+	}
+
+	Convey("Small responses should not be compressed", t, func() {
+		recorder := serve("gzip", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, "{}")
+		})
+		So(recorder.Code, ShouldEqual, http.StatusCreated)
+		So(recorder.Header().Get("Content-Encoding"), ShouldEqual, "")
+		So(recorder.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+		So(recorder.Body.String(), ShouldEqual, "{}")
+	})
+
+	Convey("Content types out of the allowlist should not be compressed", t, func() {
+		recorder := serve("gzip", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, body)
+		})
+		So(recorder.Header().Get("Content-Encoding"), ShouldEqual, "")
+		So(recorder.Header().Get("Vary"), ShouldEqual, "")
+	})
+
+	Convey("Strong etags of the compressed responses should be weakened", t, func() {
+		recorder := serve("gzip", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, body)
+		})
+		So(recorder.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+		So(recorder.Header().Get("ETag"), ShouldEqual, `W/"v1"`)
+
+		recorder = serve("", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, body)
+		})
+		So(recorder.Header().Get("ETag"), ShouldEqual, `"v1"`)
+	})
+
+	Convey("Partial content should not be compressed", t, func() {
+		recorder := serve("gzip", func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-259/1000")
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, body)
+		})
+		So(recorder.Code, ShouldEqual, http.StatusPartialContent)
+		So(recorder.Header().Get("Content-Encoding"), ShouldEqual, "")
+	})
+}
+
+func TestNewReader(t *testing.T) {
+
+	compressor := NewCompressor()
+
+	Convey("Stacked encodings should be decoded in reverse order", t, func() {
+		var encoded bytes.Buffer
+		gzipWriter, _ := Gzip.NewWriter(&encoded)
+		deflateWriter, _ := Deflate.NewWriter(gzipWriter)
+		io.WriteString(deflateWriter, "hello")
+		deflateWriter.Close()
+		gzipWriter.Close()
+
+		reader, err := compressor.NewReader("deflate, gzip", &encoded)
+		So(err, ShouldBeNil)
+		decoded, _ := io.ReadAll(reader)
+		So(string(decoded), ShouldEqual, "hello")
+		So(reader.Close(), ShouldBeNil)
+	})
+
+	Convey("Unknown encodings should be rejected", t, func() {
+		_, err := compressor.NewReader("compress", strings.NewReader("data"))
+		So(err, ShouldEqual, ErrUnsupportedEncoding)
+	})
+}
@@ -0,0 +1,138 @@
+package compression
+
+import (
+	"io"
+	"strings"
+	"net/http"
+)
+
+/**
+ * Response writer compressing the body with the negotiated encoding. Writes are
+ * buffered until the minimum size is reached, so the decision is made with the
+ * final status and headers. Must be closed after the response is written.
+ */
+type ResponseWriter struct {
+	http.ResponseWriter
+	compressor *Compressor
+	encoding   Encoding
+	acceptable bool
+	status     int
+	buffer     []byte
+	decided    bool
+	writer     io.WriteCloser
+}
+
+func (c *Compressor) NewWriter(w http.ResponseWriter, r *http.Request) *ResponseWriter {
+	encoding, acceptable := c.Negotiate(r.Header.Get("Accept-Encoding"))
+	if r.Method == http.MethodHead {
+		acceptable = false
+	}
+	return &ResponseWriter{ResponseWriter: w, compressor: c, encoding: encoding, acceptable: acceptable}
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+	// responses without a body are written immediately
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *ResponseWriter) Write(data []byte) (int, error) {
+
+	if !w.decided {
+		w.buffer = append(w.buffer, data...)
+		if len(w.buffer) >= w.compressor.MinSize {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(data), nil
+	}
+
+	if w.writer != nil {
+		return w.writer.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *ResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buffer) >= w.compressor.MinSize)
+	}
+	if flusher, isFlusher := w.writer.(interface{ Flush() error }); isFlusher {
+		flusher.Flush()
+	}
+	if flusher, isFlusher := w.ResponseWriter.(http.Flusher); isFlusher {
+		flusher.Flush()
+	}
+}
+
+// writes the buffered data and completes the compressed stream
+func (w *ResponseWriter) Close() (err error) {
+	if !w.decided {
+		if err = w.decide(false); err != nil {
+			return
+		}
+	}
+	if w.writer != nil {
+		err = w.writer.Close()
+	}
+	return
+}
+
+func (w *ResponseWriter) decide(largeEnough bool) (err error) {
+
+	w.decided = true
+	header := w.Header()
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if header.Get("Content-Type") == "" && len(w.buffer) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buffer))
+	}
+
+	eligible := status >= 200 &&
+		status != http.StatusNoContent &&
+		status != http.StatusPartialContent &&
+		status != http.StatusNotModified &&
+		header.Get("Content-Range") == "" &&
+		header.Get("Content-Encoding") == "" &&
+		w.compressor.IsCompressible(header.Get("Content-Type"))
+
+	if eligible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if eligible && largeEnough && w.acceptable {
+		// response is sent uncompressed if the encoder can't be created
+		if writer, newErr := w.encoding.NewWriter(w.ResponseWriter); newErr == nil {
+			w.writer = writer
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", w.encoding.Name)
+			// strong validators must differ between the encodings
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+
+	buffer := w.buffer
+	w.buffer = nil
+	if len(buffer) == 0 {
+		return
+	}
+	if w.writer != nil {
+		_, err = w.writer.Write(buffer)
+	} else {
+		_, err = w.ResponseWriter.Write(buffer)
+	}
+	return
+}
@@ -2,13 +2,20 @@ package core
 
 import (
 	"io"
+	"mime"
+	"time"
+	"bufio"
+	"bytes"
 	"strings"
 	"net/http"
 	"encoding/json"
 
 	"github.com/rihtim/core/log"
 	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/events"
+	"github.com/rihtim/core/codecs"
 	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/compression"
 	"github.com/rihtim/core/requestscope"
 	"github.com/rihtim/core/interceptors"
 	"github.com/rihtim/core/functions"
@@ -19,28 +26,95 @@ var Functions functions.FunctionController = &functions.CoreFunctionController{}
 var Interceptors interceptors.InterceptorController = &interceptors.CoreInterceptorController{}
 var DataProvider dataprovider.Provider
 
+// resolves Message.IP. only the peers in the trusted proxy list can set the client ip via forwarding headers
+var ClientIPResolver = utils.DefaultIPResolver
+
 var BodyParserExcludedPaths map[string]bool
 
+// codecs used for decoding request bodies by Content-Type and encoding response bodies by Accept
+var Codecs = codecs.DefaultRegistry()
+
+// compresses the responses and decodes the compressed request bodies. set to nil for disabling
+var Compression = compression.NewCompressor()
+
+// returns the current time for the deletion marks, the revisions and the schedules. replaced for testing
+var Clock = time.Now
+
 func HandleHttpRequest(w http.ResponseWriter, r *http.Request) {
 
+	if Compression != nil {
+		compressingWriter := Compression.NewWriter(w, r)
+		defer compressingWriter.Close()
+		w = compressingWriter
+	}
+
 	// parse request
 	request, parseReqErr := parseRequest(r)
 	if parseReqErr != nil {
 		printError(w, parseReqErr)
 		return
 	}
+	defer removeUploads(request.Uploads)
 
 	response, _, err := HandleRequest(request, requestscope.Init())
-	buildResponse(w, response, err)
+	buildResponse(w, r, response, err)
 }
 
 func HandleRequest(request messages.Message, requestScope requestscope.RequestScope) (response messages.Message, updatedRequestScope requestscope.RequestScope, err *utils.Error) {
+	return handleRequest(request, requestScope, nil)
+}
+
+// handles the request with the provider if it's set, so the requests made while executing a request use its transaction
+func handleRequest(request messages.Message, requestScope requestscope.RequestScope, provider dataprovider.Provider) (response messages.Message, updatedRequestScope requestscope.RequestScope, err *utils.Error) {
 
 	var editedRequest, editedResponse messages.Message
 	var editedRequestScope requestscope.RequestScope
+	var completed bool
+	var storedFiles []string
+	var target messages.Message
+	var isDelegated bool
+	var publish func()
+
+	publishRequestEvent(events.RequestReceived, request, nil)
+
+	// uploaded files stored for the request are deleted if the request fails, including the commit
+	defer func() {
+		if err != nil {
+			deleteStoredFiles(storedFiles)
+		}
+	}()
+
+	// provider bound to the transaction if transactions are enabled for the resource
+	db := provider
+	var tx dataprovider.Transaction
+	if db == nil {
+		if db, tx, err = beginTransaction(request.Res); err != nil {
+			response, err = handleError(request, response, requestScope, err)
+			return
+		}
+	}
+	if tx != nil {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				tx.Rollback()
+				panic(recovered)
+			}
+			if commitErr := endTransaction(tx, err); commitErr != nil {
+				response, err = handleError(request, messages.Message{}, requestScope, commitErr)
+			} else if completed && err == nil {
+				if publish != nil {
+					publish()
+				}
+				go executeFinal(Interceptors, DataProvider, request, target, requestScope, response)
+			}
+		}()
+	}
 
 	// execute BEFORE_EXEC interceptors
-	editedRequest, editedResponse, editedRequestScope, err = Interceptors.Execute(request.Res, request.Command, interceptors.BEFORE_EXEC, requestScope, request, response, DataProvider)
+	editedRequest, editedResponse, editedRequestScope, err = Interceptors.Execute(request.Res, request.Command, interceptors.BEFORE_EXEC, requestScope, request, response, db)
+	if !editedRequestScope.IsEmpty() {
+		requestScope = editedRequestScope
+	}
 	if err != nil {
 		response, err = handleError(request, editedResponse, requestScope, err)
 		return
@@ -61,11 +135,61 @@ func HandleRequest(request messages.Message, requestScope requestscope.RequestSc
 		requestScope = editedRequestScope
 	}
 
+	if err = checkIncludeDeleted(request, requestScope); err != nil {
+		response, err = handleError(request, editedResponse, requestScope, err)
+		return
+	}
+
+	// requests on another resource run the interceptors of that resource as well. ex: /users/1/posts on /posts
+	if !Functions.Contains(request.Res, request.Command) {
+		if target, isDelegated, err = targetOf(request, db); err != nil {
+			response, err = handleError(request, editedResponse, requestScope, err)
+			return
+		}
+	}
+	if target.Res != "" {
+		editedRequest, editedResponse, editedRequestScope, err = interceptors.ExecuteExcluding(Interceptors, request.Res, request.Command, target.Res, target.Command, interceptors.BEFORE_EXEC, requestScope, target, messages.Message{}, db)
+		if !editedRequestScope.IsEmpty() {
+			requestScope = editedRequestScope
+		}
+		if err != nil {
+			response, err = handleError(request, editedResponse, requestScope, err)
+			return
+		}
+		if !editedResponse.IsEmpty() {
+			response = editedResponse
+			return
+		}
+		if isDelegated && !editedRequest.IsEmpty() {
+			target = editedRequest
+		}
+		// aggregations are executed on the query of the collection, so the edited parameters are applied. ex: where
+		if isAggregation(request.Res) && !editedRequest.IsEmpty() {
+			request.Parameters = editedRequest.Parameters
+		}
+		if !editedRequestScope.IsEmpty() {
+			requestScope = editedRequestScope
+		}
+	}
+
+	// uploaded files are stored after the interceptors accept the request
+	if isDelegated && target.Uploads != nil {
+		storedFiles, err = storeUploads(&target)
+	} else if request.Uploads != nil {
+		storedFiles, err = storeUploads(&request)
+	}
+	if err != nil {
+		response, err = handleError(request, editedResponse, requestScope, err)
+		return
+	}
+
 	// execute the request
 	if Functions.Contains(request.Res, request.Command) {
-		response, editedRequestScope, err = Functions.Execute(request, requestScope, DataProvider)
+		response, editedRequestScope, err = Functions.Execute(request, requestScope, db)
+	} else if isDelegated {
+		response, publish, err = execute(target, db)
 	} else {
-		response, editedRequestScope, err = Execute(request, DataProvider)
+		response, publish, err = execute(request, db)
 	}
 
 	if err != nil {
@@ -78,8 +202,24 @@ func HandleRequest(request messages.Message, requestScope requestscope.RequestSc
 		requestScope = editedRequestScope
 	}
 
+	// mutations are published after the commit if the request is in a transaction
+	if tx == nil && publish != nil {
+		publish()
+	}
+
+	// AFTER_EXEC interceptors of the resource the request is executed on run first
+	if isDelegated {
+		_, editedResponse, editedRequestScope, err = interceptors.ExecuteExcluding(Interceptors, request.Res, request.Command, target.Res, target.Command, interceptors.AFTER_EXEC, requestScope, target, response, db)
+		if !editedResponse.IsEmpty() {
+			response = editedResponse
+		}
+		if !editedRequestScope.IsEmpty() {
+			requestScope = editedRequestScope
+		}
+	}
+
 	// execute AFTER_EXEC interceptors
-	_, editedResponse, editedRequestScope, err = Interceptors.Execute(request.Res, request.Command, interceptors.AFTER_EXEC, requestScope, request, response, DataProvider)
+	_, editedResponse, editedRequestScope, err = Interceptors.Execute(request.Res, request.Command, interceptors.AFTER_EXEC, requestScope, request, response, db)
 
 	// update response if interceptor returned an edited response
 	if !editedResponse.IsEmpty() {
@@ -91,18 +231,61 @@ func HandleRequest(request messages.Message, requestScope requestscope.RequestSc
 		requestScope = editedRequestScope
 	}
 
-	// execute FINAL interceptors in goroutine
-	go Interceptors.Execute(request.Res, request.Command, interceptors.FINAL, requestScope, request, response, DataProvider)
+	// execute FINAL interceptors in goroutine. they are executed after the commit if the request is in a transaction
+	if tx == nil {
+		go executeFinal(Interceptors, DataProvider, request, target, requestScope, response)
+	} else {
+		completed = true
+	}
+	return
+}
 
+/**
+ * Returns the request of the resource the request is on, if it's another resource. Delegated
+ * requests are executed as the target request. Otherwise the request is executed but the
+ * BEFORE_EXEC and FINAL interceptors of the target are executed as well.
+ *
+ * Ex: GET /users/1/posts is executed as GET /posts?where={"userId":"1"}
+ *     POST /posts/1/_revert runs the interceptors of PUT /posts/1
+ */
+func targetOf(request messages.Message, db dataprovider.Provider) (target messages.Message, isDelegated bool, err *utils.Error) {
+	if command, isCommand := objectCommandOf(request.Res); isCommand && strings.EqualFold(request.Command, command.Method) {
+		target = objectRequestOf(request, command)
+	} else if isAggregation(request.Res) {
+		target = aggregatedRequestOf(request)
+		// aggregations of the sub resources run the interceptors of the child collection
+		if isSubResource(target.Res) {
+			if err = checkHidden(target.Res); err == nil {
+				target, err = resolveSubResource(target, db)
+			}
+		}
+	} else if isSubResource(request.Res) {
+		if err = checkHidden(request.Res); err != nil {
+			return
+		}
+		if target, err = resolveSubResource(request, db); err == nil {
+			isDelegated = true
+		}
+	}
 	return
 }
 
+// executes the FINAL interceptors of the request and the resource the request is on. controller and provider
+// are passed by the caller, so the goroutine uses the ones of the request
+func executeFinal(controller interceptors.InterceptorController, db dataprovider.Provider, request, target messages.Message, requestScope requestscope.RequestScope, response messages.Message) {
+	if target.Res != "" {
+		interceptors.ExecuteExcluding(controller, request.Res, request.Command, target.Res, target.Command, interceptors.FINAL, requestScope, target, response, db)
+	}
+	controller.Execute(request.Res, request.Command, interceptors.FINAL, requestScope, request, response, db)
+}
+
 func handleError(request, response messages.Message, requestScope requestscope.RequestScope, err *utils.Error) (returnedResponse messages.Message, returnedErr *utils.Error) {
 
 	returnedErr = err
 	returnedResponse = response
 
 	requestScope.Set("error", err)
+	publishRequestEvent(events.RequestFailed, request, err)
 
 	var editedResponse messages.Message
 	_, editedResponse, _, err = Interceptors.Execute(request.Res, request.Command, interceptors.ON_ERROR, requestScope, request, response, DataProvider)
@@ -123,6 +306,7 @@ func printError(w http.ResponseWriter, err *utils.Error) {
 		log.Error("Generating error message failed.")
 	}
 	log.Error(err.Message)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(err.Code)
 	io.WriteString(w, string(bytes))
 }
@@ -138,10 +322,26 @@ func parseRequest(r *http.Request) (request messages.Message, err *utils.Error)
 		return
 	}
 
-	ip, _ := utils.GetClientIPHelper(r)
+	// scheduled functions can't be requested over http
+	if strings.EqualFold(r.Method, ScheduledMethod) {
+		err = &utils.Error{Code: http.StatusMethodNotAllowed, Message: "Method not allowed."}
+		return
+	}
+
+	// reject before execution if the response can't be encoded in any of the accepted media types
+	if _, acceptable := Codecs.Negotiate(r.Header.Get("Accept")); !acceptable {
+		err = &utils.Error{
+			Code:    http.StatusNotAcceptable,
+			Message: "None of the media types in the Accept header is supported.",
+		}
+		return
+	}
+
+	ip, ipChain, _ := ClientIPResolver.Resolve(r)
 
 	request = messages.Message{
 		IP:         ip,
+		IPChain:    ipChain,
 		Res:        res,
 		Command:    strings.ToLower(r.Method),
 		Headers:    r.Header,
@@ -154,20 +354,125 @@ func parseRequest(r *http.Request) (request messages.Message, err *utils.Error)
 		return
 	}
 
-	readErr := json.NewDecoder(r.Body).Decode(&request.Body)
-	if readErr != nil && readErr != io.EOF {
-		err = &utils.Error{Code: http.StatusBadRequest, Message: "Parsing request body failed. Reason: " + readErr.Error()}
+	// return if the body is empty
+	if r.Body == nil {
+		return
+	}
+
+	// size of the body is limited before decoding, so the multipart and file bodies are limited as well
+	limits := bodyLimitsOf(res)
+	if limits.MaxBodySize > 0 {
+		if r.ContentLength > limits.MaxBodySize {
+			err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Request body is too large."}
+			return
+		}
+		r.Body = http.MaxBytesReader(nil, r.Body, limits.MaxBodySize)
+		request.ReqBodyRaw = r.Body
+	}
+
+	if contentEncoding := r.Header.Get("Content-Encoding"); contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+		if Compression == nil {
+			err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "Content encoding of the request body is not supported."}
+			return
+		}
+		decoded, decodeErr := Compression.NewReader(contentEncoding, r.Body)
+		if decodeErr == compression.ErrUnsupportedEncoding {
+			err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "Content encoding of the request body is not supported."}
+			return
+		}
+		if decodeErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Decoding request body failed. Reason: " + decodeErr.Error()}
+			return
+		}
+		r.Body = decoded
+		if limits.MaxBodySize > 0 {
+			r.Body = http.MaxBytesReader(nil, decoded, limits.MaxBodySize)
+		}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		request.ReqBodyRaw = r.Body
+	}
+	body := bufio.NewReader(r.Body)
+	if _, peekErr := body.Peek(1); peekErr != nil {
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, params, parseErr := mime.ParseMediaType(contentType); parseErr == nil && mediaType == "multipart/form-data" {
+		err = parseMultipart(body, params["boundary"], &request)
+		return
+	}
+
+	// raw body is stored as the file content on the file mount paths
+	if _, _, isFileResource := fileResource(res); isFileResource {
+		request.ReqBodyRaw = io.NopCloser(body)
+		return
+	}
+
+	codec, supported := Codecs.ForContentType(contentType)
+	if !supported {
+		err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "Content type of the request body is not supported."}
+		return
+	}
+
+	// raw json of the aggregations is kept, so the fields of the $sort stages are sorted in their order
+	var reader io.Reader = body
+	if _, isJSON := codec.(*codecs.JSON); isJSON && isAggregation(res) {
+		data, readErr := io.ReadAll(body)
+		if readErr != nil {
+			err = readError(readErr)
+			return
+		}
+		request.RawBody = data
+		reader = bytes.NewReader(data)
+	}
+
+	decoded, err := decodeBody(reader, codec, limits)
+	if err != nil {
+		return
+	}
+
+	switch typed := decoded.(type) {
+	case nil:
+	case map[string]interface{}:
+		request.Body = typed
+	case []interface{}:
+		// arrays of objects are used for the bulk requests
+		request.Items = make([]map[string]interface{}, len(typed))
+		for i, item := range typed {
+			var isObject bool
+			if request.Items[i], isObject = item.(map[string]interface{}); !isObject {
+				err = &utils.Error{Code: http.StatusBadRequest, Message: "Items of the request body must be objects."}
+				return
+			}
+		}
+	default:
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Request body must be an object or an array of objects."}
 	}
 	return
 }
 
-func buildResponse(w http.ResponseWriter, response messages.Message, err *utils.Error) {
+func buildResponse(w http.ResponseWriter, r *http.Request, response messages.Message, err *utils.Error) {
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// response is encoded with the codec negotiated by the Accept header of the request
+	codec, acceptable := Codecs.Negotiate(r.Header.Get("Accept"))
+	if !acceptable {
+		codec = Codecs.Default
+	}
+
+	w.Header().Set("Content-Type", codec.ContentType())
 	for k, v := range response.Headers {
 		w.Header().Set(k, v[0])
 	}
 
+	if response.RawBodyReader != nil {
+		defer response.RawBodyReader.Close()
+		if err == nil {
+			writeStream(w, r, response)
+			return
+		}
+	}
+
 	if err != nil {
 		if response.Status == 0 {
 			response.Status = err.Code
@@ -177,6 +482,20 @@ func buildResponse(w http.ResponseWriter, response messages.Message, err *utils.
 		}
 	}
 
+	var encodedBody bytes.Buffer
+	if response.Body != nil {
+		if encodeErr := codec.Encode(&encodedBody, response.Body); encodeErr != nil {
+			log.Error("Encoding response body failed. Reason: " + encodeErr.Error())
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if limit := bodyLimitsOf(strings.TrimRight(r.URL.Path, "/")).MaxResponseSize; limit > 0 && int64(encodedBody.Len()+len(response.RawBody)) > limit {
+		printError(w, &utils.Error{Code: http.StatusInternalServerError, Message: "Response body is too large."})
+		return
+	}
+
 	if response.Status != 0 {
 		// http panics if the response code is not in this range
 		if response.Status < 100 || response.Status > 999 {
@@ -190,12 +509,33 @@ func buildResponse(w http.ResponseWriter, response messages.Message, err *utils.
 		w.Write(response.RawBody)
 	}
 
-	if response.Body != nil {
-		bytes, encodeErr := json.Marshal(response.Body)
-		if encodeErr != nil {
-			log.Error("Encoding response body failed.")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
-		io.WriteString(w, string(bytes))
+	if encodedBody.Len() > 0 {
+		w.Write(encodedBody.Bytes())
+	}
+}
+
+/**
+ * Writes the stream of the response. Seekable streams are served with support for
+ * byte ranges and conditional requests validated by the 'ETag' and 'Last-Modified'
+ * headers of the response.
+ */
+func writeStream(w http.ResponseWriter, r *http.Request, response messages.Message) {
+
+	// let the content type be detected if it's not set by the function
+	if _, hasContentType := response.Headers["Content-Type"]; !hasContentType {
+		w.Header().Del("Content-Type")
+	}
+
+	if seeker, isSeekable := response.RawBodyReader.(io.ReadSeeker); isSeekable {
+		modTime, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+		http.ServeContent(w, r, "", modTime, seeker)
+		return
+	}
+
+	if response.Status >= 100 && response.Status <= 999 {
+		w.WriteHeader(response.Status)
+	}
+	if _, copyErr := io.Copy(w, response.RawBodyReader); copyErr != nil {
+		log.Error("Writing response stream failed. Reason: " + copyErr.Error())
 	}
 }
@@ -0,0 +1,465 @@
+package core
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"bytes"
+	"strconv"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"compress/gzip"
+	"mime/multipart"
+	"net/http/httptest"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/functions"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/dataprovider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fileRecordingProvider struct {
+	dataprovider.Provider
+	files   [][]byte
+	deleted []string
+}
+
+func (p *fileRecordingProvider) DeleteFile(id string) (err *utils.Error) {
+	p.deleted = append(p.deleted, id)
+	return
+}
+
+func (p *fileRecordingProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+	content, readErr := io.ReadAll(data)
+	if readErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: readErr.Error()}
+		return
+	}
+	p.files = append(p.files, content)
+	response = map[string]interface{}{"_id": "file1"}
+	return
+}
+
+// in memory provider supporting equality and $in filters in the 'where' parameter, skip and limit
+type memoryProvider struct {
+	dataprovider.Provider
+	collections map[string]map[string]map[string]interface{}
+	nextID      int
+}
+
+func newMemoryProvider() *memoryProvider {
+	return &memoryProvider{collections: make(map[string]map[string]map[string]interface{})}
+}
+
+func (p *memoryProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	if p.collections[collection] == nil {
+		p.collections[collection] = make(map[string]map[string]interface{})
+	}
+	p.nextID++
+	object := map[string]interface{}{"_id": strconv.Itoa(p.nextID)}
+	for key, value := range data {
+		object[key] = value
+	}
+	p.collections[collection][object["_id"].(string)] = object
+	return object, nil
+}
+
+func (p *memoryProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	object, exists := p.collections[collection][id]
+	if !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+		return
+	}
+	response = make(map[string]interface{})
+	for key, value := range object {
+		response[key] = value
+	}
+	return
+}
+
+func (p *memoryProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	where := make(map[string]interface{})
+	if values, hasWhere := parameters["where"]; hasWhere {
+		json.Unmarshal([]byte(values[0]), &where)
+	}
+
+	ids := make([]string, 0)
+	for id := range p.collections[collection] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	results := make([]interface{}, 0)
+	for _, id := range ids {
+		object := p.collections[collection][id]
+		if matches(object, where) {
+			copied, _ := p.Get(collection, id)
+			results = append(results, copied)
+		}
+	}
+
+	if values, hasSkip := parameters["skip"]; hasSkip {
+		skip, _ := strconv.Atoi(values[0])
+		if skip > len(results) {
+			skip = len(results)
+		}
+		results = results[skip:]
+	}
+	if values, hasLimit := parameters["limit"]; hasLimit {
+		if limit, _ := strconv.Atoi(values[0]); limit < len(results) {
+			results = results[:limit]
+		}
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+func matches(object, where map[string]interface{}) bool {
+	for field, condition := range where {
+		if operators, isOperator := condition.(map[string]interface{}); isOperator {
+			if values, hasIn := operators["$in"].([]interface{}); hasIn {
+				found := false
+				for _, value := range values {
+					found = found || fmt.Sprint(value) == fmt.Sprint(object[field])
+				}
+				if !found {
+					return false
+				}
+			}
+			continue
+		}
+		if fmt.Sprint(condition) != fmt.Sprint(object[field]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *memoryProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	object, exists := p.collections[collection][id]
+	if !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+		return
+	}
+	for key, value := range data {
+		object[key] = value
+	}
+	return p.Get(collection, id)
+}
+
+func (p *memoryProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	if _, exists := p.collections[collection][id]; !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+		return
+	}
+	delete(p.collections[collection], id)
+	return
+}
+
+func newMultipartRequest(fields map[string]string, fileName string, fileContent []byte) *http.Request {
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	part, _ := writer.CreateFormFile("photo", fileName)
+	part.Write(fileContent)
+	writer.Close()
+
+	r, _ := http.NewRequest(http.MethodPost, "/photos", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.RemoteAddr = "127.0.0.1:1234"
+	return r
+}
+
+func TestParseRequest(t *testing.T) {
+
+	Convey("Given a multipart request", t, func() {
+		provider := &fileRecordingProvider{}
+		DataProvider = provider
+		defer func() { DataProvider = nil }()
+
+		r := newMultipartRequest(map[string]string{"title": "holiday"}, "beach.jpg", []byte("image-bytes"))
+
+		Convey("When the request is parsed", func() {
+			request, err := parseRequest(r)
+			defer removeUploads(request.Uploads)
+
+			Convey("Fields should be added into the body", func() {
+				So(err, ShouldBeNil)
+				So(request.Body["title"], ShouldEqual, "holiday")
+				So(request.MultipartForm.Value["title"], ShouldResemble, []string{"holiday"})
+			})
+
+			Convey("File should not be stored before the execution", func() {
+				So(provider.files, ShouldBeEmpty)
+				So(request.Uploads, ShouldHaveLength, 1)
+				So(request.Uploads[0].Size, ShouldEqual, 11)
+				So(request.MultipartForm.File["photo"][0].Filename, ShouldEqual, "beach.jpg")
+			})
+
+			Convey("File metadata should be added into the body when the file is stored", func() {
+				stored, err := storeUploads(&request)
+				So(err, ShouldBeNil)
+				So(stored, ShouldResemble, []string{"file1"})
+				So(provider.files, ShouldResemble, [][]byte{[]byte("image-bytes")})
+
+				metadata := request.Body["photo"].(map[string]interface{})
+				So(metadata["_id"], ShouldEqual, "file1")
+				So(metadata["filename"], ShouldEqual, "beach.jpg")
+				So(metadata["size"], ShouldEqual, 11)
+			})
+		})
+
+		Convey("When the request is rejected by an interceptor", func() {
+			Interceptors.Add("/photos", methods.Post, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusUnauthorized, Message: "Unauthorized."}
+				return
+			}, nil)
+			defer func() { Interceptors = &interceptors.CoreInterceptorController{} }()
+
+			request, _ := parseRequest(r)
+			defer removeUploads(request.Uploads)
+			_, _, err := HandleRequest(request, requestscope.Init())
+
+			Convey("File should not be stored", func() {
+				So(err.Code, ShouldEqual, http.StatusUnauthorized)
+				So(provider.files, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the execution fails after the file is stored", func() {
+			Functions.Add("/photos", methods.Post, func(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusConflict, Message: "Photo exists."}
+				return
+			}, nil)
+			defer func() { Functions = &functions.CoreFunctionController{} }()
+
+			request, _ := parseRequest(r)
+			defer removeUploads(request.Uploads)
+			HandleRequest(request, requestscope.Init())
+
+			Convey("Stored file should be deleted", func() {
+				So(provider.files, ShouldHaveLength, 1)
+				So(provider.deleted, ShouldResemble, []string{"file1"})
+			})
+		})
+
+		Convey("When the file exceeds the size limit", func() {
+			MultipartMaxFileSize = 4
+			defer func() { MultipartMaxFileSize = 0 }()
+
+			_, err := parseRequest(r)
+
+			Convey("It should return request entity too large", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			})
+		})
+		Convey("When the body exceeds the body size limit", func() {
+			SetBodyLimits("/photos", BodyLimits{MaxBodySize: 64})
+			defer func() { routeLimits = nil }()
+
+			r.ContentLength = -1
+			_, err := parseRequest(r)
+
+			Convey("It should return request entity too large", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			})
+		})
+	})
+
+	Convey("Given a request with an unsupported content type", t, func() {
+		r, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString("%PDF"))
+		r.Header.Set("Content-Type", "application/pdf")
+		r.RemoteAddr = "127.0.0.1:1234"
+
+		_, err := parseRequest(r)
+
+		Convey("It should return unsupported media type", func() {
+			So(err.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+	})
+
+	Convey("Given a gzip compressed request", t, func() {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write([]byte(`{"name":"john"}`))
+		writer.Close()
+
+		r, _ := http.NewRequest(http.MethodPost, "/users", &compressed)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Encoding", "gzip")
+		r.RemoteAddr = "127.0.0.1:1234"
+
+		request, err := parseRequest(r)
+
+		Convey("Body should be decoded", func() {
+			So(err, ShouldBeNil)
+			So(request.Body["name"], ShouldEqual, "john")
+		})
+	})
+
+	Convey("Given a request with an unsupported content encoding", t, func() {
+		r, _ := http.NewRequest(http.MethodPost, "/users", bytes.NewBufferString("data"))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Encoding", "compress")
+		r.RemoteAddr = "127.0.0.1:1234"
+
+		_, err := parseRequest(r)
+
+		Convey("It should return unsupported media type", func() {
+			So(err.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+	})
+
+	Convey("Given a request accepting an unsupported media type", t, func() {
+		r, _ := http.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set("Accept", "image/png")
+		r.RemoteAddr = "127.0.0.1:1234"
+
+		_, err := parseRequest(r)
+
+		Convey("It should return not acceptable", func() {
+			So(err.Code, ShouldEqual, http.StatusNotAcceptable)
+		})
+	})
+}
+
+func TestBuildResponse(t *testing.T) {
+
+	Convey("Given a file stream response", t, func() {
+		response := messages.Message{
+			Headers:       map[string][]string{"Content-Type": {"text/plain"}, "ETag": {`"v1"`}},
+			RawBodyReader: readSeekNopCloser{bytes.NewReader([]byte("hello world"))},
+		}
+		r, _ := http.NewRequest(http.MethodGet, "/files/abc", nil)
+		w := httptest.NewRecorder()
+
+		Convey("When a range is requested", func() {
+			r.Header.Set("Range", "bytes=6-")
+			buildResponse(w, r, response, nil)
+
+			Convey("Partial content should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusPartialContent)
+				So(w.Body.String(), ShouldEqual, "world")
+				So(w.Header().Get("Content-Range"), ShouldEqual, "bytes 6-10/11")
+			})
+		})
+
+		Convey("When the etag matches", func() {
+			r.Header.Set("If-None-Match", `"v1"`)
+			buildResponse(w, r, response, nil)
+
+			Convey("Not modified should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotModified)
+				So(w.Body.Len(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the whole file is requested", func() {
+			buildResponse(w, r, response, nil)
+
+			Convey("Content should be streamed with its type and length", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldEqual, "hello world")
+				So(w.Header().Get("Content-Type"), ShouldEqual, "text/plain")
+				So(w.Header().Get("Content-Length"), ShouldEqual, "11")
+			})
+		})
+	})
+}
+
+type fileProvider struct {
+	dataprovider.Provider
+	content []byte
+}
+
+func (p *fileProvider) GetFile(id string) (response []byte, err *utils.Error) {
+	return p.content, nil
+}
+
+type fileStreamingProvider struct {
+	fileProvider
+}
+
+func (p *fileStreamingProvider) GetFileReader(id string) (reader io.ReadSeekCloser, err *utils.Error) {
+	return readSeekNopCloser{bytes.NewReader(p.content)}, nil
+}
+
+type fileStatingProvider struct {
+	fileStreamingProvider
+}
+
+func (p *fileStatingProvider) StatFile(id string) (info map[string]interface{}, err *utils.Error) {
+	return map[string]interface{}{"name": "notes.txt", "contentType": "text/plain", "size": len(p.content), "checksum": "0cc175b9"}, nil
+}
+
+func TestDownloadFile(t *testing.T) {
+
+	Convey("Given the files of the data provider", t, func() {
+		content := []byte("<html><body>hello</body></html>")
+		request := messages.Message{Res: "/files/abc", Command: methods.Get, Parameters: map[string][]string{"download": {"true"}}}
+
+		Convey("Streamed and loaded files should be served with the same headers", func() {
+			loaded, err := downloadFile(request, "abc", &fileProvider{content: content})
+			So(err, ShouldBeNil)
+			streamed, err := downloadFile(request, "abc", &fileStreamingProvider{fileProvider{content: content}})
+			So(err, ShouldBeNil)
+
+			So(loaded.Headers["Content-Type"], ShouldResemble, []string{"text/html; charset=utf-8"})
+			So(loaded.Headers["Content-Disposition"], ShouldResemble, []string{"attachment"})
+			So(loaded.Headers["ETag"], ShouldResemble, []string{`W/"abc-1f"`})
+			So(streamed.Headers, ShouldResemble, loaded.Headers)
+
+			body, _ := io.ReadAll(streamed.RawBodyReader)
+			So(body, ShouldResemble, content)
+		})
+
+		Convey("Metadata of the files should be taken from the provider", func() {
+			provider := &fileStatingProvider{fileStreamingProvider{fileProvider{content: content}}}
+			response, err := downloadFile(request, "abc", provider)
+			So(err, ShouldBeNil)
+			So(response.Headers["Content-Type"], ShouldResemble, []string{"text/plain"})
+			So(response.Headers["Content-Disposition"], ShouldResemble, []string{`attachment; filename=notes.txt`})
+			So(response.Headers["ETag"], ShouldResemble, []string{`"0cc175b9"`})
+		})
+	})
+}
+
+func TestHandleRequest(t *testing.T) {
+
+	Convey("Given an interceptor editing the request scope and a failing interceptor after it", t, func() {
+		DataProvider = newMemoryProvider()
+		Interceptors = &interceptors.CoreInterceptorController{}
+		defer func() { Interceptors = &interceptors.CoreInterceptorController{}; DataProvider = nil }()
+
+		Interceptors.Add("/orders", methods.Post, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			editedRs = rs.Copy()
+			editedRs.Set("reservation", "order1")
+			return
+		}, nil)
+		Interceptors.Add("/orders", methods.Post, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			err = &utils.Error{Code: http.StatusForbidden, Message: "Forbidden."}
+			return
+		}, nil)
+
+		var released interface{}
+		Interceptors.Add("/orders", methods.Post, interceptors.ON_ERROR, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			released = rs.Get("reservation")
+			return
+		}, nil)
+
+		_, _, err := HandleRequest(messages.Message{Res: "/orders", Command: methods.Post, Body: map[string]interface{}{}}, requestscope.Init())
+
+		Convey("ON_ERROR interceptors should get the request scope edited before the failure", func() {
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+			So(released, ShouldEqual, "order1")
+		})
+	})
+}
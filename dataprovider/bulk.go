@@ -0,0 +1,103 @@
+package dataprovider
+
+import (
+	"net/http"
+	"github.com/rihtim/core/utils"
+)
+
+// field of the object ids
+var IdField = "_id"
+
+// field of the objects in the query responses
+var ResultsField = "results"
+
+// result of an item in a bulk operation. either the response or the error is set
+type BulkResult struct {
+	ID       string
+	Response map[string]interface{}
+	Err      *utils.Error
+}
+
+/**
+ * Optional interface for the providers that can execute the bulk operations natively.
+ * Results must be in the order of the items or ids. Operations are executed one by
+ * one with the Provider methods for the other providers.
+ */
+type BulkProvider interface {
+	CreateMany(collection string, items []map[string]interface{}) (results []BulkResult, err *utils.Error)
+	UpdateMany(collection string, ids []string, data map[string]interface{}) (results []BulkResult, err *utils.Error)
+	DeleteMany(collection string, ids []string) (results []BulkResult, err *utils.Error)
+}
+
+func CreateMany(db Provider, collection string, items []map[string]interface{}) (results []BulkResult, err *utils.Error) {
+
+	if bulkProvider, isBulkProvider := db.(BulkProvider); isBulkProvider {
+		return bulkProvider.CreateMany(collection, items)
+	}
+
+	results = make([]BulkResult, len(items))
+	for i, item := range items {
+		results[i].Response, results[i].Err = db.Create(collection, item)
+		if id, hasID := results[i].Response[IdField].(string); hasID {
+			results[i].ID = id
+		}
+	}
+	return
+}
+
+func UpdateMany(db Provider, collection string, ids []string, data map[string]interface{}) (results []BulkResult, err *utils.Error) {
+
+	if bulkProvider, isBulkProvider := db.(BulkProvider); isBulkProvider {
+		return bulkProvider.UpdateMany(collection, ids, data)
+	}
+
+	results = make([]BulkResult, len(ids))
+	for i, id := range ids {
+		// each update gets its own copy since providers may modify the data
+		copied := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			copied[key] = value
+		}
+		results[i].ID = id
+		results[i].Response, results[i].Err = db.Update(collection, id, copied)
+	}
+	return
+}
+
+func DeleteMany(db Provider, collection string, ids []string) (results []BulkResult, err *utils.Error) {
+
+	if bulkProvider, isBulkProvider := db.(BulkProvider); isBulkProvider {
+		return bulkProvider.DeleteMany(collection, ids)
+	}
+
+	results = make([]BulkResult, len(ids))
+	for i, id := range ids {
+		results[i].ID = id
+		results[i].Response, results[i].Err = db.Delete(collection, id)
+	}
+	return
+}
+
+// returns the ids of the objects in a query response
+func IdsOfResults(response map[string]interface{}) (ids []string, err *utils.Error) {
+
+	ids = make([]string, 0)
+	results, _ := response[ResultsField].([]interface{})
+	if typed, isTyped := response[ResultsField].([]map[string]interface{}); isTyped {
+		for _, result := range typed {
+			results = append(results, result)
+		}
+	}
+
+	for _, result := range results {
+		object, isObject := result.(map[string]interface{})
+		if !isObject {
+			err = &utils.Error{Code: http.StatusInternalServerError, Message: "Query response of the data provider is not valid."}
+			return
+		}
+		if id, hasID := object[IdField].(string); hasID {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package dataprovider
+
+import (
+	"encoding/json"
+)
+
+// returns the objects in the results of the query response
+func ObjectsOf(response map[string]interface{}) (objects []map[string]interface{}) {
+
+	objects = make([]map[string]interface{}, 0)
+	switch results := response[ResultsField].(type) {
+	case []map[string]interface{}:
+		objects = append(objects, results...)
+	case []interface{}:
+		for _, result := range results {
+			if object, isObject := result.(map[string]interface{}); isObject {
+				objects = append(objects, object)
+			}
+		}
+	}
+	return
+}
+
+/**
+ * Converts the value to an object of the provider with its json encoding, so the
+ * objects are stored with the json names of the fields.
+ *
+ * Ex: object := dataprovider.ToObject(job)
+ */
+func ToObject(value interface{}) (object map[string]interface{}) {
+	encoded, _ := json.Marshal(value)
+	json.Unmarshal(encoded, &object)
+	return
+}
+
+/**
+ * Decodes the object of the provider into the value with its json encoding. Fields
+ * without json names, like the ids, are set by the callers.
+ *
+ * Ex: err := dataprovider.FromObject(object, &job)
+ */
+func FromObject(object map[string]interface{}, value interface{}) (err error) {
+	encoded, err := json.Marshal(object)
+	if err == nil {
+		err = json.Unmarshal(encoded, value)
+	}
+	return
+}
@@ -15,3 +15,41 @@ type Provider interface {
 	CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error)
 	GetFile(id string) (response []byte, err *utils.Error)
 }
+
+// optional interface for the providers that can stream files without loading them into memory
+type FileStreamer interface {
+	GetFileReader(id string) (reader io.ReadSeekCloser, err *utils.Error)
+}
+
+// optional interface for the providers keeping the metadata of the files. keys are the fields of filestorage.FileInfo
+type FileStater interface {
+	StatFile(id string) (info map[string]interface{}, err *utils.Error)
+}
+
+// optional interface for the providers that can delete the files
+type FileDeleter interface {
+	DeleteFile(id string) (err *utils.Error)
+}
+
+// provider bound to a transaction. changes are visible to the other providers after the commit
+type Transaction interface {
+	Provider
+	Commit() (err *utils.Error)
+	Rollback() (err *utils.Error)
+}
+
+// optional interface for the providers supporting transactions
+type TransactionalProvider interface {
+	Begin() (tx Transaction, err *utils.Error)
+}
+
+/**
+ * Optional interface for the providers that can update the objects conditionally. The object
+ * is updated only if it matches the where filter at the time of the update, so the objects
+ * can be claimed safely by multiple processes.
+ *
+ * Ex: updated, err := provider.UpdateIf("jobs", "5", map[string]interface{}{"status": "pending"}, data)
+ */
+type ConditionalUpdater interface {
+	UpdateIf(collection string, id string, where map[string]interface{}, data map[string]interface{}) (updated bool, err *utils.Error)
+}
@@ -0,0 +1,61 @@
+package dataprovider
+
+import (
+	"fmt"
+	"strconv"
+	"github.com/rihtim/core/utils"
+)
+
+// parameter of the queries ordering the results. fields are separated by commas, descending ones start with '-'
+var SortParameter = "sort"
+
+/**
+ * Reads the objects matching the parameters page by page with the 'skip' and 'limit'
+ * parameters. If max is positive, reading stops after max objects and exceeded is
+ * set when there are more.
+ *
+ * Ex: objects, _, err := dataprovider.QueryAll(db, "jobs", map[string][]string{"sort": {"runAt"}}, 1000, 0)
+ */
+func QueryAll(db Provider, collection string, parameters map[string][]string, pageSize, max int) (objects []map[string]interface{}, exceeded bool, err *utils.Error) {
+	return QueryPages(func(paged map[string][]string) (map[string]interface{}, *utils.Error) {
+		return db.Query(collection, paged)
+	}, parameters, pageSize, max)
+}
+
+// reads the pages with the query function. used for the queries executed as requests as well
+func QueryPages(query func(parameters map[string][]string) (response map[string]interface{}, err *utils.Error), parameters map[string][]string, pageSize, max int) (objects []map[string]interface{}, exceeded bool, err *utils.Error) {
+
+	if max > 0 && max+1 < pageSize {
+		pageSize = max + 1
+	}
+
+	paged := make(map[string][]string, len(parameters)+2)
+	for key, values := range parameters {
+		paged[key] = values
+	}
+	paged["limit"] = []string{strconv.Itoa(pageSize)}
+
+	previousFirst := ""
+	for skip := 0; ; skip += pageSize {
+		paged["skip"] = []string{strconv.Itoa(skip)}
+		var response map[string]interface{}
+		if response, err = query(paged); err != nil {
+			return
+		}
+
+		page := ObjectsOf(response)
+		// providers ignoring the paging parameters return the same objects for each page
+		if len(page) > 0 && skip > 0 && fmt.Sprint(page[0][IdField]) == previousFirst {
+			break
+		}
+		objects = append(objects, page...)
+		if max > 0 && len(objects) > max {
+			return objects[:max], true, nil
+		}
+		if len(page) != pageSize {
+			break
+		}
+		previousFirst = fmt.Sprint(page[0][IdField])
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"strings"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/events"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+)
+
+/**
+ * Events of the requests and the objects mutated by them are published to the bus.
+ *
+ * Ex: core.Events = events.NewBus()
+ *     core.Events.Subscribe(events.Filter{Types: []events.Type{events.ObjectCreated}, Resource: "/posts/{id}"}, events.Async, handler)
+ */
+var Events *events.Bus
+
+var objectEventTypes = map[string]events.Type{
+	methods.Post:   events.ObjectCreated,
+	methods.Put:    events.ObjectUpdated,
+	methods.Patch:  events.ObjectUpdated,
+	methods.Delete: events.ObjectDeleted,
+}
+
+func publishRequestEvent(eventType events.Type, request messages.Message, err *utils.Error) {
+	if Events == nil {
+		return
+	}
+	Events.Publish(events.Event{Type: eventType, Resource: request.Res, Command: request.Command, Request: request, Error: err})
+}
+
+// publishes an event for each object mutated by the request
+func publishObjectEvents(request messages.Message, ids []string, objects []map[string]interface{}) {
+
+	if Events == nil {
+		return
+	}
+	eventType, isMutation := objectEventTypes[strings.ToLower(request.Command)]
+	if !isMutation {
+		return
+	}
+
+	class := strings.Split(request.Res, "/")[1]
+	published := make([]events.Event, len(ids))
+	for i, id := range ids {
+		published[i] = events.Event{
+			Type:       eventType,
+			Resource:   "/" + class + "/" + id,
+			Collection: class,
+			Command:    strings.ToLower(request.Command),
+			Object:     objects[i],
+			Request:    request,
+		}
+	}
+	Events.Publish(published...)
+}
+
+// returns the ids and the current objects mutated by the request. objects of the deleted ids are nil
+func mutatedObjects(request, response messages.Message, db dataprovider.Provider) (ids []string, objects []map[string]interface{}) {
+
+	class := strings.Split(request.Res, "/")[1]
+	isDelete := strings.EqualFold(request.Command, methods.Delete)
+
+	ids = mutatedIds(request, response)
+	objects = make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		if !isDelete {
+			objects[i], _ = db.Get(class, id)
+		}
+	}
+	return
+}
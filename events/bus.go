@@ -0,0 +1,187 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"hash/fnv"
+	"github.com/rihtim/core/log"
+)
+
+type Handler func(event Event)
+
+type Delivery int
+
+const (
+	// handler is called by the publisher before the publish returns
+	Sync Delivery = iota
+	// handler is called in the background
+	Async
+)
+
+type subscription struct {
+	id       int
+	filter   Filter
+	delivery Delivery
+	handler  Handler
+}
+
+type delivery struct {
+	event    Event
+	handlers []Handler
+}
+
+/**
+ * In-process publish/subscribe bus. Sync handlers are called in the order of the
+ * subscriptions before the publish returns. Async handlers are called by the shard
+ * workers, events of the same resource are always handled by the same worker, so
+ * each handler receives the events of a resource in the published order.
+ *
+ * Ex: bus.Subscribe(events.Filter{Types: []events.Type{events.ObjectCreated}}, events.Async, handler)
+ */
+type Bus struct {
+	// number of the workers of the async handlers and the queue size of each worker
+	Shards    int
+	QueueSize int
+	Now       func() time.Time
+
+	lock          sync.RWMutex
+	subscriptions []subscription
+	lastID        int
+	queues        []chan delivery
+	pending       sync.WaitGroup
+	closed        bool
+}
+
+func NewBus() *Bus {
+	return &Bus{Shards: 8, QueueSize: 256, Now: time.Now}
+}
+
+// adds the handler for the events matching the filter. returned function removes the subscription
+func (b *Bus) Subscribe(filter Filter, mode Delivery, handler Handler) (unsubscribe func()) {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastID++
+	id := b.lastID
+	b.subscriptions = append(b.subscriptions, subscription{id, filter.compiled(), mode, handler})
+
+	return func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		for i, subscription := range b.subscriptions {
+			if subscription.id == id {
+				b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// delivers the events to the matching subscriptions. blocks while the queue of the shard is full
+func (b *Bus) Publish(events ...Event) {
+
+	for _, event := range events {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = b.Now()
+		}
+
+		var syncHandlers, asyncHandlers []Handler
+		b.lock.RLock()
+		for _, subscription := range b.subscriptions {
+			if !subscription.filter.Matches(event) {
+				continue
+			}
+			if subscription.delivery == Async {
+				asyncHandlers = append(asyncHandlers, subscription.handler)
+			} else {
+				syncHandlers = append(syncHandlers, subscription.handler)
+			}
+		}
+		b.lock.RUnlock()
+
+		for _, handler := range syncHandlers {
+			call(handler, event)
+		}
+		if len(asyncHandlers) > 0 {
+			b.enqueue(delivery{event, asyncHandlers})
+		}
+	}
+}
+
+func (b *Bus) enqueue(d delivery) {
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		log.Warning("Event '" + string(d.event.Type) + "' is dropped, the bus is closed.")
+		return
+	}
+	if b.queues == nil {
+		b.startWorkers()
+	}
+	b.pending.Add(1)
+	queue := b.queues[shardOf(d.event.Resource, len(b.queues))]
+	b.lock.Unlock()
+
+	queue <- d
+}
+
+func (b *Bus) startWorkers() {
+
+	shards := b.Shards
+	if shards < 1 {
+		shards = 1
+	}
+	b.queues = make([]chan delivery, shards)
+	for i := range b.queues {
+		b.queues[i] = make(chan delivery, b.QueueSize)
+		go func(queue chan delivery) {
+			for d := range queue {
+				for _, handler := range d.handlers {
+					call(handler, d.event)
+				}
+				b.pending.Done()
+			}
+		}(b.queues[i])
+	}
+}
+
+func shardOf(resource string, shards int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(resource))
+	return int(hash.Sum32() % uint32(shards))
+}
+
+// panics of the handlers are logged instead of stopping the publisher or the workers
+func call(handler Handler, event Event) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Error(fmt.Sprintf("Handler of the event '%s' panicked: %v", event.Type, recovered))
+		}
+	}()
+	handler(event)
+}
+
+// waits until the queued events are handled
+func (b *Bus) Wait() {
+	b.pending.Wait()
+}
+
+// handles the queued events and stops the workers. events published afterwards are delivered to the sync handlers only
+func (b *Bus) Close() {
+
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	b.lock.Unlock()
+
+	b.pending.Wait()
+	for _, queue := range b.queues {
+		close(queue)
+	}
+}
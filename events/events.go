@@ -0,0 +1,72 @@
+package events
+
+import (
+	"time"
+	"regexp"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+)
+
+type Type string
+
+const (
+	RequestReceived Type = "request.received"
+	ObjectCreated   Type = "object.created"
+	ObjectUpdated   Type = "object.updated"
+	ObjectDeleted   Type = "object.deleted"
+	RequestFailed   Type = "request.failed"
+)
+
+/**
+ * Event published by core. Object events have the collection and the object, which
+ * is nil for the deleted objects. Failed requests have the error.
+ */
+type Event struct {
+	Type       Type
+	Resource   string
+	Collection string
+	Command    string
+	Object     map[string]interface{}
+	Request    messages.Message
+	Error      *utils.Error
+	Timestamp  time.Time
+}
+
+/**
+ * Filter of the subscriptions. Empty fields match all the events. Resource is a rich
+ * url matched against the resource of the event.
+ *
+ * Ex: events.Filter{Types: []events.Type{events.ObjectCreated}, Resource: "/posts/{id}"}
+ */
+type Filter struct {
+	Types    []Type
+	Resource string
+	resource *regexp.Regexp
+}
+
+func (f Filter) Matches(event Event) bool {
+	if len(f.Types) > 0 {
+		matched := false
+		for _, eventType := range f.Types {
+			if eventType == event.Type {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.resource != nil {
+		return f.resource.MatchString(event.Resource)
+	}
+	return f.Resource == "" || f.Resource == "*" || f.Resource == event.Resource
+}
+
+// compiles the resource pattern of the filter
+func (f Filter) compiled() Filter {
+	if f.Resource != "" && f.Resource != "*" {
+		f.resource, _ = regexp.Compile(utils.ConvertRichUrlToRegex(f.Resource, true))
+	}
+	return f
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"sync"
+	"strconv"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBus(t *testing.T) {
+
+	Convey("Given a bus", t, func() {
+		bus := NewBus()
+		defer bus.Close()
+
+		Convey("Sync handlers should be called before the publish returns", func() {
+			received := []Event{}
+			bus.Subscribe(Filter{}, Sync, func(event Event) {
+				received = append(received, event)
+			})
+
+			bus.Publish(Event{Type: RequestReceived, Resource: "/posts"})
+			So(received, ShouldHaveLength, 1)
+			So(received[0].Timestamp.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("Events should be filtered by the types and the resources", func() {
+			received := []string{}
+			bus.Subscribe(Filter{Types: []Type{ObjectCreated, ObjectUpdated}, Resource: "/posts/{id}"}, Sync, func(event Event) {
+				received = append(received, string(event.Type)+" "+event.Resource)
+			})
+
+			bus.Publish(
+				Event{Type: ObjectCreated, Resource: "/posts/1"},
+				Event{Type: ObjectDeleted, Resource: "/posts/1"},
+				Event{Type: ObjectUpdated, Resource: "/users/1"},
+				Event{Type: ObjectUpdated, Resource: "/posts/1/comments"},
+				Event{Type: ObjectUpdated, Resource: "/posts/2"},
+			)
+			So(received, ShouldResemble, []string{"object.created /posts/1", "object.updated /posts/2"})
+		})
+
+		Convey("Async handlers should receive the events of a resource in order", func() {
+			var lock sync.Mutex
+			received := map[string][]int{}
+			bus.Subscribe(Filter{}, Async, func(event Event) {
+				lock.Lock()
+				defer lock.Unlock()
+				sequence, _ := strconv.Atoi(event.Command)
+				received[event.Resource] = append(received[event.Resource], sequence)
+			})
+
+			for i := 0; i < 100; i++ {
+				bus.Publish(Event{Type: ObjectUpdated, Resource: "/posts/" + strconv.Itoa(i%5), Command: strconv.Itoa(i)})
+			}
+			bus.Wait()
+
+			So(received, ShouldHaveLength, 5)
+			for _, sequences := range received {
+				So(sequences, ShouldHaveLength, 20)
+				for i := 1; i < len(sequences); i++ {
+					So(sequences[i], ShouldBeGreaterThan, sequences[i-1])
+				}
+			}
+		})
+
+		Convey("Unsubscribed handlers should not be called", func() {
+			count := 0
+			unsubscribe := bus.Subscribe(Filter{}, Sync, func(event Event) { count++ })
+			bus.Publish(Event{Type: RequestReceived})
+			unsubscribe()
+			bus.Publish(Event{Type: RequestReceived})
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("Panics of the handlers should not stop the other handlers", func() {
+			count := 0
+			bus.Subscribe(Filter{}, Sync, func(event Event) { panic("failed") })
+			bus.Subscribe(Filter{}, Async, func(event Event) { panic("failed") })
+			bus.Subscribe(Filter{}, Sync, func(event Event) { count++ })
+
+			So(func() { bus.Publish(Event{Type: RequestFailed}); bus.Wait() }, ShouldNotPanic)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
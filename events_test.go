@@ -0,0 +1,98 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/events"
+	"github.com/rihtim/core/search"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/webhooks"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEvents(t *testing.T) {
+
+	Convey("Given an event bus", t, func() {
+		DataProvider = newMemoryProvider()
+		Events = events.NewBus()
+		defer func() { Events.Close(); Events = nil; DataProvider = nil }()
+
+		received := []events.Event{}
+		Events.Subscribe(events.Filter{}, events.Sync, func(event events.Event) {
+			received = append(received, event)
+		})
+
+		Convey("Requests and the mutated objects should be published", func() {
+			HandleRequest(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "first"}}, requestscope.Init())
+			HandleRequest(messages.Message{Res: "/posts/1", Command: methods.Put, Body: map[string]interface{}{"title": "second"}}, requestscope.Init())
+			HandleRequest(messages.Message{Res: "/posts/1", Command: methods.Delete}, requestscope.Init())
+
+			So(received, ShouldHaveLength, 6)
+			So(received[0].Type, ShouldEqual, events.RequestReceived)
+			So(received[1].Type, ShouldEqual, events.ObjectCreated)
+			So(received[1].Resource, ShouldEqual, "/posts/1")
+			So(received[1].Object["title"], ShouldEqual, "first")
+			So(received[3].Type, ShouldEqual, events.ObjectUpdated)
+			So(received[3].Object["title"], ShouldEqual, "second")
+			So(received[5].Type, ShouldEqual, events.ObjectDeleted)
+			So(received[5].Object, ShouldBeNil)
+		})
+
+		Convey("Mutations in transactions should be published after the commit", func() {
+			transactional := &memoryTransactions{memoryProvider: DataProvider.(*memoryProvider)}
+			DataProvider = transactional
+			EnableTransactions("/posts")
+			defer func() { transactionalPaths = nil }()
+
+			committed := false
+			Interceptors = &interceptors.CoreInterceptorController{}
+			Interceptors.Add("/posts", methods.Post, interceptors.AFTER_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				if !committed {
+					err = &utils.Error{Code: http.StatusConflict, Message: "Not committed."}
+				}
+				return
+			}, nil)
+			defer func() { Interceptors = &interceptors.CoreInterceptorController{} }()
+
+			SearchIndex = search.NewMemoryIndex()
+			defer func() { SearchIndex = nil }()
+
+			HandleRequest(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "first"}}, requestscope.Init())
+			So(received, ShouldHaveLength, 1)
+			hits, _ := SearchIndex.Search("posts", "first")
+			So(hits, ShouldBeEmpty)
+
+			committed = true
+			HandleRequest(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "second"}}, requestscope.Init())
+			So(received, ShouldHaveLength, 3)
+			So(received[2].Type, ShouldEqual, events.ObjectCreated)
+			So(received[2].Object["title"], ShouldEqual, "second")
+		})
+
+		Convey("Mutated objects should be read once for all the publishers", func() {
+			provider := &countingProvider{memoryProvider: DataProvider.(*memoryProvider)}
+			DataProvider = provider
+			Webhooks = webhooks.NewDispatcher(provider)
+			SearchIndex = search.NewMemoryIndex()
+			defer func() { Webhooks = nil; SearchIndex = nil }()
+
+			HandleRequest(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "first"}}, requestscope.Init())
+			Webhooks.Wait()
+			So(received[1].Object["title"], ShouldEqual, "first")
+			So(provider.gets, ShouldEqual, 1)
+		})
+
+		Convey("Failed requests should be published with the errors", func() {
+			HandleRequest(messages.Message{Res: "/posts/1", Command: methods.Post}, requestscope.Init())
+
+			So(received, ShouldHaveLength, 2)
+			So(received[1].Type, ShouldEqual, events.RequestFailed)
+			So(received[1].Error.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
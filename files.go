@@ -0,0 +1,320 @@
+package core
+
+import (
+	"io"
+	"mime"
+	"bytes"
+	"strconv"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/imaging"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/filestorage"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// storage of the files served on the mount paths. file functions of the data provider are used if not set
+var FileStorage filestorage.FileStorage
+
+/**
+ * Paths where the files are served.
+ *
+ * POST   /files       uploads the request body or the files of a multipart request
+ * GET    /files       lists the files. 'after' and 'limit' parameters are used for paging
+ * GET    /files/{id}  downloads the file
+ * DELETE /files/{id}  deletes the file
+ */
+var FileMountPaths = []string{"/files"}
+
+// transforms the downloaded images according to the 'w', 'h', 'fit', 'format' and 'preset' parameters if set
+var ImageTransformer *imaging.Transformer
+
+// returns the mount path and the file id if the resource is served from a file mount path
+func fileResource(res string) (mount, id string, isFileResource bool) {
+	for _, mountPath := range FileMountPaths {
+		mountPath = strings.TrimRight(mountPath, "/")
+		if res == mountPath {
+			return mountPath, "", true
+		}
+		if strings.HasPrefix(res, mountPath+"/") {
+			id = res[len(mountPath)+1:]
+			if id != "" && !strings.Contains(id, "/") {
+				return mountPath, id, true
+			}
+		}
+	}
+	return
+}
+
+var handleFileRequest = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	_, id, _ := fileResource(request.Res)
+	isModel := id != ""
+
+	switch {
+	case request.Command == methods.Post && !isModel:
+		response, err = uploadFile(request, db)
+	case request.Command == methods.Get && isModel:
+		response, err = downloadImageOrFile(request, id, db)
+	case request.Command == methods.Get && FileStorage != nil:
+		response, err = listFiles(request)
+	case request.Command == methods.Delete && isModel && FileStorage != nil:
+		if err = FileStorage.Delete(id); err == nil {
+			response.Status = http.StatusNoContent
+		}
+	default:
+		err = &utils.Error{
+			Code:    http.StatusMethodNotAllowed,
+			Message: "Method not allowed on the resource type.",
+		}
+	}
+	return
+}
+
+func uploadFile(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	// files of multipart requests are already stored before the execution
+	if request.MultipartForm != nil {
+		response.Body = request.Body
+		response.Status = http.StatusCreated
+		return
+	}
+
+	if request.ReqBodyRaw == nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Request body is empty."}
+		return
+	}
+
+	if FileStorage == nil {
+		response.Body, err = db.CreateFile(request.ReqBodyRaw)
+	} else {
+		name, _ := request.GetParameter("name")
+		if name == "" {
+			name, _ = request.GetHeader("X-File-Name")
+		}
+		contentType, _ := request.GetHeader("Content-Type")
+
+		var info filestorage.FileInfo
+		if info, err = FileStorage.Put(name, contentType, request.ReqBodyRaw); err == nil {
+			response.Body = info.Map()
+		}
+	}
+
+	if err == nil {
+		response.Status = http.StatusCreated
+	}
+	return
+}
+
+/**
+ * Streams the file with its content type and validators. Ranges and conditional
+ * requests are served while building the response. The file is served as an
+ * attachment if the 'download' parameter is true.
+ */
+func downloadImageOrFile(request messages.Message, id string, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	if ImageTransformer == nil {
+		return downloadFile(request, id, db)
+	}
+
+	options, requested, err := ImageTransformer.ParseOptions(request.Parameters)
+	if err != nil {
+		return
+	}
+	if response, err = downloadFile(request, id, db); err != nil || !requested {
+		return
+	}
+	source := response.RawBodyReader
+	defer source.Close()
+
+	// variants are cached only if the version of the source is known
+	key := ""
+	etag, _ := response.GetHeader("ETag")
+	version := strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+	if version != "" {
+		key = id + "/" + version
+	}
+
+	data, contentType, err := ImageTransformer.Transform(key, source, options)
+	if err != nil {
+		return
+	}
+
+	response.Headers["Content-Type"] = []string{contentType}
+	if version != "" {
+		// variants of the files with weak validators are weak too
+		prefix := ""
+		if strings.HasPrefix(etag, "W/") {
+			prefix = "W/"
+		}
+		response.Headers["ETag"] = []string{prefix + "\"" + version + "-" + options.String() + "\""}
+	}
+	response.RawBodyReader = readSeekNopCloser{bytes.NewReader(data)}
+	return
+}
+
+func downloadFile(request messages.Message, id string, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	disposition := "inline"
+	if download, _ := request.GetParameter("download"); download == "true" {
+		disposition = "attachment"
+	}
+	response.Headers = make(map[string][]string)
+
+	if FileStorage == nil {
+		var info filestorage.FileInfo
+		if info, err = providerFileInfo(id, db); err != nil {
+			return
+		}
+
+		var reader io.ReadSeekCloser
+		if streamer, isStreamer := db.(dataprovider.FileStreamer); isStreamer {
+			if reader, err = streamer.GetFileReader(id); err != nil {
+				return
+			}
+		} else {
+			var content []byte
+			if content, err = db.GetFile(id); err != nil {
+				return
+			}
+			reader = readSeekNopCloser{bytes.NewReader(content)}
+		}
+
+		if err = completeFileInfo(&info, reader); err != nil {
+			reader.Close()
+			return
+		}
+		setFileHeaders(response.Headers, id, info, disposition)
+		response.RawBodyReader = reader
+		return
+	}
+
+	data, info, err := FileStorage.Get(id)
+	if err != nil {
+		return
+	}
+	setFileHeaders(response.Headers, id, info, disposition)
+	response.RawBodyReader = data
+	return
+}
+
+// returns the metadata of the file if the provider keeps it
+func providerFileInfo(id string, db dataprovider.Provider) (info filestorage.FileInfo, err *utils.Error) {
+
+	stater, isStater := db.(dataprovider.FileStater)
+	if !isStater {
+		return
+	}
+	metadata, err := stater.StatFile(id)
+	if err != nil {
+		return
+	}
+	if decodeErr := dataprovider.FromObject(metadata, &info); decodeErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Decoding file metadata failed. Reason: " + decodeErr.Error()}
+	}
+	return
+}
+
+// detects the content type from the head of the content and reads the size from the end of the stream if they are unknown
+func completeFileInfo(info *filestorage.FileInfo, reader io.ReadSeeker) (err *utils.Error) {
+
+	var readErr error
+	if info.ContentType == "" {
+		head := make([]byte, 512)
+		n, headErr := io.ReadFull(reader, head)
+		if headErr != nil && headErr != io.EOF && headErr != io.ErrUnexpectedEOF {
+			readErr = headErr
+		}
+		info.ContentType = http.DetectContentType(head[:n])
+	}
+	if readErr == nil && info.Checksum == "" && info.Size == 0 {
+		info.Size, readErr = reader.Seek(0, io.SeekEnd)
+	}
+	if readErr == nil {
+		_, readErr = reader.Seek(0, io.SeekStart)
+	}
+	if readErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Reading file failed. Reason: " + readErr.Error()}
+	}
+	return
+}
+
+// etag is the checksum of the file if it's known. otherwise it's a weak validator built from the id and the size
+func setFileHeaders(headers map[string][]string, id string, info filestorage.FileInfo, disposition string) {
+
+	if info.ContentType != "" {
+		headers["Content-Type"] = []string{info.ContentType}
+	}
+	if info.Name != "" {
+		headers["Content-Disposition"] = []string{mime.FormatMediaType(disposition, map[string]string{"filename": info.Name})}
+	} else {
+		headers["Content-Disposition"] = []string{disposition}
+	}
+	if info.Checksum != "" {
+		headers["ETag"] = []string{"\"" + info.Checksum + "\""}
+	} else {
+		headers["ETag"] = []string{"W/\"" + id + "-" + strconv.FormatInt(info.Size, 16) + "\""}
+	}
+	if !info.CreatedAt.IsZero() {
+		headers["Last-Modified"] = []string{info.CreatedAt.UTC().Format(http.TimeFormat)}
+	}
+}
+
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+func listFiles(request messages.Message) (response messages.Message, err *utils.Error) {
+
+	after, _ := request.GetParameter("after")
+	limit, _, err := request.GetIntParameter("limit")
+	if err != nil {
+		err.Code = http.StatusBadRequest
+		return
+	}
+
+	files, err := FileStorage.List(after, limit)
+	if err != nil {
+		return
+	}
+
+	results := make([]interface{}, len(files))
+	for i, info := range files {
+		results[i] = info.Map()
+	}
+	response.Body = map[string]interface{}{"results": results}
+	return
+}
+
+// stores an uploaded file of a multipart request and returns its metadata
+func storeFile(name, contentType string, data io.ReadCloser) (metadata map[string]interface{}, err *utils.Error) {
+
+	if FileStorage != nil {
+		var info filestorage.FileInfo
+		if info, err = FileStorage.Put(name, contentType, data); err == nil {
+			metadata = info.Map()
+		}
+		return
+	}
+
+	if DataProvider == nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "No file storage or data provider is set for storing files."}
+		return
+	}
+
+	response, err := DataProvider.CreateFile(data)
+	if err != nil {
+		return
+	}
+	metadata = make(map[string]interface{}, len(response))
+	for key, value := range response {
+		metadata[key] = value
+	}
+	return
+}
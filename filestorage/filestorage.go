@@ -0,0 +1,91 @@
+package filestorage
+
+import (
+	"io"
+	"mime"
+	"time"
+	"bufio"
+	"regexp"
+	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"github.com/rihtim/core/utils"
+)
+
+type FileInfo struct {
+	ID          string    `json:"_id"`
+	Name        string    `json:"name,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum,omitempty"` // hex encoded md5 of the content
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type FileStorage interface {
+	// stores the data and returns the metadata record of the file. content type is detected if empty
+	Put(name, contentType string, data io.Reader) (info FileInfo, err *utils.Error)
+	// opens the file for reading. data is seekable so the ranges can be served without loading the whole file
+	Get(id string) (data io.ReadSeekCloser, info FileInfo, err *utils.Error)
+	Stat(id string) (info FileInfo, err *utils.Error)
+	Delete(id string) (err *utils.Error)
+	// lists the files ordered by id. after is the last id of the previous page
+	List(after string, limit int) (files []FileInfo, err *utils.Error)
+}
+
+func (info FileInfo) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"_id":         info.ID,
+		"name":        info.Name,
+		"contentType": info.ContentType,
+		"size":        info.Size,
+		"checksum":    info.Checksum,
+		"createdAt":   info.CreatedAt,
+	}
+}
+
+var idPattern = regexp.MustCompile("^[0-9a-f]{32}$")
+
+func NewID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func IsValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+/**
+ * Detects the content type of the data if the given content type is empty or generic.
+ * The content is sniffed first, the extension of the name is used if sniffing fails.
+ * Returned reader contains the whole data including the sniffed bytes.
+ */
+func DetectContentType(name, contentType string, data io.Reader) (detected string, reader io.Reader) {
+
+	reader = data
+	detected = contentType
+	if detected != "" && detected != "application/octet-stream" {
+		return
+	}
+
+	buffered := bufio.NewReaderSize(data, 512)
+	reader = buffered
+	head, _ := buffered.Peek(512)
+
+	detected = http.DetectContentType(head)
+	if detected == "application/octet-stream" || detected == "text/plain; charset=utf-8" {
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+			detected = byExtension
+		}
+	}
+	return
+}
+
+func notFound() *utils.Error {
+	return &utils.Error{Code: http.StatusNotFound, Message: "File not found."}
+}
+
+func internalError(message string, err error) *utils.Error {
+	return &utils.Error{Code: http.StatusInternalServerError, Message: message + " Reason: " + err.Error()}
+}
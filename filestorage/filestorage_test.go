@@ -0,0 +1,161 @@
+package filestorage
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"strconv"
+	"strings"
+	"testing"
+	"net/http"
+	"encoding/xml"
+	"net/http/httptest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type storedObject struct {
+	header http.Header
+	data   []byte
+}
+
+// minimal stand-in for an S3 compatible object storage
+func newObjectStorageServer() *httptest.Server {
+
+	var mutex sync.Mutex
+	objects := make(map[string]storedObject)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if len(parts) == 1 && r.Method == http.MethodGet {
+			type contents struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			}
+			result := struct {
+				XMLName  xml.Name   `xml:"ListBucketResult"`
+				Contents []contents `xml:"Contents"`
+			}{}
+			keys := make([]string, 0)
+			for key := range objects {
+				if strings.HasPrefix(key, r.URL.Query().Get("prefix")) && key > r.URL.Query().Get("start-after") {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				result.Contents = append(result.Contents, contents{key, int64(len(objects[key].data))})
+			}
+			xml.NewEncoder(w).Encode(result)
+			return
+		}
+
+		key := parts[1]
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = storedObject{header: r.Header.Clone(), data: data}
+		case http.MethodGet, http.MethodHead:
+			object, exists := objects[key]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", object.header.Get("Content-Type"))
+			w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+			w.Header().Set("X-Amz-Meta-Name", object.header.Get("X-Amz-Meta-Name"))
+			w.Header().Set("X-Amz-Meta-Created-At", object.header.Get("X-Amz-Meta-Created-At"))
+			if r.Method == http.MethodGet {
+				w.Write(object.data)
+			}
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestFileStorage(t *testing.T) {
+
+	server := newObjectStorageServer()
+	defer server.Close()
+
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storages := map[string]FileStorage{
+		"local": local,
+		"s3":    &S3{Endpoint: server.URL, Region: "us-east-1", Bucket: "files", AccessKey: "key", SecretKey: "secret", Prefix: "uploads/"},
+	}
+
+	for name, storage := range storages {
+
+		Convey("Given the "+name+" file storage", t, func() {
+
+			Convey("When a file is stored", func() {
+				info, err := storage.Put("notes.txt", "", strings.NewReader("hello world"))
+				So(err, ShouldBeNil)
+
+				Convey("Metadata should be returned", func() {
+					So(IsValidID(info.ID), ShouldBeTrue)
+					So(info.Size, ShouldEqual, 11)
+					So(info.ContentType, ShouldEqual, "text/plain; charset=utf-8")
+					So(info.Checksum, ShouldEqual, "5eb63bbbe01eeed093cb22bb8f5acdc3")
+				})
+
+				Convey("It should be readable", func() {
+					data, readInfo, err := storage.Get(info.ID)
+					So(err, ShouldBeNil)
+					content, _ := io.ReadAll(data)
+					data.Close()
+					So(string(content), ShouldEqual, "hello world")
+					So(readInfo.Name, ShouldEqual, "notes.txt")
+				})
+
+				Convey("It should be readable from an offset", func() {
+					data, _, err := storage.Get(info.ID)
+					So(err, ShouldBeNil)
+					defer data.Close()
+					position, seekErr := data.Seek(-5, io.SeekEnd)
+					So(seekErr, ShouldBeNil)
+					So(position, ShouldEqual, 6)
+					content, _ := io.ReadAll(data)
+					So(string(content), ShouldEqual, "world")
+				})
+
+				Convey("It should be listed", func() {
+					files, err := storage.List("", 0)
+					So(err, ShouldBeNil)
+					ids := make([]string, 0)
+					for _, file := range files {
+						ids = append(ids, file.ID)
+					}
+					So(ids, ShouldContain, info.ID)
+					So(sort.StringsAreSorted(ids), ShouldBeTrue)
+				})
+
+				Convey("It should be deleted", func() {
+					So(storage.Delete(info.ID), ShouldBeNil)
+					_, err := storage.Stat(info.ID)
+					So(err, ShouldNotBeNil)
+					So(err.Code, ShouldEqual, http.StatusNotFound)
+				})
+			})
+
+			Convey("Invalid ids should not be found", func() {
+				_, _, err := storage.Get("../secret")
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package filestorage
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+	"strings"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"github.com/rihtim/core/utils"
+)
+
+/**
+ * Stores the files in a directory of the local file system. Content of each file
+ * is kept in a file named by its id and the metadata in a json file next to it.
+ */
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (storage *Local, err error) {
+	if err = os.MkdirAll(root, 0755); err != nil {
+		return
+	}
+	storage = &Local{root: root}
+	return
+}
+
+func (s *Local) Put(name, contentType string, data io.Reader) (info FileInfo, err *utils.Error) {
+
+	contentType, data = DetectContentType(name, contentType, data)
+
+	temp, createErr := os.CreateTemp(s.root, ".upload-")
+	if createErr != nil {
+		err = internalError("Creating file failed.", createErr)
+		return
+	}
+	defer os.Remove(temp.Name())
+
+	hash := md5.New()
+	size, copyErr := io.Copy(io.MultiWriter(temp, hash), data)
+	closeErr := temp.Close()
+	if copyErr != nil {
+		err = internalError("Writing file failed.", copyErr)
+		return
+	}
+	if closeErr != nil {
+		err = internalError("Writing file failed.", closeErr)
+		return
+	}
+
+	info = FileInfo{
+		ID:          NewID(),
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	metadata, _ := json.Marshal(info)
+	if writeErr := os.WriteFile(s.metadataPath(info.ID), metadata, 0644); writeErr != nil {
+		err = internalError("Writing file metadata failed.", writeErr)
+		return
+	}
+	if renameErr := os.Rename(temp.Name(), s.contentPath(info.ID)); renameErr != nil {
+		os.Remove(s.metadataPath(info.ID))
+		err = internalError("Writing file failed.", renameErr)
+	}
+	return
+}
+
+func (s *Local) Get(id string) (data io.ReadSeekCloser, info FileInfo, err *utils.Error) {
+
+	if info, err = s.Stat(id); err != nil {
+		return
+	}
+
+	file, openErr := os.Open(s.contentPath(id))
+	if os.IsNotExist(openErr) {
+		err = notFound()
+		return
+	}
+	if openErr != nil {
+		err = internalError("Opening file failed.", openErr)
+		return
+	}
+	data = file
+	return
+}
+
+func (s *Local) Stat(id string) (info FileInfo, err *utils.Error) {
+
+	if !IsValidID(id) {
+		err = notFound()
+		return
+	}
+
+	metadata, readErr := os.ReadFile(s.metadataPath(id))
+	if os.IsNotExist(readErr) {
+		err = notFound()
+		return
+	}
+	if readErr != nil {
+		err = internalError("Reading file metadata failed.", readErr)
+		return
+	}
+	if decodeErr := json.Unmarshal(metadata, &info); decodeErr != nil {
+		err = internalError("Reading file metadata failed.", decodeErr)
+	}
+	return
+}
+
+func (s *Local) Delete(id string) (err *utils.Error) {
+
+	if _, err = s.Stat(id); err != nil {
+		return
+	}
+	if removeErr := os.Remove(s.contentPath(id)); removeErr != nil && !os.IsNotExist(removeErr) {
+		err = internalError("Deleting file failed.", removeErr)
+		return
+	}
+	if removeErr := os.Remove(s.metadataPath(id)); removeErr != nil && !os.IsNotExist(removeErr) {
+		err = internalError("Deleting file metadata failed.", removeErr)
+	}
+	return
+}
+
+func (s *Local) List(after string, limit int) (files []FileInfo, err *utils.Error) {
+
+	entries, readErr := os.ReadDir(s.root)
+	if readErr != nil {
+		err = internalError("Listing files failed.", readErr)
+		return
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if id != entry.Name() && IsValidID(id) && id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	files = make([]FileInfo, 0)
+	for _, id := range ids {
+		if limit > 0 && len(files) == limit {
+			break
+		}
+		info, statErr := s.Stat(id)
+		if statErr != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+	return
+}
+
+func (s *Local) contentPath(id string) string {
+	return filepath.Join(s.root, id)
+}
+
+func (s *Local) metadataPath(id string) string {
+	return filepath.Join(s.root, id+".json")
+}
@@ -0,0 +1,420 @@
+package filestorage
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"net/http"
+	"crypto/md5"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/xml"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/rihtim/core/utils"
+)
+
+/**
+ * Stores the files in a bucket of an S3 compatible object storage. Requests are
+ * signed with AWS signature version 4 and path-style urls are used, so any S3
+ * compatible service can be used as well.
+ *
+ * Ex: &S3{Endpoint: "http://localhost:9000", Region: "us-east-1", Bucket: "files", AccessKey: "...", SecretKey: "..."}
+ */
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// prepended to the ids of the files to build the object keys
+	Prefix string
+	Client *http.Client
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+func (s *S3) Put(name, contentType string, data io.Reader) (info FileInfo, err *utils.Error) {
+
+	contentType, data = DetectContentType(name, contentType, data)
+
+	// content is spooled to disk since the object storage requires the length in advance
+	temp, createErr := os.CreateTemp("", "rihtim-upload-")
+	if createErr != nil {
+		err = internalError("Creating file failed.", createErr)
+		return
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	hash := md5.New()
+	size, copyErr := io.Copy(io.MultiWriter(temp, hash), data)
+	if copyErr != nil {
+		err = internalError("Writing file failed.", copyErr)
+		return
+	}
+	if _, seekErr := temp.Seek(0, io.SeekStart); seekErr != nil {
+		err = internalError("Writing file failed.", seekErr)
+		return
+	}
+
+	info = FileInfo{
+		ID:          NewID(),
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Checksum:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, s.objectURL(info.ID), io.NopCloser(temp))
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(hash.Sum(nil)))
+	req.Header.Set("X-Amz-Meta-Name", url.QueryEscape(name))
+	req.Header.Set("X-Amz-Meta-Created-At", info.CreatedAt.Format(time.RFC3339))
+
+	resp, doErr := s.do(req)
+	if doErr != nil {
+		err = internalError("Uploading file failed.", doErr)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = statusError("Uploading file failed.", resp)
+	}
+	return
+}
+
+// returns a reader which downloads the object lazily with range requests starting from the read offset
+func (s *S3) Get(id string) (data io.ReadSeekCloser, info FileInfo, err *utils.Error) {
+
+	if info, err = s.Stat(id); err != nil {
+		return
+	}
+	data = &s3Reader{storage: s, id: id, size: info.Size}
+	return
+}
+
+func (s *S3) Stat(id string) (info FileInfo, err *utils.Error) {
+
+	if !IsValidID(id) {
+		err = notFound()
+		return
+	}
+
+	req, _ := http.NewRequest(http.MethodHead, s.objectURL(id), nil)
+	resp, doErr := s.do(req)
+	if doErr != nil {
+		err = internalError("Reading file metadata failed.", doErr)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = statusError("Reading file metadata failed.", resp)
+		return
+	}
+	info = infoFromHeaders(id, resp)
+	return
+}
+
+func (s *S3) Delete(id string) (err *utils.Error) {
+
+	// object storages don't report missing objects on delete
+	if _, err = s.Stat(id); err != nil {
+		return
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, s.objectURL(id), nil)
+	resp, doErr := s.do(req)
+	if doErr != nil {
+		err = internalError("Deleting file failed.", doErr)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		err = statusError("Deleting file failed.", resp)
+	}
+	return
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		ETag         string    `xml:"ETag"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// lists the files without content type and name since the listing doesn't contain the object metadata
+func (s *S3) List(after string, limit int) (files []FileInfo, err *utils.Error) {
+
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", s.Prefix)
+	if after != "" {
+		query.Set("start-after", s.Prefix+after)
+	}
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, s.bucketURL()+"?"+query.Encode(), nil)
+	resp, doErr := s.do(req)
+	if doErr != nil {
+		err = internalError("Listing files failed.", doErr)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = statusError("Listing files failed.", resp)
+		return
+	}
+
+	var result listBucketResult
+	if decodeErr := xml.NewDecoder(resp.Body).Decode(&result); decodeErr != nil {
+		err = internalError("Listing files failed.", decodeErr)
+		return
+	}
+
+	files = make([]FileInfo, 0, len(result.Contents))
+	for _, object := range result.Contents {
+		id := strings.TrimPrefix(object.Key, s.Prefix)
+		if !IsValidID(id) {
+			continue
+		}
+		files = append(files, FileInfo{
+			ID:        id,
+			Size:      object.Size,
+			Checksum:  strings.Trim(object.ETag, "\""),
+			CreatedAt: object.LastModified,
+		})
+	}
+	return
+}
+
+type s3Reader struct {
+	storage *S3
+	id      string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (n int, err error) {
+
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		req, _ := http.NewRequest(http.MethodGet, r.storage.objectURL(r.id), nil)
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+		resp, doErr := r.storage.do(req)
+		if doErr != nil {
+			return 0, doErr
+		}
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return 0, errors.New("Downloading file failed. Object storage responded with " + resp.Status + ".")
+		}
+		// skip to the offset if the range is ignored
+		if resp.StatusCode == http.StatusOK && r.offset > 0 {
+			if _, skipErr := io.CopyN(io.Discard, resp.Body, r.offset); skipErr != nil {
+				resp.Body.Close()
+				return 0, skipErr
+			}
+		}
+		r.body = resp.Body
+	}
+
+	n, err = r.body.Read(p)
+	r.offset += int64(n)
+	return
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = r.offset + offset
+	case io.SeekEnd:
+		position = r.size + offset
+	default:
+		return 0, errors.New("Invalid whence.")
+	}
+	if position < 0 {
+		return 0, errors.New("Negative position.")
+	}
+
+	// the download is restarted from the new position on the next read
+	if position != r.offset {
+		r.Close()
+		r.offset = position
+	}
+	return position, nil
+}
+
+func (r *s3Reader) Close() (err error) {
+	if r.body != nil {
+		err = r.body.Close()
+		r.body = nil
+	}
+	return
+}
+
+func (s *S3) bucketURL() string {
+	return strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket
+}
+
+func (s *S3) objectURL(id string) string {
+	return s.bucketURL() + "/" + s.Prefix + id
+}
+
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	if err := s.sign(req, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// signs the request with AWS signature version 4 without signing the payload
+func (s *S3) sign(req *http.Request, now time.Time) error {
+
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return errors.New("Credentials of the object storage are not set.")
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	// host, content type and all x-amz headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		lower := strings.ToLower(key)
+		if lower == "content-type" || lower == "content-md5" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// encodes everything except the unreserved characters of RFC 3986
+func uriEncode(value string) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			encoded.WriteByte(b)
+		} else {
+			encoded.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return encoded.String()
+}
+
+func infoFromHeaders(id string, resp *http.Response) (info FileInfo) {
+	info.ID = id
+	info.ContentType = resp.Header.Get("Content-Type")
+	info.Size = resp.ContentLength
+	if info.Size < 0 {
+		info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	}
+	info.Checksum = strings.Trim(resp.Header.Get("ETag"), "\"")
+	info.Name, _ = url.QueryUnescape(resp.Header.Get("X-Amz-Meta-Name"))
+	if createdAt, parseErr := time.Parse(time.RFC3339, resp.Header.Get("X-Amz-Meta-Created-At")); parseErr == nil {
+		info.CreatedAt = createdAt
+	} else if lastModified, parseErr := http.ParseTime(resp.Header.Get("Last-Modified")); parseErr == nil {
+		info.CreatedAt = lastModified
+	}
+	return
+}
+
+func statusError(message string, resp *http.Response) *utils.Error {
+	if resp.StatusCode == http.StatusNotFound {
+		return notFound()
+	}
+	return &utils.Error{Code: http.StatusBadGateway, Message: message + " Object storage responded with " + resp.Status + "."}
+}
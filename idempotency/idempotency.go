@@ -0,0 +1,218 @@
+package idempotency
+
+import (
+	"fmt"
+	"time"
+	"net/http"
+	"encoding/hex"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+)
+
+// request header carrying the key generated by the client for each logical request
+const Header = "Idempotency-Key"
+
+// response header set on the replayed responses
+const ReplayedHeader = "Idempotent-Replayed"
+
+// request scope key of the record key, set when the request reserves the key
+const KeyScopeKey = "idempotencyKey"
+
+/**
+ * Makes the retried requests with the same Idempotency-Key return the response of
+ * the first request instead of executing again. Keys are scoped by the principal
+ * and the path. Duplicates of an in-flight request wait for it to complete or get
+ * 409, and reusing a key with another body returns 422.
+ *
+ * Ex: idempotency.New(idempotency.NewMemoryStore()).Register(core.Interceptors, "/orders")
+ */
+type Handler struct {
+	Store Store
+	// duration that the completed responses are kept. defaults to 24 hours
+	TTL time.Duration
+	// duration after which an in-flight request is considered abandoned. defaults to 1 minute
+	InFlightTTL time.Duration
+	// duplicates wait for the in-flight request up to this duration. 409 is returned immediately if zero
+	WaitTimeout time.Duration
+	// request scope key of the authenticated user
+	PrincipalKey string
+	// requests without the header are rejected with 400 if true
+	Required bool
+	// used to get the current time, for testing purposes
+	Now func() time.Time
+}
+
+func New(store Store) *Handler {
+	return &Handler{Store: store}
+}
+
+// adds the interceptors for the POST requests of the path
+func (h *Handler) Register(controller interceptors.InterceptorController, path string) {
+	controller.Add(path, methods.Post, interceptors.BEFORE_EXEC, h.Begin, nil)
+	controller.Add(path, methods.Post, interceptors.AFTER_EXEC, h.Complete, nil)
+	controller.Add(path, methods.Post, interceptors.ON_ERROR, h.Release, nil)
+}
+
+// BEFORE_EXEC interceptor reserving the key or replaying the stored response
+func (h *Handler) Begin(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	idempotencyKey, _ := req.GetHeader(Header)
+	if idempotencyKey == "" {
+		if h.Required {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Idempotency-Key header is required."}
+		}
+		return
+	}
+	if len(idempotencyKey) > 255 {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Idempotency-Key header is too long."}
+		return
+	}
+
+	key := h.key(idempotencyKey, rs, req)
+	fingerprint := fingerprint(req)
+
+	// key is set in the request scope of the request, so ON_ERROR can release it if a later interceptor fails
+	record, reserved := h.Store.Reserve(key, Record{Fingerprint: fingerprint, ExpiresAt: h.now().Add(h.inFlightTTL())})
+	if reserved {
+		rs.Set(KeyScopeKey, key)
+		editedRs = rs
+		return
+	}
+
+	if !record.Completed && h.WaitTimeout > 0 {
+		record = h.wait(key, record)
+	}
+	if record.Fingerprint != fingerprint {
+		err = &utils.Error{Code: http.StatusUnprocessableEntity, Message: "Idempotency-Key is already used with another request body."}
+		return
+	}
+	if !record.Completed {
+		err = &utils.Error{Code: http.StatusConflict, Message: "Request with the same Idempotency-Key is in progress."}
+		return
+	}
+
+	editedResp, err = replay(record)
+	return
+}
+
+// AFTER_EXEC interceptor storing the response of the request reserving the key
+func (h *Handler) Complete(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	key, reserved := rs.Get(KeyScopeKey).(string)
+	if !reserved {
+		return
+	}
+
+	// streamed responses can't be replayed
+	body, encodeErr := json.Marshal(resp.Body)
+	if encodeErr != nil || resp.RawBodyReader != nil {
+		h.Store.Release(key)
+		return
+	}
+
+	// fingerprint of the reservation is kept since the body may be edited by the interceptors
+	reservation, _ := h.Store.Get(key)
+	record := Record{
+		Fingerprint: reservation.Fingerprint,
+		Completed:   true,
+		Status:      resp.Status,
+		Headers:     resp.Headers,
+		ExpiresAt:   h.now().Add(h.ttl()),
+	}
+	if resp.Body != nil {
+		record.Body = body
+	}
+	h.Store.Complete(key, record)
+	return
+}
+
+// ON_ERROR interceptor releasing the key, so the failed requests can be retried
+func (h *Handler) Release(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+	if key, reserved := rs.Get(KeyScopeKey).(string); reserved {
+		h.Store.Release(key)
+	}
+	return
+}
+
+func (h *Handler) wait(key string, record Record) Record {
+
+	deadline := time.Now().Add(h.WaitTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		current, found := h.Store.Get(key)
+		if !found {
+			// released by the failed request, so it can't be replayed
+			return record
+		}
+		if current.Completed {
+			return current
+		}
+	}
+	return record
+}
+
+func replay(record Record) (response messages.Message, err *utils.Error) {
+
+	response.Status = record.Status
+	response.Headers = make(map[string][]string)
+	for name, values := range record.Headers {
+		response.Headers[name] = values
+	}
+	response.Headers[ReplayedHeader] = []string{"true"}
+
+	if len(record.Body) > 0 {
+		if decodeErr := json.Unmarshal(record.Body, &response.Body); decodeErr != nil {
+			err = &utils.Error{Code: http.StatusInternalServerError, Message: "Decoding stored response failed."}
+		}
+	}
+	return
+}
+
+func (h *Handler) key(idempotencyKey string, rs requestscope.RequestScope, req messages.Message) string {
+
+	principal := ""
+	if h.PrincipalKey != "" {
+		if user, isMap := rs.Get(h.PrincipalKey).(map[string]interface{}); isMap {
+			principal = fmt.Sprint(user["_id"])
+		} else if value := rs.Get(h.PrincipalKey); value != nil {
+			principal = fmt.Sprint(value)
+		}
+	}
+
+	hash := sha256.Sum256([]byte(idempotencyKey + "\n" + principal + "\n" + req.Res))
+	return hex.EncodeToString(hash[:])
+}
+
+func fingerprint(req messages.Message) string {
+	// keys of the maps are sorted while encoding
+	encoded, _ := json.Marshal([]interface{}{req.Body, req.Items})
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+func (h *Handler) ttl() time.Duration {
+	if h.TTL > 0 {
+		return h.TTL
+	}
+	return 24 * time.Hour
+}
+
+func (h *Handler) inFlightTTL() time.Duration {
+	if h.InFlightTTL > 0 {
+		return h.InFlightTTL
+	}
+	return time.Minute
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,107 @@
+package idempotency
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandler(t *testing.T) {
+
+	Convey("Given an idempotency handler", t, func() {
+		handler := New(NewMemoryStore())
+		request := messages.Message{
+			Res:     "/orders",
+			Command: methods.Post,
+			Headers: map[string][]string{Header: {"abc"}},
+			Body:    map[string]interface{}{"item": "book"},
+		}
+		created := messages.Message{Status: http.StatusCreated, Body: map[string]interface{}{"_id": "order1"}}
+
+		_, replayed, rs, err := handler.Begin(requestscope.Init(), nil, request, messages.Message{}, nil)
+		So(err, ShouldBeNil)
+		So(replayed.IsEmpty(), ShouldBeTrue)
+
+		Convey("When the first request completes", func() {
+			handler.Complete(rs, nil, request, created, nil)
+
+			Convey("Retries should replay the stored response", func() {
+				_, replayed, _, err := handler.Begin(requestscope.Init(), nil, request, messages.Message{}, nil)
+				So(err, ShouldBeNil)
+				So(replayed.Status, ShouldEqual, http.StatusCreated)
+				So(replayed.Body["_id"], ShouldEqual, "order1")
+				So(replayed.Headers[ReplayedHeader], ShouldResemble, []string{"true"})
+			})
+
+			Convey("Reusing the key with another body should be rejected", func() {
+				other := request
+				other.Body = map[string]interface{}{"item": "pen"}
+				_, _, _, err := handler.Begin(requestscope.Init(), nil, other, messages.Message{}, nil)
+				So(err.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+
+			Convey("Same key on another path should not be replayed", func() {
+				other := request
+				other.Res = "/payments"
+				_, replayed, _, err := handler.Begin(requestscope.Init(), nil, other, messages.Message{}, nil)
+				So(err, ShouldBeNil)
+				So(replayed.IsEmpty(), ShouldBeTrue)
+			})
+		})
+
+		Convey("Duplicates of an in-flight request should get conflict", func() {
+			_, _, _, err := handler.Begin(requestscope.Init(), nil, request, messages.Message{}, nil)
+			So(err.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("Duplicates should wait for the in-flight request if configured", func() {
+			handler.WaitTimeout = time.Second
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				handler.Complete(rs, nil, request, created, nil)
+			}()
+			_, replayed, _, err := handler.Begin(requestscope.Init(), nil, request, messages.Message{}, nil)
+			So(err, ShouldBeNil)
+			So(replayed.Body["_id"], ShouldEqual, "order1")
+		})
+
+		Convey("Failed requests should release the key", func() {
+			handler.Release(rs, nil, request, messages.Message{}, nil)
+			_, replayed, _, err := handler.Begin(requestscope.Init(), nil, request, messages.Message{}, nil)
+			So(err, ShouldBeNil)
+			So(replayed.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("Keys should be released with the request scope of the request if a later interceptor fails", func() {
+			scope := requestscope.Init()
+			other := request
+			other.Headers = map[string][]string{Header: {"def"}}
+			handler.Begin(scope, nil, other, messages.Message{}, nil)
+			handler.Release(scope, nil, other, messages.Message{}, nil)
+
+			_, replayed, _, err := handler.Begin(requestscope.Init(), nil, other, messages.Message{}, nil)
+			So(err, ShouldBeNil)
+			So(replayed.IsEmpty(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Records should expire after the ttl", t, func() {
+		now := time.Unix(1700000000, 0)
+		store := NewMemoryStore()
+		store.Now = func() time.Time { return now }
+		handler := New(store)
+		handler.Now = store.Now
+
+		request := messages.Message{Res: "/orders", Command: methods.Post, Headers: map[string][]string{Header: {"abc"}}}
+		_, _, rs, _ := handler.Begin(requestscope.Init(), nil, request, messages.Message{}, nil)
+		handler.Complete(rs, nil, request, messages.Message{Status: http.StatusCreated}, nil)
+
+		now = now.Add(25 * time.Hour)
+		_, found := store.Get(rs.Get(KeyScopeKey).(string))
+		So(found, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+)
+
+type Record struct {
+	// hash of the request body. same key can't be used with another body
+	Fingerprint string
+	// false while the first request is in flight
+	Completed bool
+	Status    int
+	Headers   map[string][]string
+	// json encoded body of the response
+	Body      []byte
+	ExpiresAt time.Time
+}
+
+type Store interface {
+	// stores the record if the key doesn't exist, otherwise returns the existing record
+	Reserve(key string, record Record) (existing Record, reserved bool)
+	Complete(key string, record Record)
+	Get(key string) (record Record, found bool)
+	// removes the record, so the request can be retried
+	Release(key string)
+}
+
+type MemoryStore struct {
+	// used to get the current time, for testing purposes
+	Now     func() time.Time
+	mutex   sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Reserve(key string, record Record) (existing Record, reserved bool) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, found := s.get(key); found {
+		return existing, false
+	}
+	s.records[key] = record
+	return record, true
+}
+
+func (s *MemoryStore) Complete(key string, record Record) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records[key] = record
+}
+
+func (s *MemoryStore) Get(key string) (record Record, found bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.get(key)
+}
+
+func (s *MemoryStore) Release(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, key)
+}
+
+// removes the expired records
+func (s *MemoryStore) Purge() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.records {
+		s.get(key)
+	}
+}
+
+func (s *MemoryStore) get(key string) (record Record, found bool) {
+	record, found = s.records[key]
+	if found && !record.ExpiresAt.IsZero() && !s.now().Before(record.ExpiresAt) {
+		delete(s.records, key)
+		return Record{}, false
+	}
+	return
+}
+
+func (s *MemoryStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,84 @@
+package imaging
+
+import (
+	"sync"
+	"container/list"
+)
+
+type VariantCache interface {
+	Get(key string) (data []byte, contentType string, found bool)
+	Set(key string, data []byte, contentType string)
+}
+
+type variant struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+/**
+ * In memory variant cache bounded by the total size of the variants. Least recently
+ * used variants are evicted when the size is exceeded.
+ */
+type MemoryCache struct {
+	MaxBytes int64
+	mutex    sync.Mutex
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		MaxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryCache) Get(key string) (data []byte, contentType string, found bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return
+	}
+	c.order.MoveToFront(element)
+	entry := element.Value.(*variant)
+	return entry.data, entry.contentType, true
+}
+
+func (c *MemoryCache) Set(key string, data []byte, contentType string) {
+
+	// variants larger than the cache are not stored
+	if int64(len(data)) > c.MaxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&variant{key: key, data: data, contentType: contentType})
+	c.size += int64(len(data))
+
+	for c.size > c.MaxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *MemoryCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*variant)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.data))
+}
@@ -0,0 +1,275 @@
+package imaging
+
+import (
+	"io"
+	"bytes"
+	"image"
+	"strconv"
+	"strings"
+	"net/http"
+	"image/gif"
+	"image/png"
+	"image/jpeg"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"github.com/rihtim/core/utils"
+)
+
+// fit modes
+const (
+	// scales the image to fit in the box keeping the aspect ratio
+	Contain = "contain"
+	// scales the image to cover the box keeping the aspect ratio and crops the overflow
+	Cover = "cover"
+	// stretches the image to the box
+	Fill = "fill"
+)
+
+// maximum number of the bytes read to find the dimensions of an image
+var MaxHeaderSize int64 = 1 << 20
+
+// output formats. webp is supported as input only
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+type Options struct {
+	Width  int
+	Height int
+	Fit    string
+	// output format. source format is kept if empty
+	Format string
+}
+
+func (o Options) String() string {
+	return strconv.Itoa(o.Width) + "x" + strconv.Itoa(o.Height) + "-" + o.Fit + "-" + o.Format
+}
+
+/**
+ * Resizes and converts the images on file downloads. Only the dimensions and fit
+ * modes defined in the presets are accepted, so clients can't generate unlimited
+ * variants. Presets can be requested by name as well.
+ *
+ * Ex: ?w=128&h=128&fit=cover&format=png or ?preset=avatar&format=jpeg
+ */
+type Transformer struct {
+	Presets map[string]Options
+	// cache of the generated variants. variants are generated on each request if not set
+	Cache VariantCache
+	// images with more pixels are not decoded. defaults to 40 megapixels
+	MaxSourcePixels int
+	// quality of the jpeg encoder. defaults to 85
+	JPEGQuality int
+}
+
+/**
+ * Parses the transformation parameters. Returns requested false if the parameters
+ * don't contain any transformation.
+ */
+func (t *Transformer) ParseOptions(params map[string][]string) (options Options, requested bool, err *utils.Error) {
+
+	get := func(key string) string {
+		if values, contains := params[key]; contains && len(values) > 0 {
+			requested = true
+			return values[0]
+		}
+		return ""
+	}
+
+	presetName := get("preset")
+	width := get("w")
+	height := get("h")
+	options.Fit = strings.ToLower(get("fit"))
+	options.Format = strings.ToLower(get("format"))
+	if !requested {
+		return
+	}
+
+	if options.Format == "jpg" {
+		options.Format = "jpeg"
+	}
+	if _, supported := contentTypes[options.Format]; options.Format != "" && !supported {
+		err = badRequest("Image format is not supported.")
+		return
+	}
+
+	if presetName != "" {
+		preset, exists := t.Presets[presetName]
+		if !exists {
+			err = badRequest("Image preset doesn't exist.")
+			return
+		}
+		if options.Format == "" {
+			options.Format = preset.Format
+		}
+		options.Width, options.Height, options.Fit = preset.Width, preset.Height, preset.Fit
+	} else {
+		var parseErr error
+		if width != "" {
+			if options.Width, parseErr = strconv.Atoi(width); parseErr != nil {
+				err = badRequest("Image width must be a number.")
+				return
+			}
+		}
+		if height != "" {
+			if options.Height, parseErr = strconv.Atoi(height); parseErr != nil {
+				err = badRequest("Image height must be a number.")
+				return
+			}
+		}
+	}
+
+	if options.Fit == "" {
+		options.Fit = Contain
+	}
+	if options.Width == 0 && options.Height == 0 {
+		// format conversion only
+		return
+	}
+	if !t.isAllowed(options) {
+		err = badRequest("Image dimensions are not allowed.")
+	}
+	return
+}
+
+func (t *Transformer) isAllowed(options Options) bool {
+	for _, preset := range t.Presets {
+		fit := preset.Fit
+		if fit == "" {
+			fit = Contain
+		}
+		if preset.Width == options.Width && preset.Height == options.Height && fit == options.Fit {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Transforms the source image. Key identifies the version of the source and is used
+ * for caching the variants. Variants are not cached if the key is empty.
+ */
+func (t *Transformer) Transform(key string, source io.Reader, options Options) (data []byte, contentType string, err *utils.Error) {
+
+	cacheKey := ""
+	if key != "" && t.Cache != nil {
+		cacheKey = key + "/" + options.String()
+		if cached, cachedType, found := t.Cache.Get(cacheKey); found {
+			return cached, cachedType, nil
+		}
+	}
+
+	// the header is read through a bounded reader and kept, so the dimensions are checked
+	// before the rest of the source is read
+	var header bytes.Buffer
+	config, sourceFormat, configErr := image.DecodeConfig(io.TeeReader(io.LimitReader(source, MaxHeaderSize), &header))
+	if configErr != nil {
+		err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "File is not a supported image."}
+		return
+	}
+	maxPixels := t.MaxSourcePixels
+	if maxPixels == 0 {
+		maxPixels = 40000000
+	}
+	if config.Width*config.Height > maxPixels {
+		err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Image is too large to transform."}
+		return
+	}
+
+	img, _, decodeErr := image.Decode(io.MultiReader(&header, source))
+	if decodeErr != nil {
+		err = &utils.Error{Code: http.StatusUnsupportedMediaType, Message: "Decoding image failed. Reason: " + decodeErr.Error()}
+		return
+	}
+
+	format := options.Format
+	if format == "" {
+		format = sourceFormat
+	}
+	if _, supported := contentTypes[format]; !supported {
+		format = "png"
+	}
+
+	if options.Width > 0 || options.Height > 0 {
+		img = resize(img, options)
+	}
+
+	var buffer bytes.Buffer
+	var encodeErr error
+	switch format {
+	case "jpeg":
+		quality := t.JPEGQuality
+		if quality == 0 {
+			quality = 85
+		}
+		encodeErr = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		encodeErr = gif.Encode(&buffer, img, nil)
+	default:
+		encodeErr = png.Encode(&buffer, img)
+	}
+	if encodeErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Encoding image failed. Reason: " + encodeErr.Error()}
+		return
+	}
+
+	data = buffer.Bytes()
+	contentType = contentTypes[format]
+	if cacheKey != "" {
+		t.Cache.Set(cacheKey, data, contentType)
+	}
+	return
+}
+
+func resize(img image.Image, options Options) image.Image {
+
+	bounds := img.Bounds()
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+	width, height := options.Width, options.Height
+
+	// missing dimension is calculated from the aspect ratio
+	if width == 0 {
+		width = atLeastOne(sourceWidth * height / sourceHeight)
+	} else if height == 0 {
+		height = atLeastOne(sourceHeight * width / sourceWidth)
+	}
+
+	source := bounds
+	targetWidth, targetHeight := width, height
+	switch options.Fit {
+	case Cover:
+		// crop the source to the aspect ratio of the box
+		if sourceWidth*height > sourceHeight*width {
+			cropWidth := sourceHeight * width / height
+			offset := (sourceWidth - cropWidth) / 2
+			source = image.Rect(bounds.Min.X+offset, bounds.Min.Y, bounds.Min.X+offset+cropWidth, bounds.Max.Y)
+		} else {
+			cropHeight := sourceWidth * height / width
+			offset := (sourceHeight - cropHeight) / 2
+			source = image.Rect(bounds.Min.X, bounds.Min.Y+offset, bounds.Max.X, bounds.Min.Y+offset+cropHeight)
+		}
+	case Contain:
+		if sourceWidth*height > sourceHeight*width {
+			targetHeight = atLeastOne(sourceHeight * width / sourceWidth)
+		} else {
+			targetWidth = atLeastOne(sourceWidth * height / sourceHeight)
+		}
+	}
+
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	draw.CatmullRom.Scale(target, target.Bounds(), img, source, draw.Src, nil)
+	return target
+}
+
+func badRequest(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusBadRequest, Message: message}
+}
+
+func atLeastOne(value int) int {
+	if value < 1 {
+		return 1
+	}
+	return value
+}
@@ -0,0 +1,152 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"testing"
+	"net/http"
+	"image/png"
+	"image/jpeg"
+	"image/color"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testImage(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buffer bytes.Buffer
+	png.Encode(&buffer, img)
+	return buffer.Bytes()
+}
+
+func TestTransformer(t *testing.T) {
+
+	Convey("Given a transformer with presets", t, func() {
+		cache := NewMemoryCache(1 << 20)
+		transformer := &Transformer{
+			Presets: map[string]Options{
+				"thumb":   {Width: 50, Height: 50, Fit: Cover},
+				"medium":  {Width: 100, Height: 100},
+				"stretch": {Width: 40, Height: 10, Fit: Fill},
+			},
+			Cache: cache,
+		}
+		source := testImage(200, 100)
+
+		transform := func(params map[string][]string) (image.Image, string) {
+			options, requested, err := transformer.ParseOptions(params)
+			So(err, ShouldBeNil)
+			So(requested, ShouldBeTrue)
+			data, contentType, err := transformer.Transform("source", bytes.NewReader(source), options)
+			So(err, ShouldBeNil)
+			img, _, decodeErr := image.Decode(bytes.NewReader(data))
+			So(decodeErr, ShouldBeNil)
+			return img, contentType
+		}
+
+		Convey("Contain should keep the aspect ratio", func() {
+			img, contentType := transform(map[string][]string{"w": {"100"}, "h": {"100"}})
+			So(img.Bounds().Dx(), ShouldEqual, 100)
+			So(img.Bounds().Dy(), ShouldEqual, 50)
+			So(contentType, ShouldEqual, "image/png")
+		})
+
+		Convey("Cover should crop to the box", func() {
+			img, _ := transform(map[string][]string{"preset": {"thumb"}})
+			So(img.Bounds().Dx(), ShouldEqual, 50)
+			So(img.Bounds().Dy(), ShouldEqual, 50)
+		})
+
+		Convey("Fill should stretch to the box", func() {
+			img, _ := transform(map[string][]string{"w": {"40"}, "h": {"10"}, "fit": {"fill"}})
+			So(img.Bounds().Dx(), ShouldEqual, 40)
+			So(img.Bounds().Dy(), ShouldEqual, 10)
+		})
+
+		Convey("Format should be converted", func() {
+			_, contentType := transform(map[string][]string{"format": {"jpg"}})
+			So(contentType, ShouldEqual, "image/jpeg")
+		})
+
+		Convey("Variants should be cached", func() {
+			transform(map[string][]string{"preset": {"thumb"}, "format": {"jpeg"}})
+			So(cache.Size(), ShouldBeGreaterThan, 0)
+
+			options, _, _ := transformer.ParseOptions(map[string][]string{"preset": {"thumb"}, "format": {"jpeg"}})
+			data, contentType, err := transformer.Transform("source", bytes.NewReader(nil), options)
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "image/jpeg")
+			_, decodeErr := jpeg.Decode(bytes.NewReader(data))
+			So(decodeErr, ShouldBeNil)
+		})
+
+		Convey("Dimensions out of the presets should be rejected", func() {
+			_, _, err := transformer.ParseOptions(map[string][]string{"w": {"101"}, "h": {"100"}})
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Unknown presets and formats should be rejected", func() {
+			_, _, err := transformer.ParseOptions(map[string][]string{"preset": {"huge"}})
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+			_, _, err = transformer.ParseOptions(map[string][]string{"format": {"webp"}})
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Requests without parameters should not be transformed", func() {
+			_, requested, err := transformer.ParseOptions(map[string][]string{"download": {"true"}})
+			So(requested, ShouldBeFalse)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Non image files should be rejected", func() {
+			_, _, err := transformer.Transform("", bytes.NewReader([]byte("hello")), Options{Width: 100, Height: 100})
+			So(err.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+
+		Convey("Large images should be rejected before the whole source is read", func() {
+			img := image.NewGray(image.Rect(0, 0, 400, 400))
+			for i := range img.Pix {
+				img.Pix[i] = uint8(i * 7919 % 251)
+			}
+			var buffer bytes.Buffer
+			png.Encode(&buffer, img)
+			large := &countingReader{reader: bytes.NewReader(buffer.Bytes())}
+			transformer.MaxSourcePixels = 1000
+			_, _, err := transformer.Transform("", large, Options{Width: 100, Height: 100})
+			So(err.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(large.read, ShouldBeLessThan, large.reader.Size())
+		})
+	})
+}
+
+type countingReader struct {
+	reader *bytes.Reader
+	read   int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.read += int64(n)
+	return
+}
+
+func TestMemoryCache(t *testing.T) {
+
+	Convey("Least recently used variants should be evicted", t, func() {
+		cache := NewMemoryCache(10)
+		cache.Set("a", []byte("1234"), "image/png")
+		cache.Set("b", []byte("1234"), "image/png")
+		cache.Get("a")
+		cache.Set("c", []byte("1234"), "image/png")
+
+		_, _, found := cache.Get("b")
+		So(found, ShouldBeFalse)
+		_, _, found = cache.Get("a")
+		So(found, ShouldBeTrue)
+		So(cache.Size(), ShouldEqual, 8)
+	})
+}
@@ -72,6 +72,39 @@ func (ci *CoreInterceptorController) Execute(res, method string, interceptorType
 
 	log.Debug("ExecuteInterceptors: " + method + " " + typeNames[int(interceptorType)] + " " + res)
 	interceptors, extras, paths := ci.Get(res, method, interceptorType)
+	return execute(res, interceptorType, interceptors, extras, paths, requestScope, request, response, db)
+}
+
+/**
+ * Executes the interceptors of the resource except the ones matching the excluded resource
+ * and method as well. Used for the requests executed on another resource, so the interceptors
+ * matching both resources, like the ones added for AnyPath, are executed only once.
+ *
+ * Ex: ExecuteExcluding(controller, "/users/1/orders", "get", "/orders", "get", BEFORE_EXEC, rs, req, resp, db)
+ */
+func ExecuteExcluding(controller InterceptorController, excludedRes, excludedMethod, res, method string, interceptorType InterceptorType, requestScope requestscope.RequestScope, request, response messages.Message, db dataprovider.Provider) (editedRequest, editedResponse messages.Message, editedRequestScope requestscope.RequestScope, err *utils.Error) {
+
+	_, _, excludedPaths := controller.Get(excludedRes, excludedMethod, interceptorType)
+	excluded := make(map[string]bool, len(excludedPaths))
+	for _, path := range excludedPaths {
+		excluded[path] = true
+	}
+
+	allInterceptors, allExtras, allPaths := controller.Get(res, method, interceptorType)
+	interceptors := make([]Interceptor, 0, len(allInterceptors))
+	extras := make([]interface{}, 0, len(allExtras))
+	paths := make([]string, 0, len(allPaths))
+	for i, path := range allPaths {
+		if !excluded[path] {
+			interceptors = append(interceptors, allInterceptors[i])
+			extras = append(extras, allExtras[i])
+			paths = append(paths, path)
+		}
+	}
+	return execute(res, interceptorType, interceptors, extras, paths, requestScope, request, response, db)
+}
+
+func execute(res string, interceptorType InterceptorType, interceptors []Interceptor, extras []interface{}, paths []string, requestScope requestscope.RequestScope, request, response messages.Message, db dataprovider.Provider) (editedRequest, editedResponse messages.Message, editedRequestScope requestscope.RequestScope, err *utils.Error) {
 
 	var inputRequest, outputRequest, inputResponse, outputResponse messages.Message
 	var inputRequestScope, outputRequestScope requestscope.RequestScope
@@ -99,6 +132,8 @@ func (ci *CoreInterceptorController) Execute(res, method string, interceptorType
 
 		outputRequest, outputResponse, outputRequestScope, err = interceptor(inputRequestScope, extra, inputRequest, inputResponse, db)
 		if err != nil {
+			// request scope edited by the previous interceptors is returned for the ON_ERROR interceptors
+			editedRequestScope = inputRequestScope
 			log.WithFields(logrus.Fields{
 				"error":       err.Error(),
 				"interceptor": interceptorName,
@@ -0,0 +1,58 @@
+package ipfilter
+
+import (
+	"net"
+	"errors"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type CountryLookup interface {
+	// returns the ISO 3166-1 alpha-2 code of the country. empty if the ip is not in the database
+	Country(ip net.IP) (isoCode string, err error)
+}
+
+// country lookup backed by a local MaxMind-format database (GeoLite2-Country, GeoIP2-Country or compatible)
+type MaxMindLookup struct {
+	reader *maxminddb.Reader
+}
+
+type maxMindRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+func OpenMaxMind(path string) (lookup *MaxMindLookup, err error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return
+	}
+	lookup = &MaxMindLookup{reader: reader}
+	return
+}
+
+func (l *MaxMindLookup) Country(ip net.IP) (isoCode string, err error) {
+
+	if ip == nil {
+		err = errors.New("Invalid IP address.")
+		return
+	}
+
+	var record maxMindRecord
+	if err = l.reader.Lookup(ip, &record); err != nil {
+		return
+	}
+
+	isoCode = record.Country.ISOCode
+	if isoCode == "" {
+		isoCode = record.RegisteredCountry.ISOCode
+	}
+	return
+}
+
+func (l *MaxMindLookup) Close() error {
+	return l.reader.Close()
+}
@@ -0,0 +1,247 @@
+package ipfilter
+
+import (
+	"os"
+	"net"
+	"sync"
+	"time"
+	"regexp"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+)
+
+// request scope key of the country code resolved for the client ip
+const CountryKey = "country"
+
+/**
+ * Access rule for the paths matching the rich url.
+ *
+ * Addresses in the deny list are always rejected. If the allow list is not empty,
+ * only the addresses in the allow list are accepted. Both lists accept CIDR blocks
+ * and single addresses.
+ *
+ * Ex: {"path": "/admin/{function}", "method": "*", "allow": ["10.0.0.0/8"]}
+ */
+type Rule struct {
+	Path   string   `json:"path"`
+	Method string   `json:"method,omitempty"`
+	Allow  []string `json:"allow,omitempty"`
+	Deny   []string `json:"deny,omitempty"`
+}
+
+type compiledRule struct {
+	path   *regexp.Regexp
+	method string
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+type Filter struct {
+	Countries CountryLookup
+
+	mutex   sync.RWMutex
+	rules   []compiledRule
+	file    string
+	modTime time.Time
+}
+
+func New(rules []Rule) (filter *Filter, err error) {
+	filter = &Filter{}
+	err = filter.SetRules(rules)
+	return
+}
+
+/**
+ * Creates a filter with the rules in the json file. The file contains an array of rules.
+ * Call Watch to reload the rules when the file changes.
+ */
+func LoadFile(path string) (filter *Filter, err error) {
+	filter = &Filter{file: path}
+	err = filter.Reload()
+	return
+}
+
+func (f *Filter) SetRules(rules []Rule) (err error) {
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		entry := compiledRule{method: strings.ToLower(rule.Method)}
+		if entry.method == "" {
+			entry.method = methods.Any
+		}
+		if rule.Path != "*" {
+			if entry.path, err = regexp.Compile(utils.ConvertRichUrlToRegex(rule.Path, true)); err != nil {
+				return
+			}
+		}
+		if entry.allow, err = parseNetworks(rule.Allow); err != nil {
+			return
+		}
+		if entry.deny, err = parseNetworks(rule.Deny); err != nil {
+			return
+		}
+		compiled = append(compiled, entry)
+	}
+
+	f.mutex.Lock()
+	f.rules = compiled
+	f.mutex.Unlock()
+	return
+}
+
+func (f *Filter) Reload() (err error) {
+
+	info, err := os.Stat(f.file)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(f.file)
+	if err != nil {
+		return
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return
+	}
+	if err = f.SetRules(rules); err != nil {
+		return
+	}
+
+	f.mutex.Lock()
+	f.modTime = info.ModTime()
+	f.mutex.Unlock()
+	log.Info("IP filter rules loaded from " + f.file)
+	return
+}
+
+/**
+ * Checks the rules file periodically and reloads the rules if the file is modified.
+ * Current rules are kept if the new file is invalid. Returned function stops watching.
+ */
+func (f *Filter) Watch(interval time.Duration) (stop func()) {
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, statErr := os.Stat(f.file)
+				if statErr != nil {
+					log.Error("Checking IP filter rules failed. Reason: " + statErr.Error())
+					continue
+				}
+				f.mutex.RLock()
+				modified := !info.ModTime().Equal(f.modTime)
+				f.mutex.RUnlock()
+				if !modified {
+					continue
+				}
+				if reloadErr := f.Reload(); reloadErr != nil {
+					log.Error("Reloading IP filter rules failed. Reason: " + reloadErr.Error())
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+	return
+}
+
+// returns true if the ip is accepted by all rules matching the path and method
+func (f *Filter) Allowed(res, method, ip string) bool {
+
+	clientIP := net.ParseIP(ip)
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	for _, rule := range f.rules {
+		if rule.method != methods.Any && rule.method != method {
+			continue
+		}
+		if rule.path != nil && !rule.path.MatchString(res) {
+			continue
+		}
+		if clientIP == nil {
+			return false
+		}
+		if contains(rule.deny, clientIP) {
+			return false
+		}
+		if len(rule.allow) > 0 && !contains(rule.allow, clientIP) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * BEFORE_EXEC interceptor rejecting the requests which are not allowed by the rules.
+ * If a country lookup is set, the country of the client is stored in the request scope.
+ *
+ * Ex: core.Interceptors.Add(interceptors.AnyPath, methods.Any, interceptors.BEFORE_EXEC, filter.Intercept, nil)
+ */
+func (f *Filter) Intercept(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	if !f.Allowed(req.Res, req.Command, req.IP) {
+		err = &utils.Error{
+			Code:    http.StatusForbidden,
+			Message: "Access is not allowed from the client address.",
+		}
+		return
+	}
+
+	if f.Countries == nil {
+		return
+	}
+
+	country, lookupErr := f.Countries.Country(net.ParseIP(req.IP))
+	if lookupErr != nil {
+		log.Debug("Country lookup failed for " + req.IP + ". Reason: " + lookupErr.Error())
+		return
+	}
+	if country != "" {
+		editedRs = rs.Copy()
+		editedRs.Set(CountryKey, country)
+	}
+	return
+}
+
+func parseNetworks(values []string) (networks []*net.IPNet, err error) {
+
+	networks = make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		network, parseErr := utils.ParseCIDR(value)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package ipfilter
+
+import (
+	"os"
+	"net"
+	"time"
+	"testing"
+	"net/http"
+	"path/filepath"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type staticCountries map[string]string
+
+func (c staticCountries) Country(ip net.IP) (string, error) {
+	return c[ip.String()], nil
+}
+
+func TestFilter(t *testing.T) {
+
+	Convey("Given a filter restricting the admin functions to the office network", t, func() {
+		filter, err := New([]Rule{
+			{Path: "/admin/{function}", Allow: []string{"10.0.0.0/8", "2001:db8::/32"}},
+			{Path: "*", Method: "post", Deny: []string{"203.0.113.0/24"}},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Office addresses should be allowed", func() {
+			So(filter.Allowed("/admin/reset", "get", "10.1.2.3"), ShouldBeTrue)
+			So(filter.Allowed("/admin/reset", "get", "2001:db8::1"), ShouldBeTrue)
+		})
+
+		Convey("Other addresses should be rejected", func() {
+			So(filter.Allowed("/admin/reset", "get", "198.51.100.1"), ShouldBeFalse)
+		})
+
+		Convey("Unrestricted paths should be allowed", func() {
+			So(filter.Allowed("/users", "get", "198.51.100.1"), ShouldBeTrue)
+		})
+
+		Convey("Denied addresses should be rejected for the matching method", func() {
+			So(filter.Allowed("/users", "post", "203.0.113.9"), ShouldBeFalse)
+			So(filter.Allowed("/users", "get", "203.0.113.9"), ShouldBeTrue)
+		})
+
+		Convey("When the interceptor is executed with a country lookup", func() {
+			filter.Countries = staticCountries{"10.1.2.3": "TR"}
+			req := messages.Message{Res: "/admin/reset", Command: "get", IP: "10.1.2.3"}
+
+			_, _, editedRs, err := filter.Intercept(requestscope.Init(), nil, req, messages.Message{}, nil)
+
+			Convey("Country should be stored in the request scope", func() {
+				So(err, ShouldBeNil)
+				So(editedRs.Get(CountryKey), ShouldEqual, "TR")
+			})
+		})
+
+		Convey("When the interceptor is executed for a rejected address", func() {
+			req := messages.Message{Res: "/admin/reset", Command: "get", IP: "198.51.100.1"}
+
+			_, _, _, err := filter.Intercept(requestscope.Init(), nil, req, messages.Message{}, nil)
+
+			Convey("It should return forbidden", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+	})
+
+	Convey("Given a filter loaded from a file", t, func() {
+		file := filepath.Join(t.TempDir(), "rules.json")
+		So(os.WriteFile(file, []byte(`[{"path": "/admin/{function}", "allow": ["10.0.0.0/8"]}]`), 0644), ShouldBeNil)
+
+		filter, err := LoadFile(file)
+		So(err, ShouldBeNil)
+		So(filter.Allowed("/admin/reset", "get", "192.168.0.1"), ShouldBeFalse)
+
+		Convey("When the file is modified", func() {
+			stop := filter.Watch(10 * time.Millisecond)
+			defer stop()
+
+			later := time.Now().Add(time.Second)
+			So(os.WriteFile(file, []byte(`[{"path": "/admin/{function}", "allow": ["192.168.0.0/16"]}]`), 0644), ShouldBeNil)
+			So(os.Chtimes(file, later, later), ShouldBeNil)
+
+			Convey("Rules should be reloaded", func() {
+				So(waitFor(func() bool { return filter.Allowed("/admin/reset", "get", "192.168.0.1") }), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func waitFor(condition func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,450 @@
+package jobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"net/http"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+)
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+)
+
+type Job struct {
+	ID          string                 `json:"-"`
+	Name        string                 `json:"name"`
+	Payload     map[string]interface{} `json:"payload,omitempty"`
+	Status      Status                 `json:"status"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"maxAttempts"`
+	RunAt       time.Time              `json:"runAt"`
+	Error       string                 `json:"error,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt"`
+	UpdatedAt   time.Time              `json:"updatedAt"`
+	// running jobs are run again after the lease expires, the process running them is assumed to be stopped
+	LeaseExpiresAt time.Time `json:"leaseExpiresAt"`
+}
+
+// number of the due jobs read for each claim
+const dueBatchSize = 10
+
+// runs the job. returned error fails the attempt
+type Handler func(job Job) (err *utils.Error)
+
+type schedule struct {
+	name    string
+	cron    *utils.CronSchedule
+	payload map[string]interface{}
+	next    time.Time
+}
+
+/**
+ * Runs the jobs in the background. Jobs are enqueued by name with a payload and run
+ * by the handler of the name once they are due. Failed attempts are retried with
+ * exponential backoff until MaxAttempts, then the job is marked as failed.
+ *
+ * Ex: queue := jobs.NewQueue(jobs.NewMemoryStore())
+ *     queue.Handle("sendEmail", sendEmail)
+ *     queue.Schedule("dailyReport", "0 6 * * *", nil)
+ *     queue.Start()
+ *     defer queue.Stop()
+ *
+ *     queue.Enqueue("sendEmail", map[string]interface{}{"to": "user@mail.com"})
+ *     queue.EnqueueIn("sendEmail", payload, time.Hour)
+ *
+ *     core.Functions.Add("/jobs", methods.Get, queue.List, nil)
+ *     core.Functions.Add("/jobs/{id}", methods.Get, queue.Status, nil)
+ *     core.Functions.Add("/jobs/{id}/_retry", methods.Post, queue.Retry, nil)
+ */
+type Queue struct {
+	Store Store
+	// number of the jobs run at the same time
+	Workers int
+	// interval of checking the due jobs and the schedules
+	PollInterval time.Duration
+	// number of the attempts including the first one
+	MaxAttempts int
+	// delay before the first retry. doubled for each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// duration of running a job before it's run again by another worker. should be longer than the jobs
+	LeaseTimeout time.Duration
+	// used to get the current time, for testing purposes
+	Now func() time.Time
+
+	// guards the handlers and the schedules. jobs are claimed with the conditional updates of the store
+	lock      sync.Mutex
+	handlers  map[string]Handler
+	schedules []*schedule
+	stop      chan struct{}
+	workers   sync.WaitGroup
+}
+
+func NewQueue(store Store) *Queue {
+	return &Queue{
+		Store:        store,
+		Workers:      4,
+		PollInterval: time.Second,
+		MaxAttempts:  5,
+		BaseDelay:    10 * time.Second,
+		MaxDelay:     time.Hour,
+		LeaseTimeout: 30 * time.Minute,
+		handlers:     make(map[string]Handler),
+	}
+}
+
+func (q *Queue) Handle(name string, handler Handler) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.handlers == nil {
+		q.handlers = make(map[string]Handler)
+	}
+	q.handlers[name] = handler
+}
+
+func (q *Queue) handlerOf(name string) (handler Handler, exists bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	handler, exists = q.handlers[name]
+	return
+}
+
+func (q *Queue) Enqueue(name string, payload map[string]interface{}) (job Job, err *utils.Error) {
+	return q.EnqueueAt(name, payload, q.now())
+}
+
+func (q *Queue) EnqueueIn(name string, payload map[string]interface{}, delay time.Duration) (job Job, err *utils.Error) {
+	return q.EnqueueAt(name, payload, q.now().Add(delay))
+}
+
+func (q *Queue) EnqueueAt(name string, payload map[string]interface{}, runAt time.Time) (job Job, err *utils.Error) {
+
+	if _, exists := q.handlerOf(name); !exists {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Job '" + name + "' doesn't have a handler."}
+		return
+	}
+
+	now := q.now()
+	return q.Store.Create(Job{
+		Name:        name,
+		Payload:     payload,
+		Status:      Pending,
+		MaxAttempts: q.maxAttempts(),
+		RunAt:       runAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+}
+
+/**
+ * Enqueues the job at the times of the cron expression. Schedules are kept in the
+ * memory, so they need to be added each time the process starts. Times missed while
+ * the process is not running are skipped.
+ *
+ * Ex: queue.Schedule("cleanup", "30 2 * * mon-fri", nil)
+ */
+func (q *Queue) Schedule(name, expression string, payload map[string]interface{}) (err *utils.Error) {
+
+	if _, exists := q.handlerOf(name); !exists {
+		return &utils.Error{Code: http.StatusBadRequest, Message: "Job '" + name + "' doesn't have a handler."}
+	}
+	cron, parseErr := utils.ParseCron(expression)
+	if parseErr != nil {
+		return &utils.Error{Code: http.StatusBadRequest, Message: parseErr.Error()}
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.schedules = append(q.schedules, &schedule{name, cron, payload, cron.Next(q.now())})
+	return
+}
+
+// enqueues the scheduled jobs that are due
+func (q *Queue) enqueueScheduled() {
+
+	now := q.now()
+	var due []schedule
+	q.lock.Lock()
+	for _, s := range q.schedules {
+		if !s.next.IsZero() && !s.next.After(now) {
+			due = append(due, *s)
+			s.next = s.cron.Next(now)
+		}
+	}
+	q.lock.Unlock()
+
+	for _, s := range due {
+		if _, err := q.EnqueueAt(s.name, s.payload, s.next); err != nil {
+			log.Error("Enqueueing scheduled job '" + s.name + "' failed. Reason: " + err.Message)
+		}
+	}
+}
+
+/**
+ * Starts the workers and the scheduler. Running jobs with expired leases, left by the
+ * stopped processes, are run again.
+ */
+func (q *Queue) Start() {
+
+	stop := make(chan struct{})
+	q.stop = stop
+	q.workers.Add(q.Workers + 1)
+	go func() {
+		defer q.workers.Done()
+		ticker := time.NewTicker(q.PollInterval)
+		defer ticker.Stop()
+		for {
+			q.enqueueScheduled()
+			q.requeueExpired()
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	for i := 0; i < q.Workers; i++ {
+		go func() {
+			defer q.workers.Done()
+			for {
+				// next job is claimed without waiting while there are due jobs
+				wait := q.PollInterval
+				if job, claimed := q.claim(); claimed {
+					q.run(job)
+					wait = 0
+				}
+				select {
+				case <-stop:
+					return
+				case <-time.After(wait):
+				}
+			}
+		}()
+	}
+}
+
+// stops the workers after the running jobs complete
+func (q *Queue) Stop() {
+	if q.stop != nil {
+		close(q.stop)
+		q.workers.Wait()
+		q.stop = nil
+	}
+}
+
+// enqueues the scheduled jobs and runs the due jobs one by one. returns the number of the jobs run
+func (q *Queue) RunDue() (count int) {
+	q.enqueueScheduled()
+	q.requeueExpired()
+	for {
+		job, claimed := q.claim()
+		if !claimed {
+			return
+		}
+		q.run(job)
+		count++
+	}
+}
+
+// marks the running jobs with the expired leases as pending, jobs running in the other processes are kept
+func (q *Queue) requeueExpired() {
+	running, err := q.Store.List(Running)
+	if err != nil {
+		log.Error("Reading running jobs failed. Reason: " + err.Message)
+		return
+	}
+	now := q.now()
+	for _, job := range running {
+		if job.LeaseExpiresAt.After(now) {
+			continue
+		}
+		requeued := job
+		requeued.Status = Pending
+		requeued.UpdatedAt = now
+		if _, err = q.Store.UpdateIf(requeued, Running, job.Attempts); err != nil {
+			log.Error("Requeueing job '" + job.ID + "' failed. Reason: " + err.Message)
+		}
+	}
+}
+
+// marks the first due job as running. jobs claimed by the other workers in the meantime are skipped
+func (q *Queue) claim() (job Job, claimed bool) {
+
+	now := q.now()
+	due, err := q.Store.Due(now, dueBatchSize)
+	if err != nil {
+		log.Error("Reading due jobs failed. Reason: " + err.Message)
+		return
+	}
+	for _, pending := range due {
+		job = pending
+		job.Status = Running
+		job.Attempts++
+		job.UpdatedAt = now
+		job.LeaseExpiresAt = now.Add(q.LeaseTimeout)
+		updated, err := q.Store.UpdateIf(job, Pending, pending.Attempts)
+		if err != nil {
+			log.Error("Claiming job '" + job.ID + "' failed. Reason: " + err.Message)
+			continue
+		}
+		if updated {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+func (q *Queue) run(job Job) {
+
+	var err *utils.Error
+	if handler, exists := q.handlerOf(job.Name); exists {
+		err = call(handler, job)
+	} else {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Job '" + job.Name + "' doesn't have a handler."}
+		job.Attempts = job.MaxAttempts
+	}
+
+	job.UpdatedAt = q.now()
+	if err == nil {
+		job.Status = Succeeded
+		job.Error = ""
+	} else if job.Attempts < job.MaxAttempts {
+		job.Status = Pending
+		job.Error = err.Message
+		job.RunAt = job.UpdatedAt.Add(q.Backoff(job.Attempts))
+	} else {
+		job.Status = Failed
+		job.Error = err.Message
+		log.Warning("Job '" + job.Name + "' failed after " + fmt.Sprint(job.Attempts) + " attempts. Reason: " + err.Message)
+	}
+	// result is dropped if the lease expired and the job is claimed again
+	if updated, updateErr := q.Store.UpdateIf(job, Running, job.Attempts); updateErr != nil {
+		log.Error("Updating job '" + job.ID + "' failed. Reason: " + updateErr.Message)
+	} else if !updated {
+		log.Warning("Result of job '" + job.ID + "' is dropped since its lease expired.")
+	}
+}
+
+// panics of the handlers fail the attempt
+func call(handler Handler, job Job) (err *utils.Error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &utils.Error{Code: http.StatusInternalServerError, Message: fmt.Sprint("Job panicked: ", recovered)}
+		}
+	}()
+	return handler(job)
+}
+
+// returns the delay before the retry
+func (q *Queue) Backoff(retry int) time.Duration {
+	delay := q.BaseDelay
+	for i := 1; i < retry && (q.MaxDelay <= 0 || delay < q.MaxDelay); i++ {
+		delay *= 2
+	}
+	if q.MaxDelay > 0 && delay > q.MaxDelay {
+		delay = q.MaxDelay
+	}
+	return delay
+}
+
+/**
+ * Function returning the jobs, filtered by the status and the name parameters.
+ *
+ * GET /jobs?status=failed&name=sendEmail
+ */
+func (q *Queue) List(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	status, _ := req.GetParameter("status")
+	name, _ := req.GetParameter("name")
+	jobs, err := q.Store.List(Status(status))
+	if err != nil {
+		return
+	}
+
+	results := make([]interface{}, 0, len(jobs))
+	for _, job := range jobs {
+		if name == "" || job.Name == name {
+			results = append(results, objectOf(job))
+		}
+	}
+	resp.Body = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
+
+// function returning the job. ex: GET /jobs/5
+func (q *Queue) Status(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	job, err := q.Store.Get(req.Res[strings.LastIndex(req.Res, "/")+1:])
+	if err == nil {
+		resp.Body = objectOf(job)
+	}
+	return
+}
+
+// function running the failed job again with new attempts. ex: POST /jobs/5/_retry
+func (q *Queue) Retry(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	parts := strings.Split(req.Res, "/")
+	if len(parts) < 3 {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Job id is missing."}
+		return
+	}
+
+	job, err := q.Store.Get(parts[len(parts)-2])
+	if err != nil {
+		return
+	}
+	if job.Status != Failed {
+		err = &utils.Error{Code: http.StatusConflict, Message: "Only the failed jobs can be retried."}
+		return
+	}
+
+	retried := job
+	retried.Status = Pending
+	retried.Attempts = 0
+	retried.MaxAttempts = q.maxAttempts()
+	retried.RunAt = q.now()
+	retried.UpdatedAt = retried.RunAt
+	updated, err := q.Store.UpdateIf(retried, Failed, job.Attempts)
+	if err != nil {
+		return
+	}
+	if !updated {
+		err = &utils.Error{Code: http.StatusConflict, Message: "Only the failed jobs can be retried."}
+		return
+	}
+	resp.Body = objectOf(retried)
+	return
+}
+
+func objectOf(job Job) map[string]interface{} {
+	object := dataprovider.ToObject(job)
+	object[dataprovider.IdField] = job.ID
+	return object
+}
+
+func (q *Queue) maxAttempts() int {
+	if q.MaxAttempts < 1 {
+		return 1
+	}
+	return q.MaxAttempts
+}
+
+func (q *Queue) now() time.Time {
+	if q.Now != nil {
+		return q.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,333 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"strconv"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type collectionProvider struct {
+	dataprovider.Provider
+	lock    sync.Mutex
+	objects map[string]map[string]interface{}
+	queries int
+}
+
+func (p *collectionProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	id := strconv.Itoa(len(p.objects) + 1)
+	p.objects[id] = data
+	return map[string]interface{}{dataprovider.IdField: id}, nil
+}
+
+func (p *collectionProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.objects[id] = data
+	return data, nil
+}
+
+func (p *collectionProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if object, exists := p.objects[id]; exists {
+		return object, nil
+	}
+	return nil, &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+}
+
+// filters by the status and pages the objects ordered by the ids, runAt filter and sort are left to the store
+func (p *collectionProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.queries++
+
+	var where map[string]interface{}
+	if values, hasWhere := parameters["where"]; hasWhere {
+		json.Unmarshal([]byte(values[0]), &where)
+	}
+	ids := make([]string, 0, len(p.objects))
+	for id := range p.objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		first, _ := strconv.Atoi(ids[i])
+		second, _ := strconv.Atoi(ids[j])
+		return first < second
+	})
+
+	results := make([]interface{}, 0)
+	for _, id := range ids {
+		object := p.objects[id]
+		if status, hasStatus := where["status"]; hasStatus && object["status"] != status {
+			continue
+		}
+		copied := map[string]interface{}{dataprovider.IdField: id}
+		for key, value := range object {
+			copied[key] = value
+		}
+		results = append(results, copied)
+	}
+
+	skip, limit := 0, len(results)
+	if values, hasSkip := parameters["skip"]; hasSkip {
+		skip, _ = strconv.Atoi(values[0])
+	}
+	if values, hasLimit := parameters["limit"]; hasLimit {
+		limit, _ = strconv.Atoi(values[0])
+	}
+	if skip > len(results) {
+		skip = len(results)
+	}
+	results = results[skip:]
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return map[string]interface{}{dataprovider.ResultsField: results}, nil
+}
+
+// updates the objects matching the filter, like the providers claiming the objects atomically
+type conditionalProvider struct {
+	*collectionProvider
+}
+
+func (p *conditionalProvider) UpdateIf(collection string, id string, where map[string]interface{}, data map[string]interface{}) (updated bool, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	object, exists := p.objects[id]
+	if !exists {
+		return false, &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+	}
+	encoded, _ := json.Marshal(where)
+	var filter map[string]interface{}
+	json.Unmarshal(encoded, &filter)
+	for field, value := range filter {
+		if fmt.Sprint(object[field]) != fmt.Sprint(value) {
+			return
+		}
+	}
+	p.objects[id] = data
+	return true, nil
+}
+
+func TestQueue(t *testing.T) {
+
+	stores := map[string]func() Store{
+		"memory": func() Store { return NewMemoryStore() },
+		"provider": func() Store {
+			return &ProviderStore{Provider: &collectionProvider{objects: map[string]map[string]interface{}{}}, Collection: "jobs", PageSize: 2}
+		},
+		"conditional provider": func() Store {
+			provider := &collectionProvider{objects: map[string]map[string]interface{}{}}
+			return &ProviderStore{Provider: &conditionalProvider{provider}, Collection: "jobs", PageSize: 2}
+		},
+	}
+
+	for name, newStore := range stores {
+		Convey("Given a queue with the "+name+" store", t, func() {
+			now := time.Date(2024, time.January, 31, 10, 0, 0, 0, time.UTC)
+			queue := NewQueue(newStore())
+			queue.Now = func() time.Time { return now }
+
+			var lock sync.Mutex
+			runs := []string{}
+			failures := 0
+			queue.Handle("email", func(job Job) *utils.Error {
+				lock.Lock()
+				defer lock.Unlock()
+				runs = append(runs, job.Payload["to"].(string))
+				if failures > 0 {
+					failures--
+					return &utils.Error{Code: http.StatusBadGateway, Message: "Mail server is down."}
+				}
+				return nil
+			})
+
+			Convey("Due jobs should be run", func() {
+				job, err := queue.Enqueue("email", map[string]interface{}{"to": "a"})
+				So(err, ShouldBeNil)
+				queue.EnqueueIn("email", map[string]interface{}{"to": "b"}, time.Minute)
+
+				So(queue.RunDue(), ShouldEqual, 1)
+				So(runs, ShouldResemble, []string{"a"})
+				job, _ = queue.Store.Get(job.ID)
+				So(job.Status, ShouldEqual, Succeeded)
+
+				now = now.Add(time.Minute)
+				So(queue.RunDue(), ShouldEqual, 1)
+				So(runs, ShouldResemble, []string{"a", "b"})
+			})
+
+			Convey("Failed jobs should be retried with backoff", func() {
+				queue.MaxAttempts = 3
+				failures = 3
+				job, _ := queue.Enqueue("email", map[string]interface{}{"to": "a"})
+
+				queue.RunDue()
+				job, _ = queue.Store.Get(job.ID)
+				So(job.Status, ShouldEqual, Pending)
+				So(job.Attempts, ShouldEqual, 1)
+				So(job.Error, ShouldEqual, "Mail server is down.")
+				So(job.RunAt, ShouldEqual, now.Add(10*time.Second))
+
+				So(queue.RunDue(), ShouldEqual, 0)
+				now = now.Add(10 * time.Second)
+				queue.RunDue()
+				now = now.Add(20 * time.Second)
+				queue.RunDue()
+
+				job, _ = queue.Store.Get(job.ID)
+				So(job.Status, ShouldEqual, Failed)
+				So(job.Attempts, ShouldEqual, 3)
+
+				Convey("Failed jobs should be retried by the endpoint", func() {
+					resp, _, err := queue.Retry(messages.Message{Res: "/jobs/" + job.ID + "/_retry", Command: methods.Post}, requestscope.Init(), nil, nil)
+					So(err, ShouldBeNil)
+					So(resp.Body["status"], ShouldEqual, "pending")
+
+					queue.RunDue()
+					job, _ = queue.Store.Get(job.ID)
+					So(job.Status, ShouldEqual, Succeeded)
+
+					_, _, err = queue.Retry(messages.Message{Res: "/jobs/" + job.ID + "/_retry", Command: methods.Post}, requestscope.Init(), nil, nil)
+					So(err.Code, ShouldEqual, http.StatusConflict)
+				})
+			})
+
+			Convey("Panics should fail the attempt", func() {
+				queue.MaxAttempts = 1
+				queue.Handle("report", func(job Job) *utils.Error { panic("nil map") })
+				job, _ := queue.Enqueue("report", nil)
+				queue.RunDue()
+
+				job, _ = queue.Store.Get(job.ID)
+				So(job.Status, ShouldEqual, Failed)
+				So(job.Error, ShouldContainSubstring, "nil map")
+			})
+
+			Convey("Scheduled jobs should be enqueued at the times of the schedule", func() {
+				So(queue.Schedule("email", "*/30 * * * *", map[string]interface{}{"to": "team"}), ShouldBeNil)
+				So(queue.RunDue(), ShouldEqual, 0)
+
+				now = now.Add(30 * time.Minute)
+				So(queue.RunDue(), ShouldEqual, 1)
+				now = now.Add(time.Hour)
+				So(queue.RunDue(), ShouldEqual, 1)
+				So(runs, ShouldResemble, []string{"team", "team"})
+
+				So(queue.Schedule("email", "* * *", nil).Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("Claimed jobs should not be claimed again", func() {
+				job, _ := queue.Enqueue("email", map[string]interface{}{"to": "a"})
+				claimed, isClaimed := queue.claim()
+				So(isClaimed, ShouldBeTrue)
+				So(claimed.ID, ShouldEqual, job.ID)
+
+				_, isClaimed = queue.claim()
+				So(isClaimed, ShouldBeFalse)
+				updated, err := queue.Store.UpdateIf(job, Pending, 0)
+				So(err, ShouldBeNil)
+				So(updated, ShouldBeFalse)
+			})
+
+			Convey("Running jobs should be run again only after their leases expire", func() {
+				job, _ := queue.Enqueue("email", map[string]interface{}{"to": "a"})
+				queue.claim()
+				So(queue.RunDue(), ShouldEqual, 0)
+
+				now = now.Add(queue.LeaseTimeout)
+				So(queue.RunDue(), ShouldEqual, 1)
+				job, _ = queue.Store.Get(job.ID)
+				So(job.Status, ShouldEqual, Succeeded)
+				So(job.Attempts, ShouldEqual, 2)
+			})
+
+			Convey("Jobs of all the pages should be listed", func() {
+				for i := 0; i < 5; i++ {
+					queue.Enqueue("email", map[string]interface{}{"to": "a"})
+				}
+				jobs, err := queue.Store.List(Pending)
+				So(err, ShouldBeNil)
+				So(jobs, ShouldHaveLength, 5)
+			})
+
+			Convey("Jobs without handlers should be rejected", func() {
+				_, err := queue.Enqueue("unknown", nil)
+				So(err.Code, ShouldEqual, http.StatusBadRequest)
+			})
+
+			Convey("Jobs should be listed and read by the endpoints", func() {
+				job, _ := queue.Enqueue("email", map[string]interface{}{"to": "a"})
+				queue.EnqueueIn("email", map[string]interface{}{"to": "b"}, time.Hour)
+				queue.RunDue()
+
+				resp, _, err := queue.List(messages.Message{Res: "/jobs", Parameters: map[string][]string{"status": {"pending"}}}, requestscope.Init(), nil, nil)
+				So(err, ShouldBeNil)
+				So(resp.Body[dataprovider.ResultsField], ShouldHaveLength, 1)
+
+				resp, _, err = queue.Status(messages.Message{Res: "/jobs/" + job.ID}, requestscope.Init(), nil, nil)
+				So(err, ShouldBeNil)
+				So(resp.Body[dataprovider.IdField], ShouldEqual, job.ID)
+				So(resp.Body["status"], ShouldEqual, "succeeded")
+
+				_, _, err = queue.Status(messages.Message{Res: "/jobs/99"}, requestscope.Init(), nil, nil)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	}
+
+	Convey("Given a provider store with an invalid job", t, func() {
+		provider := &collectionProvider{objects: map[string]map[string]interface{}{}}
+		provider.Create("jobs", map[string]interface{}{"name": "email", "status": "pending", "runAt": "yesterday"})
+		queue := NewQueue(&ProviderStore{Provider: provider, Collection: "jobs"})
+		queue.Handle("email", func(job Job) *utils.Error { return nil })
+
+		Convey("Invalid jobs should be skipped", func() {
+			job, _ := queue.Enqueue("email", nil)
+			So(queue.RunDue(), ShouldEqual, 1)
+			job, _ = queue.Store.Get(job.ID)
+			So(job.Status, ShouldEqual, Succeeded)
+
+			jobs, err := queue.Store.List("")
+			So(err, ShouldBeNil)
+			So(jobs, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Given a started queue", t, func() {
+		queue := NewQueue(NewMemoryStore())
+		queue.PollInterval = 10 * time.Millisecond
+
+		var done sync.WaitGroup
+		done.Add(3)
+		queue.Handle("email", func(job Job) *utils.Error {
+			done.Done()
+			return nil
+		})
+
+		Convey("Workers should run the enqueued jobs", func() {
+			queue.Start()
+			for i := 0; i < 3; i++ {
+				queue.Enqueue("email", nil)
+			}
+			done.Wait()
+			queue.Stop()
+
+			succeeded, _ := queue.Store.List(Succeeded)
+			So(succeeded, ShouldHaveLength, 3)
+		})
+	})
+}
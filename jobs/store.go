@@ -0,0 +1,254 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"strconv"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+/**
+ * Persists the jobs. List returns the jobs with the status ordered by RunAt, all the
+ * jobs if the status is empty. Due returns the pending jobs due at the time ordered by
+ * RunAt, up to the limit. UpdateIf updates the job only if its stored status and
+ * attempts are still the given ones, so a job is claimed by a single worker.
+ */
+type Store interface {
+	Create(job Job) (created Job, err *utils.Error)
+	Update(job Job) (err *utils.Error)
+	UpdateIf(job Job, status Status, attempts int) (updated bool, err *utils.Error)
+	Get(id string) (job Job, err *utils.Error)
+	List(status Status) (jobs []Job, err *utils.Error)
+	Due(now time.Time, limit int) (jobs []Job, err *utils.Error)
+}
+
+// keeps the jobs in the memory. jobs are lost when the process exits
+type MemoryStore struct {
+	lock   sync.RWMutex
+	jobs   map[string]Job
+	lastID int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+func (s *MemoryStore) Create(job Job) (created Job, err *utils.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastID++
+	job.ID = strconv.Itoa(s.lastID)
+	s.jobs[job.ID] = job
+	return job, nil
+}
+
+func (s *MemoryStore) Update(job Job) (err *utils.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, exists := s.jobs[job.ID]; !exists {
+		return notFound(job.ID)
+	}
+	s.jobs[job.ID] = job
+	return
+}
+
+func (s *MemoryStore) UpdateIf(job Job, status Status, attempts int) (updated bool, err *utils.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored, exists := s.jobs[job.ID]
+	if !exists {
+		return false, notFound(job.ID)
+	}
+	if stored.Status != status || stored.Attempts != attempts {
+		return
+	}
+	s.jobs[job.ID] = job
+	return true, nil
+}
+
+func (s *MemoryStore) Get(id string) (job Job, err *utils.Error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	job, exists := s.jobs[id]
+	if !exists {
+		err = notFound(id)
+	}
+	return
+}
+
+func (s *MemoryStore) List(status Status) (jobs []Job, err *utils.Error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, job := range s.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sortJobs(jobs)
+	return
+}
+
+func (s *MemoryStore) Due(now time.Time, limit int) (jobs []Job, err *utils.Error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, job := range s.jobs {
+		if job.Status == Pending && !job.RunAt.After(now) {
+			jobs = append(jobs, job)
+		}
+	}
+	sortJobs(jobs)
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return
+}
+
+/**
+ * Keeps the jobs in a collection of the data provider. Jobs are claimed with the
+ * conditional updates if the provider implements dataprovider.ConditionalUpdater, so
+ * a collection can be shared by the queues of multiple processes. Otherwise the
+ * claims are guarded only in the process.
+ *
+ * Ex: queue := jobs.NewQueue(&jobs.ProviderStore{Provider: core.DataProvider, Collection: "jobs"})
+ */
+type ProviderStore struct {
+	Provider   dataprovider.Provider
+	Collection string
+	// page size of the queries reading the jobs. 1000 if not set
+	PageSize int
+}
+
+func (s *ProviderStore) Create(job Job) (created Job, err *utils.Error) {
+	response, err := s.Provider.Create(s.Collection, dataprovider.ToObject(job))
+	if err != nil {
+		return
+	}
+	created = job
+	created.ID = fmt.Sprint(response[dataprovider.IdField])
+	return
+}
+
+func (s *ProviderStore) Update(job Job) (err *utils.Error) {
+	_, err = s.Provider.Update(s.Collection, job.ID, dataprovider.ToObject(job))
+	return
+}
+
+func (s *ProviderStore) UpdateIf(job Job, status Status, attempts int) (updated bool, err *utils.Error) {
+
+	if updater, isUpdater := s.Provider.(dataprovider.ConditionalUpdater); isUpdater {
+		where := map[string]interface{}{"status": status, "attempts": attempts}
+		return updater.UpdateIf(s.Collection, job.ID, where, dataprovider.ToObject(job))
+	}
+
+	stored, err := s.Get(job.ID)
+	if err != nil || stored.Status != status || stored.Attempts != attempts {
+		return
+	}
+	if err = s.Update(job); err == nil {
+		updated = true
+	}
+	return
+}
+
+func (s *ProviderStore) Get(id string) (job Job, err *utils.Error) {
+	object, err := s.Provider.Get(s.Collection, id)
+	if err != nil {
+		return
+	}
+	if decodeErr := dataprovider.FromObject(object, &job); decodeErr != nil {
+		return job, decodeError(decodeErr)
+	}
+	job.ID = id
+	return
+}
+
+func (s *ProviderStore) List(status Status) (jobs []Job, err *utils.Error) {
+
+	where := map[string]interface{}{}
+	if status != "" {
+		where["status"] = status
+	}
+	jobs, err = s.query(where, 0)
+	if err != nil {
+		return
+	}
+
+	// filtered again since the providers may ignore the where parameter
+	filtered := jobs[:0]
+	for _, job := range jobs {
+		if status == "" || job.Status == status {
+			filtered = append(filtered, job)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *ProviderStore) Due(now time.Time, limit int) (jobs []Job, err *utils.Error) {
+
+	where := map[string]interface{}{"status": Pending, "runAt": map[string]interface{}{"$lte": now}}
+	all, err := s.query(where, limit)
+	if err != nil {
+		return
+	}
+
+	// filtered again since the providers may ignore the where parameter
+	for _, job := range all {
+		if job.Status == Pending && !job.RunAt.After(now) {
+			jobs = append(jobs, job)
+		}
+	}
+	return
+}
+
+// reads the jobs matching the filter ordered by RunAt. objects that can't be decoded are skipped
+func (s *ProviderStore) query(where map[string]interface{}, max int) (jobs []Job, err *utils.Error) {
+
+	parameters := map[string][]string{dataprovider.SortParameter: {"runAt"}}
+	if len(where) > 0 {
+		encoded, _ := json.Marshal(where)
+		parameters["where"] = []string{string(encoded)}
+	}
+
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	objects, _, err := dataprovider.QueryAll(s.Provider, s.Collection, parameters, pageSize, max)
+	if err != nil {
+		return
+	}
+
+	jobs = make([]Job, 0, len(objects))
+	for _, object := range objects {
+		var job Job
+		id := fmt.Sprint(object[dataprovider.IdField])
+		if decodeErr := dataprovider.FromObject(object, &job); decodeErr != nil {
+			log.Error("Skipping job '" + id + "'. Reason: " + decodeErr.Error())
+			continue
+		}
+		job.ID = id
+		jobs = append(jobs, job)
+	}
+	sortJobs(jobs)
+	return
+}
+
+func sortJobs(jobs []Job) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].RunAt.Before(jobs[j].RunAt)
+	})
+}
+
+func notFound(id string) *utils.Error {
+	return &utils.Error{Code: http.StatusNotFound, Message: "Job '" + id + "' doesn't exist."}
+}
+
+func decodeError(decodeErr error) *utils.Error {
+	return &utils.Error{Code: http.StatusInternalServerError, Message: "Decoding job failed. Reason: " + decodeErr.Error()}
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"io"
+	"bytes"
+	"errors"
+	"regexp"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/codecs"
+)
+
+// limits of the request and response bodies. zero values mean unlimited
+type BodyLimits struct {
+	// request bodies larger than this are rejected with 413
+	MaxBodySize int64
+	// maximum nesting depth of the objects and arrays. the root object is at depth 1
+	MaxDepth int
+	// maximum number of the elements in an array
+	MaxArrayLength int
+	// maximum number of the keys in an object
+	MaxKeys int
+	// json objects with the same key more than once are rejected instead of keeping the last value
+	RejectDuplicateKeys bool
+	// decodes the json numbers as json.Number instead of float64, keeping the precision of large ids
+	UseNumber bool
+	// responses encoded larger than this are replaced with an error
+	MaxResponseSize int64
+}
+
+// limits of the paths without route limits
+var DefaultBodyLimits = BodyLimits{MaxBodySize: 10 << 20, MaxDepth: 64}
+
+type routeBodyLimits struct {
+	regex  *regexp.Regexp
+	limits BodyLimits
+}
+
+var routeLimits []routeBodyLimits
+
+/**
+ * Sets the limits of the paths matching the rich url. Limits of the route added
+ * first are used if the path matches more than one route.
+ *
+ * Ex: core.SetBodyLimits("/posts/{id}", core.BodyLimits{MaxBodySize: 1 << 20, MaxDepth: 8, UseNumber: true})
+ */
+func SetBodyLimits(path string, limits BodyLimits) {
+	regex := regexp.MustCompile(utils.ConvertRichUrlToRegex(path, true))
+	routeLimits = append(routeLimits, routeBodyLimits{regex, limits})
+}
+
+func bodyLimitsOf(res string) BodyLimits {
+	for _, route := range routeLimits {
+		if route.regex.MatchString(res) {
+			return route.limits
+		}
+	}
+	return DefaultBodyLimits
+}
+
+// decodes the body by enforcing the limits
+func decodeBody(body io.Reader, codec codecs.Codec, limits BodyLimits) (value interface{}, err *utils.Error) {
+
+	if limits.MaxBodySize > 0 {
+		body = http.MaxBytesReader(nil, io.NopCloser(body), limits.MaxBodySize)
+	}
+
+	var decodeErr error
+	if jsonCodec, isJSON := codec.(*codecs.JSON); isJSON {
+		// json is scanned before decoding, so the structure is checked without allocating the values
+		data, readErr := io.ReadAll(body)
+		if readErr != nil {
+			err = readError(readErr)
+			return
+		}
+		if err = scanJSON(data, limits); err != nil {
+			return
+		}
+		if limits.UseNumber && !jsonCodec.UseNumber {
+			codec = &codecs.JSON{UseNumber: true}
+		}
+		value, decodeErr = codec.Decode(bytes.NewReader(data))
+	} else {
+		value, decodeErr = codec.Decode(body)
+		if decodeErr == nil {
+			err = checkStructure(value, limits, 1)
+		}
+	}
+
+	if decodeErr != nil {
+		err = readError(decodeErr)
+	}
+	return
+}
+
+func readError(readErr error) *utils.Error {
+	if isBodyTooLarge(readErr) {
+		return &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Request body is too large."}
+	}
+	return &utils.Error{Code: http.StatusBadRequest, Message: "Parsing request body failed. Reason: " + readErr.Error()}
+}
+
+func isBodyTooLarge(readErr error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(readErr, &maxBytesErr)
+}
+
+type jsonFrame struct {
+	isObject  bool
+	expectKey bool
+	length    int
+	keys      map[string]bool
+}
+
+func scanJSON(data []byte, limits BodyLimits) (err *utils.Error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	stack := make([]*jsonFrame, 0)
+
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr == io.EOF {
+			return
+		}
+		if tokenErr != nil {
+			return readError(tokenErr)
+		}
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		// keys of the objects
+		if key, isString := token.(string); isString && top != nil && top.isObject && top.expectKey {
+			top.expectKey = false
+			top.length++
+			if limits.MaxKeys > 0 && top.length > limits.MaxKeys {
+				return structureError("Object in the request body has too many keys.")
+			}
+			if limits.RejectDuplicateKeys {
+				if top.keys[key] {
+					return structureError("Object in the request body has duplicate key '" + key + "'.")
+				}
+				top.keys[key] = true
+			}
+			continue
+		}
+
+		if delim, isDelim := token.(json.Delim); isDelim && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		// values of the objects and elements of the arrays
+		if top != nil {
+			if top.isObject {
+				top.expectKey = true
+			} else {
+				top.length++
+				if limits.MaxArrayLength > 0 && top.length > limits.MaxArrayLength {
+					return structureError("Array in the request body has too many elements.")
+				}
+			}
+		}
+
+		if delim, isDelim := token.(json.Delim); isDelim {
+			frame := &jsonFrame{isObject: delim == '{', expectKey: delim == '{'}
+			if frame.isObject && limits.RejectDuplicateKeys {
+				frame.keys = make(map[string]bool)
+			}
+			stack = append(stack, frame)
+			if limits.MaxDepth > 0 && len(stack) > limits.MaxDepth {
+				return structureError("Request body is nested too deeply.")
+			}
+		}
+	}
+}
+
+// checks the decoded values of the codecs other than json
+func checkStructure(value interface{}, limits BodyLimits, depth int) (err *utils.Error) {
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+			return structureError("Request body is nested too deeply.")
+		}
+		if limits.MaxKeys > 0 && len(typed) > limits.MaxKeys {
+			return structureError("Object in the request body has too many keys.")
+		}
+		for _, item := range typed {
+			if err = checkStructure(item, limits, depth+1); err != nil {
+				return
+			}
+		}
+	case []interface{}:
+		if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+			return structureError("Request body is nested too deeply.")
+		}
+		if limits.MaxArrayLength > 0 && len(typed) > limits.MaxArrayLength {
+			return structureError("Array in the request body has too many elements.")
+		}
+		for _, item := range typed {
+			if err = checkStructure(item, limits, depth+1); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func structureError(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusBadRequest, Message: message}
+}
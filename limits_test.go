@@ -0,0 +1,88 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/rihtim/core/codecs"
+	"github.com/rihtim/core/messages"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBodyLimits(t *testing.T) {
+
+	limits := BodyLimits{MaxBodySize: 128, MaxDepth: 3, MaxArrayLength: 3, MaxKeys: 3, RejectDuplicateKeys: true, UseNumber: true}
+	decode := func(codec codecs.Codec, body string) (interface{}, int) {
+		value, err := decodeBody(strings.NewReader(body), codec, limits)
+		if err != nil {
+			return nil, err.Code
+		}
+		return value, 0
+	}
+
+	Convey("Given json bodies", t, func() {
+
+		Convey("Bodies in the limits should be decoded", func() {
+			value, code := decode(&codecs.JSON{}, `{"id": 12345678901234567890, "tags": ["a", "b"], "nested": {"a": {}}}`)
+			So(code, ShouldEqual, 0)
+			So(value.(map[string]interface{})["id"], ShouldEqual, json.Number("12345678901234567890"))
+		})
+
+		Convey("Large bodies should be rejected with 413", func() {
+			_, code := decode(&codecs.JSON{}, `{"name": "`+strings.Repeat("a", 200)+`"}`)
+			So(code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("Structure violations should be rejected with 400", func() {
+			for _, body := range []string{
+				`{"a": {"b": {"c": {}}}}`,
+				`{"a": [1, 2, 3, 4]}`,
+				`{"a": 1, "b": 2, "c": 3, "d": 4}`,
+				`{"a": 1, "a": 2}`,
+				`{"a": [[[1]]]}`,
+			} {
+				_, code := decode(&codecs.JSON{}, body)
+				So(code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+
+		Convey("Same keys in different objects should be accepted", func() {
+			_, code := decode(&codecs.JSON{}, `{"a": {"id": 1}, "b": {"id": 2}}`)
+			So(code, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Decoded values of the other codecs should be checked", t, func() {
+		_, code := decode(&codecs.YAML{}, "a:\n  b:\n    c:\n      d: 1\n")
+		So(code, ShouldEqual, http.StatusBadRequest)
+		_, code = decode(&codecs.YAML{}, "a: [1, 2]\n")
+		So(code, ShouldEqual, 0)
+	})
+
+	Convey("Given route limits", t, func() {
+		SetBodyLimits("/logs", BodyLimits{MaxBodySize: 8, MaxResponseSize: 8})
+		defer func() { routeLimits = nil }()
+
+		Convey("Requests over the limit of the route should be rejected", func() {
+			r, _ := http.NewRequest(http.MethodPost, "/logs", bytes.NewBufferString(`{"message": "too long"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.RemoteAddr = "127.0.0.1:1234"
+			_, err := parseRequest(r)
+			So(err.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("Responses over the limit of the route should be replaced", func() {
+			r, _ := http.NewRequest(http.MethodGet, "/logs", nil)
+			w := httptest.NewRecorder()
+			buildResponse(w, r, messages.Message{Body: map[string]interface{}{"message": "too long"}}, nil)
+			So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+
+		Convey("Other paths should use the default limits", func() {
+			So(bodyLimitsOf("/posts"), ShouldResemble, DefaultBodyLimits)
+		})
+	})
+}
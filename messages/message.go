@@ -8,17 +8,21 @@ import (
 )
 
 type Message struct {
-	Rid           int                    `json:"rid,omitempty"`
-	IP            string                 `json:"ip,omitempty"`
-	Res           string                 `json:"res,omitempty"`
-	Command       string                 `json:"method,omitempty"`
-	Headers       map[string][]string    `json:"headers,omitempty"`
-	Parameters    map[string][]string    `json:"parameters,omitempty"`
-	MultipartForm *multipart.Form        `json:"multipart,omitempty"`
-	Body          map[string]interface{} `json:"body,omitempty"`
-	RawBody       []byte                 `json:"rawbody,omitempty"` // used for files
+	Rid           int                      `json:"rid,omitempty"`
+	IP            string                   `json:"ip,omitempty"`
+	IPChain       []string                 `json:"ipchain,omitempty"` // client first, closest peer last
+	Res           string                   `json:"res,omitempty"`
+	Command       string                   `json:"method,omitempty"`
+	Headers       map[string][]string      `json:"headers,omitempty"`
+	Parameters    map[string][]string      `json:"parameters,omitempty"`
+	MultipartForm *multipart.Form          `json:"multipart,omitempty"`
+	Body          map[string]interface{}   `json:"body,omitempty"`
+	Items         []map[string]interface{} `json:"items,omitempty"`   // used for the bulk requests with an array body
+	RawBody       []byte                   `json:"rawbody,omitempty"` // used for files
+	RawBodyReader io.ReadCloser            `json:"-"`                 // used for streaming files. ranges are served if it implements io.Seeker
+	Uploads       []Upload                 `json:"-"`                 // file parts of the multipart requests. stored when the request is executed
 	ReqBodyRaw    io.ReadCloser
-	Status        int                    `json:"status,omitempty"` // used only in responses
+	Status        int                      `json:"status,omitempty"` // used only in responses
 }
 
 func (m Message) GetParameter(key string) (value string, contains bool) {
@@ -67,6 +71,15 @@ func (m Message) GetHeader(key string) (value string, contains bool) {
 	return
 }
 
+// file part of a multipart request kept in a temporary file until the request is executed
+type Upload struct {
+	Field       string
+	Filename    string
+	ContentType string
+	Size        int64
+	Path        string
+}
+
 type RequestWrapper struct {
 	Message  Message
 	Listener chan Message
@@ -79,5 +92,5 @@ type RequestError struct {
 }
 
 func (m *Message) IsEmpty() bool {
-	return m.Status == 0 && len(m.Res) == 0 && len(m.Command) == 0 && m.Headers == nil && m.Parameters == nil && m.MultipartForm == nil && m.Body == nil && len(m.RawBody) == 0 && m.ReqBodyRaw == nil
+	return m.Status == 0 && len(m.Res) == 0 && len(m.Command) == 0 && m.Headers == nil && m.Parameters == nil && m.MultipartForm == nil && m.Body == nil && m.Items == nil && len(m.RawBody) == 0 && m.RawBodyReader == nil && m.ReqBodyRaw == nil && m.Uploads == nil && m.IPChain == nil
 }
@@ -4,6 +4,7 @@ const (
 	Get     = "get"
 	Post    = "post"
 	Put     = "put"
+	Patch   = "patch"
 	Delete  = "delete"
 	Options = "options"
 	Any     = "*"
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"errors"
+	"net/http"
+	"mime/multipart"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/codecs"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// total size limit of the non-file fields of a multipart request
+var MultipartMaxMemory int64 = 10 << 20
+
+// size limit of each file in a multipart request. 0 means unlimited
+var MultipartMaxFileSize int64 = 0
+
+var errFileTooLarge = errors.New("File exceeds the size limit.")
+
+/**
+ * Parses the multipart/form-data body. Form fields are added into the body and
+ * the multipart form of the request. File parts are streamed into temporary files
+ * and stored when the request is executed, so the files of the requests rejected
+ * by the interceptors are never stored. Temporary files are removed on errors.
+ */
+func parseMultipart(body io.Reader, boundary string, request *messages.Message) (err *utils.Error) {
+
+	form := &multipart.Form{
+		Value: make(map[string][]string),
+		File:  make(map[string][]*multipart.FileHeader),
+	}
+	uploads := make([]messages.Upload, 0)
+	remainingMemory := MultipartMaxMemory
+	defer func() {
+		if err != nil {
+			removeUploads(uploads)
+		}
+	}()
+
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, partErr := reader.NextPart()
+		if partErr == io.EOF {
+			break
+		}
+		if partErr != nil && isBodyTooLarge(partErr) {
+			err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Request body is too large."}
+			return
+		}
+		if partErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parsing multipart body failed. Reason: " + partErr.Error()}
+			return
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		// form field
+		if part.FileName() == "" {
+			value, readErr := io.ReadAll(io.LimitReader(part, remainingMemory+1))
+			part.Close()
+			if readErr != nil && isBodyTooLarge(readErr) {
+				err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Request body is too large."}
+				return
+			}
+			if readErr != nil {
+				err = &utils.Error{Code: http.StatusBadRequest, Message: "Reading multipart field failed. Reason: " + readErr.Error()}
+				return
+			}
+			remainingMemory -= int64(len(value))
+			if remainingMemory < 0 {
+				err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Multipart fields exceed the memory limit."}
+				return
+			}
+			form.Value[name] = append(form.Value[name], string(value))
+			continue
+		}
+
+		// file
+		upload, spoolErr := spoolPart(part)
+		part.Close()
+		if spoolErr != nil {
+			err = spoolErr
+			return
+		}
+		upload.Field = name
+		uploads = append(uploads, upload)
+
+		header := &multipart.FileHeader{Filename: upload.Filename, Header: part.Header, Size: upload.Size}
+		form.File[name] = append(form.File[name], header)
+	}
+
+	request.MultipartForm = form
+	request.Body = codecs.FormValues(form.Value)
+	if len(uploads) > 0 {
+		request.Uploads = uploads
+	}
+	return
+}
+
+// writes the file part into a temporary file
+func spoolPart(part *multipart.Part) (upload messages.Upload, err *utils.Error) {
+
+	file, createErr := os.CreateTemp("", "upload-")
+	if createErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Creating temporary file failed. Reason: " + createErr.Error()}
+		return
+	}
+	defer file.Close()
+
+	reader := &limitedPartReader{part: part, limit: MultipartMaxFileSize}
+	_, copyErr := io.Copy(file, reader)
+	if reader.exceeded {
+		err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: errFileTooLarge.Error()}
+	} else if copyErr != nil && isBodyTooLarge(copyErr) {
+		err = &utils.Error{Code: http.StatusRequestEntityTooLarge, Message: "Request body is too large."}
+	} else if copyErr != nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Reading multipart file failed. Reason: " + copyErr.Error()}
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+
+	upload = messages.Upload{
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Size:        reader.size,
+		Path:        file.Name(),
+	}
+	return
+}
+
+func removeUploads(uploads []messages.Upload) {
+	for _, upload := range uploads {
+		os.Remove(upload.Path)
+	}
+}
+
+/**
+ * Stores the uploaded files of the request in the file storage (or the data provider
+ * if no file storage is set) and adds their metadata into the body under the field
+ * names. Files stored before a failure are deleted.
+ *
+ * Ex: {"title": "holiday", "photo": {"_id": "...", "filename": "beach.jpg", "contentType": "image/jpeg", "size": 1024}}
+ */
+func storeUploads(request *messages.Message) (stored []string, err *utils.Error) {
+
+	files := make(map[string][]interface{})
+	for _, upload := range request.Uploads {
+		var metadata map[string]interface{}
+		if metadata, err = storeUpload(upload); err != nil {
+			deleteStoredFiles(stored)
+			return nil, err
+		}
+		stored = append(stored, fmt.Sprint(metadata[dataprovider.IdField]))
+		files[upload.Field] = append(files[upload.Field], metadata)
+	}
+
+	if request.Body == nil {
+		request.Body = make(map[string]interface{})
+	}
+	for name, metadata := range files {
+		if len(metadata) == 1 {
+			request.Body[name] = metadata[0]
+		} else {
+			request.Body[name] = metadata
+		}
+	}
+	return
+}
+
+func storeUpload(upload messages.Upload) (metadata map[string]interface{}, err *utils.Error) {
+
+	file, openErr := os.Open(upload.Path)
+	if openErr != nil {
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Opening uploaded file failed. Reason: " + openErr.Error()}
+		return
+	}
+	defer file.Close()
+	if metadata, err = storeFile(upload.Filename, upload.ContentType, file); err != nil {
+		return
+	}
+
+	metadata["filename"] = upload.Filename
+	if _, hasContentType := metadata["contentType"]; !hasContentType {
+		metadata["contentType"] = upload.ContentType
+	}
+	metadata["size"] = upload.Size
+	return
+}
+
+// deletes the files stored for a request that failed afterwards
+func deleteStoredFiles(ids []string) {
+	for _, id := range ids {
+		var err *utils.Error
+		if FileStorage != nil {
+			err = FileStorage.Delete(id)
+		} else if deleter, isDeleter := DataProvider.(dataprovider.FileDeleter); isDeleter {
+			err = deleter.DeleteFile(id)
+		} else {
+			log.Warning("File '" + id + "' of the failed request can't be deleted, the data provider doesn't delete files.")
+			continue
+		}
+		if err != nil {
+			log.Error("Deleting file '" + id + "' of the failed request failed. Reason: " + err.Message)
+		}
+	}
+}
+
+// counts the bytes read from the part and fails when the size limit is exceeded
+type limitedPartReader struct {
+	part     *multipart.Part
+	limit    int64
+	size     int64
+	exceeded bool
+}
+
+func (r *limitedPartReader) Read(p []byte) (n int, err error) {
+	n, err = r.part.Read(p)
+	r.size += int64(n)
+	if r.limit > 0 && r.size > r.limit {
+		r.exceeded = true
+		return n, errFileTooLarge
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// page size of the queries reading all the matching objects
+var QueryPageSize = 1000
+
+/**
+ * Reads the objects matching the parameters page by page with the 'skip' and 'limit'
+ * parameters. If max is positive, reading stops after max objects and exceeded is
+ * set when there are more.
+ */
+func queryAll(db dataprovider.Provider, class string, parameters map[string][]string, max int) (objects []map[string]interface{}, exceeded bool, err *utils.Error) {
+	return dataprovider.QueryAll(db, class, parameters, QueryPageSize, max)
+}
+
+// reads the pages with the query function. used for the queries executed as requests as well
+func queryPages(query func(parameters map[string][]string) (response map[string]interface{}, err *utils.Error), parameters map[string][]string, max int) (objects []map[string]interface{}, exceeded bool, err *utils.Error) {
+	return dataprovider.QueryPages(query, parameters, QueryPageSize, max)
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/dataprovider"
+)
+
+type Relation struct {
+	// related collection
+	Collection string
+	// field of the object keeping the id of the related object. set for the pointer relations
+	LocalField string
+	// field of the related objects keeping the id of the object. set for the reverse relations
+	ForeignField string
+}
+
+var relations = make(map[string]map[string]Relation)
+
+// maximum number of the levels in an include path. ex: 'comments.author' has 2 levels
+var IncludeMaxDepth = 2
+
+/**
+ * Adds a relation expanded into a single object by the include parameter.
+ *
+ * Ex: core.AddPointerRelation("posts", "author", "authorId", "users")
+ *     GET /posts/1?include=author => {"_id": "1", "authorId": "5", "author": {"_id": "5", ...}}
+ */
+func AddPointerRelation(collection, name, field, target string) {
+	addRelation(collection, name, Relation{Collection: target, LocalField: field})
+}
+
+/**
+ * Adds a relation expanded into the list of the objects referencing the object.
+ *
+ * Ex: core.AddReverseRelation("posts", "comments", "comments", "postId")
+ *     GET /posts?include=comments.author => {"results": [{"_id": "1", "comments": [{"postId": "1", "author": {...}}]}]}
+ */
+func AddReverseRelation(collection, name, target, foreignField string) {
+	addRelation(collection, name, Relation{Collection: target, ForeignField: foreignField})
+}
+
+func addRelation(collection, name string, relation Relation) {
+	if relations[collection] == nil {
+		relations[collection] = make(map[string]Relation)
+	}
+	relations[collection][name] = relation
+}
+
+/**
+ * Expands the relations in the include parameter of the GET requests. Related objects
+ * of all the objects in the response are fetched with a single query for each
+ * relation, read page by page. Requests are executed with the headers and the provider
+ * of the original request through the interceptors, so the access rules of the related
+ * collections are applied and the objects written in its transaction are included.
+ */
+func expandIncludes(request messages.Message, response *messages.Message, db dataprovider.Provider) (err *utils.Error) {
+
+	include, hasInclude := request.GetParameter("include")
+	if !hasInclude || include == "" || response.Body == nil {
+		return
+	}
+
+	// nested includes are grouped by the first level. ex: 'comments.author,author' => {comments: [author], author: []}
+	includes := make(map[string][]string)
+	for _, path := range strings.Split(include, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		levels := strings.SplitN(path, ".", 2)
+		if IncludeMaxDepth > 0 && strings.Count(path, ".")+1 > IncludeMaxDepth {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Include path '" + path + "' is nested too deeply."}
+			return
+		}
+		if len(levels) == 2 {
+			includes[levels[0]] = append(includes[levels[0]], levels[1])
+		} else if _, exists := includes[levels[0]]; !exists {
+			includes[levels[0]] = []string{}
+		}
+	}
+
+	collection := strings.Split(request.Res, "/")[1]
+	objects := objectsOf(response.Body, strings.Count(request.Res, "/") == 2)
+
+	for name, nested := range includes {
+		relation, exists := relations[collection][name]
+		if !exists {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Relation '" + name + "' doesn't exist in '" + collection + "'."}
+			return
+		}
+		if err = expandRelation(request, name, relation, nested, objects, db); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func expandRelation(request messages.Message, name string, relation Relation, nested []string, objects []map[string]interface{}, db dataprovider.Provider) (err *utils.Error) {
+
+	// values to look up are collected from all the objects
+	keyField := relation.LocalField
+	lookupField := dataprovider.IdField
+	if relation.ForeignField != "" {
+		keyField = dataprovider.IdField
+		lookupField = relation.ForeignField
+	}
+
+	seen := make(map[string]bool)
+	values := make([]interface{}, 0)
+	for _, object := range objects {
+		if value, exists := object[keyField]; exists && value != nil && !seen[fmt.Sprint(value)] {
+			seen[fmt.Sprint(value)] = true
+			values = append(values, value)
+		}
+	}
+
+	related := make([]map[string]interface{}, 0)
+	if len(values) > 0 {
+		where, _ := json.Marshal(map[string]interface{}{lookupField: map[string]interface{}{"$in": values}})
+		subRequest := messages.Message{
+			IP:         request.IP,
+			IPChain:    request.IPChain,
+			Res:        "/" + relation.Collection,
+			Command:    methods.Get,
+			Headers:    request.Headers,
+			Parameters: map[string][]string{"where": {string(where)}},
+		}
+		if len(nested) > 0 {
+			subRequest.Parameters["include"] = []string{strings.Join(nested, ",")}
+		}
+
+		// all the pages are read, related objects of the page may be more than the page size of the collection
+		related, _, err = queryPages(func(parameters map[string][]string) (body map[string]interface{}, err *utils.Error) {
+			subRequest.Parameters = parameters
+			subResponse, _, err := handleRequest(subRequest, requestscope.Init(), db)
+			return subResponse.Body, err
+		}, subRequest.Parameters, 0)
+		if err != nil {
+			return
+		}
+	}
+
+	// related objects are grouped by the looked up field
+	grouped := make(map[string][]interface{})
+	for _, object := range related {
+		key := fmt.Sprint(object[lookupField])
+		grouped[key] = append(grouped[key], object)
+	}
+
+	for _, object := range objects {
+		matches := grouped[fmt.Sprint(object[keyField])]
+		if relation.ForeignField != "" {
+			if matches == nil {
+				matches = make([]interface{}, 0)
+			}
+			object[name] = matches
+		} else if len(matches) > 0 && object[keyField] != nil {
+			object[name] = matches[0]
+		} else {
+			object[name] = nil
+		}
+	}
+	return
+}
+
+// returns the objects in the body. body is the object itself for the models
+func objectsOf(body map[string]interface{}, isModel bool) (objects []map[string]interface{}) {
+
+	objects = make([]map[string]interface{}, 0)
+	if body == nil {
+		return
+	}
+	if isModel {
+		return append(objects, body)
+	}
+
+	return dataprovider.ObjectsOf(body)
+}
+
+func withoutParameter(parameters map[string][]string, key string) map[string][]string {
+	if _, exists := parameters[key]; !exists {
+		return parameters
+	}
+	copied := make(map[string][]string, len(parameters))
+	for name, values := range parameters {
+		if name != key {
+			copied[name] = values
+		}
+	}
+	return copied
+}
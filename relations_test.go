@@ -0,0 +1,115 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type countingProvider struct {
+	*memoryProvider
+	queries int
+	gets    int
+}
+
+func (p *countingProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	p.gets++
+	return p.memoryProvider.Get(collection, id)
+}
+
+func (p *countingProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	p.queries++
+	return p.memoryProvider.Query(collection, parameters)
+}
+
+func TestRelations(t *testing.T) {
+
+	Convey("Given posts with authors and comments", t, func() {
+		provider := &countingProvider{memoryProvider: newMemoryProvider()}
+		provider.Create("users", map[string]interface{}{"name": "alice"})
+		provider.Create("users", map[string]interface{}{"name": "bob"})
+		provider.Create("posts", map[string]interface{}{"authorId": "1"})
+		provider.Create("posts", map[string]interface{}{"authorId": "2"})
+		provider.Create("comments", map[string]interface{}{"postId": "3", "authorId": "2"})
+		provider.Create("comments", map[string]interface{}{"postId": "3", "authorId": "1"})
+
+		DataProvider = provider
+		AddPointerRelation("posts", "author", "authorId", "users")
+		AddReverseRelation("posts", "comments", "comments", "postId")
+		AddPointerRelation("comments", "author", "authorId", "users")
+		defer func() {
+			DataProvider = nil
+			relations = make(map[string]map[string]Relation)
+			Interceptors = &interceptors.CoreInterceptorController{}
+		}()
+
+		get := func(res, include string) (messages.Message, *utils.Error) {
+			request := messages.Message{Res: res, Command: methods.Get, Parameters: map[string][]string{"include": {include}}}
+			response, _, err := Execute(request, provider)
+			return response, err
+		}
+
+		Convey("Pointer relations of a model should be expanded", func() {
+			response, err := get("/posts/3", "author")
+			So(err, ShouldBeNil)
+			So(response.Body["author"].(map[string]interface{})["name"], ShouldEqual, "alice")
+		})
+
+		Convey("Relations of a collection should be fetched with one query each", func() {
+			provider.queries = 0
+			response, err := get("/posts", "author,comments.author")
+			So(err, ShouldBeNil)
+			So(provider.queries, ShouldEqual, 4)
+
+			posts := response.Body["results"].([]interface{})
+			first := posts[0].(map[string]interface{})
+			So(first["author"].(map[string]interface{})["name"], ShouldEqual, "alice")
+			comments := first["comments"].([]interface{})
+			So(comments, ShouldHaveLength, 2)
+			So(comments[0].(map[string]interface{})["author"].(map[string]interface{})["name"], ShouldEqual, "bob")
+			So(posts[1].(map[string]interface{})["comments"], ShouldBeEmpty)
+		})
+
+		Convey("Related objects more than a page should all be expanded", func() {
+			QueryPageSize = 1
+			defer func() { QueryPageSize = 1000 }()
+
+			response, err := get("/posts/3", "comments")
+			So(err, ShouldBeNil)
+			So(response.Body["comments"], ShouldHaveLength, 2)
+		})
+
+		Convey("Related objects should be read with the provider of the request", func() {
+			DataProvider = newMemoryProvider()
+			response, err := get("/posts/3", "author,comments")
+			So(err, ShouldBeNil)
+			So(response.Body["author"].(map[string]interface{})["name"], ShouldEqual, "alice")
+			So(response.Body["comments"], ShouldHaveLength, 2)
+		})
+
+		Convey("Unknown relations and deep paths should be rejected", func() {
+			_, err := get("/posts", "editor")
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+
+			_, err = get("/posts", "comments.author.posts")
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Access rules of the related collections should be applied", func() {
+			Interceptors = &interceptors.CoreInterceptorController{}
+			Interceptors.Add("/users", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				err = &utils.Error{Code: http.StatusForbidden, Message: "Forbidden."}
+				return
+			}, nil)
+
+			_, err := get("/posts/3", "author")
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
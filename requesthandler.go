@@ -3,7 +3,9 @@ package core
 import (
 	"strings"
 	"net/http"
+	"github.com/rihtim/core/cache"
 	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/search"
 	"github.com/rihtim/core/methods"
 	"github.com/rihtim/core/messages"
 	"github.com/rihtim/core/requestscope"
@@ -22,14 +24,94 @@ var AllowedMethodsOfResourceTypes = map[string]map[string]bool{
 	},
 }
 
+var hiddenCollections = make(map[string]bool)
+
+/**
+ * Hides the collection from the requests, so it's used through the data provider only.
+ * Collections of the webhooks are hidden while Webhooks is set, since the subscriptions
+ * have the secrets. Revisions of the versioned collections are hidden too, they are
+ * read through the _versions command of the objects.
+ *
+ * Ex: core.HideCollection("auditLogs")
+ */
+func HideCollection(collection string) {
+	hiddenCollections[collection] = true
+}
+
+func isHidden(collection string) bool {
+	if hiddenCollections[collection] {
+		return true
+	}
+	if strings.HasSuffix(collection, VersionsCollectionSuffix) && versionedCollections[strings.TrimSuffix(collection, VersionsCollectionSuffix)] {
+		return true
+	}
+	return Webhooks != nil && (collection == Webhooks.SubscriptionsCollection ||
+		collection == Webhooks.DeliveriesCollection || collection == Webhooks.DeadLettersCollection)
+}
+
+// rejects the requests of the hidden collections as if they don't exist
+func checkHidden(res string) (err *utils.Error) {
+	if parts := strings.Split(res, "/"); len(parts) > 1 && isHidden(parts[1]) {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Collection doesn't exist."}
+	}
+	return
+}
+
+// cached responses of the collections are invalidated when the collections are mutated
+var ResponseCache *cache.Cache
+
+// objects created, updated and deleted by the requests are indexed for the 'q' parameter
+var SearchIndex search.Index
+
 func Execute(request messages.Message, db dataprovider.Provider) (response messages.Message, updatedRequestscope requestscope.RequestScope, err *utils.Error) {
+	var publish func()
+	if response, publish, err = execute(request, db); publish != nil {
+		publish()
+	}
+	return
+}
+
+// executes the request. returned function publishes the mutation of the request, it's called after the commit
+func execute(request messages.Message, db dataprovider.Provider) (response messages.Message, publish func(), err *utils.Error) {
+
+	if _, _, isFileResource := fileResource(request.Res); isFileResource {
+		response, err = handleFileRequest(request, db)
+		return
+	}
+
+	if err = checkHidden(request.Res); err != nil {
+		return
+	}
+
+	if isAggregation(request.Res) {
+		response, err = handleAggregate(request, db)
+		return
+	}
+
+	// commands on the objects have their own methods. ex: POST /posts/1/_restore
+	if command, isCommand := objectCommandOf(request.Res); isCommand {
+		if !strings.EqualFold(request.Command, command.Method) {
+			err = &utils.Error{Code: http.StatusMethodNotAllowed, Message: "Method not allowed on the resource type."}
+			return
+		}
+		// commands of the deleted objects fail as the requests of the objects, except the restores
+		if !strings.HasSuffix(request.Res, "/"+restoreCommand) {
+			if _, err = excludeDeleted(objectRequestOf(request, command), db); err != nil {
+				return
+			}
+		}
+		if response, err = command.Handler(request, db); err == nil && !strings.EqualFold(request.Command, methods.Get) {
+			publish = onMutation(objectRequestOf(request, command), response, db)
+		}
+		return
+	}
 
 	// check if the method is allowed on the resource type
 	var resourceType string
 	resPartCount := len(strings.Split(request.Res, "/"))
-	if resPartCount == 2 {
+	if resPartCount == 2 || resPartCount == 4 {
 		resourceType = "collection"
-	} else if resPartCount == 3 {
+	} else if resPartCount == 3 || resPartCount == 5 {
 		resourceType = "model"
 	} else {
 		err = &utils.Error{
@@ -39,39 +121,131 @@ func Execute(request messages.Message, db dataprovider.Provider) (response messa
 		return
 	}
 
+	// bulk methods are allowed on the collections only if they are enabled for the collection
 	allowedMethods := AllowedMethodsOfResourceTypes[resourceType]
-	if isMethodAllowed := allowedMethods[strings.ToLower(request.Command)]; !isMethodAllowed {
+	isBulk := resourceType == "collection" && isBulkAllowed(request.Res, request.Command)
+	if isMethodAllowed := allowedMethods[strings.ToLower(request.Command)]; !isMethodAllowed && !isBulk {
 		err = &utils.Error{
 			Code:    http.StatusMethodNotAllowed,
 			Message: "Method not allowed on the resource type.",
 		}
 		return
 	}
+	if request.Items != nil && !isBulk {
+		err = &utils.Error{
+			Code:    http.StatusMethodNotAllowed,
+			Message: "Bulk requests are not enabled on the resource.",
+		}
+		return
+	}
+
+	// requests of the sub resources are executed on the child collections
+	if resPartCount > 3 {
+		if request, err = resolveSubResource(request, db); err != nil {
+			return
+		}
+	}
+
+	// soft deleted objects are excluded unless they are included explicitly
+	if request, err = excludeDeleted(request, db); err != nil {
+		return
+	}
 
 	// execute request
-	if strings.EqualFold(request.Command, methods.Post) {
+	if strings.EqualFold(request.Command, methods.Post) && request.Items != nil {
+		response, err = handleBulkCreate(request, db)
+	} else if strings.EqualFold(request.Command, methods.Post) {
 		response, err = handlePost(request, db)
 	} else if strings.EqualFold(request.Command, methods.Get) {
-		response, err = handleGet(request, db)
+		// include parameter is handled by core, not passed to the data provider
+		getRequest := request
+		getRequest.Parameters = withoutParameter(request.Parameters, "include")
+		if _, hasQuery := getRequest.Parameters["q"]; hasQuery && resourceType == "collection" {
+			response, err = handleSearch(getRequest, db)
+		} else if _, hasVersion := getRequest.Parameters["version"]; hasVersion && resourceType == "model" {
+			response, err = handleGetVersion(getRequest, db)
+		} else {
+			response, err = handleGet(getRequest, db)
+		}
+		if err == nil {
+			err = expandIncludes(request, &response, db)
+		}
 	} else if strings.EqualFold(request.Command, methods.Put) {
 		response, err = handlePut(request, db)
+	} else if strings.EqualFold(request.Command, methods.Patch) {
+		response, err = handleBulkUpdate(request, db)
+	} else if strings.EqualFold(request.Command, methods.Delete) && resourceType == "collection" {
+		response, err = handleBulkDelete(request, db)
 	} else if strings.EqualFold(request.Command, methods.Delete) {
 		response, err = handleDelete(request, db)
 	}
 
+	if err == nil && !strings.EqualFold(request.Command, methods.Get) {
+		publish = onMutation(request, response, db)
+	}
 	return
 }
 
-var handlePost = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+type objectCommand struct {
+	Method  string
+	Handler func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error)
+}
 
-	class := strings.Split(request.Res, "/")[1]
+// commands on the objects. ex: POST /posts/1/_restore
+var objectCommands = map[string]objectCommand{
+	restoreCommand:  {methods.Post, handleRestore},
+	versionsCommand: {methods.Get, handleVersions},
+	revertCommand:   {methods.Post, handleRevert},
+}
 
-	// TODO: don't hard code the 'files' path
-	if !strings.EqualFold(class, "files") {
-		response.Body, err = db.Create(class, request.Body)
-	} else {
-		response.Body, err = db.CreateFile(request.ReqBodyRaw)
+func objectCommandOf(res string) (command objectCommand, isCommand bool) {
+	parts := strings.Split(res, "/")
+	if len(parts) != 4 || !strings.HasPrefix(parts[3], "_") {
+		return
 	}
+	command, isCommand = objectCommands[parts[3]]
+	return
+}
+
+// returns the request of the object of the command. commands changing the object are updates of the object
+func objectRequestOf(request messages.Message, command objectCommand) (objectRequest messages.Message) {
+	objectRequest = request
+	objectRequest.Res = request.Res[:strings.LastIndex(request.Res, "/")]
+	objectRequest.Command = methods.Put
+	if strings.EqualFold(command.Method, methods.Get) {
+		objectRequest.Command = methods.Get
+	}
+	return
+}
+
+// called after the collection of the request is mutated successfully. returned function invalidates the cache,
+// syncs the search index and publishes the mutation to the webhooks and the events, it's called after the
+// transaction of the request is committed
+func onMutation(request, response messages.Message, db dataprovider.Provider) (publish func()) {
+	if ResponseCache == nil && SearchIndex == nil && Webhooks == nil && Events == nil {
+		return
+	}
+
+	// objects are read once with the provider of the request, they may not be visible to the others before the commit
+	var ids []string
+	var objects []map[string]interface{}
+	if SearchIndex != nil || Webhooks != nil || Events != nil {
+		ids, objects = mutatedObjects(request, response, db)
+	}
+	return func() {
+		if ResponseCache != nil {
+			ResponseCache.Invalidate(cache.Collection(request.Res))
+		}
+		syncSearchIndex(request, ids, objects)
+		publishWebhooks(request, ids, objects)
+		publishObjectEvents(request, ids, objects)
+	}
+}
+
+var handlePost = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	response.Body, err = db.Create(class, request.Body)
 
 	if err == nil {
 		response.Status = http.StatusCreated
@@ -83,17 +257,12 @@ var handleGet = func(request messages.Message, db dataprovider.Provider) (respon
 
 	class := strings.Split(request.Res, "/")[1]
 
-	isFileClass := strings.EqualFold(class, "files")
 	isModelActor := len(strings.Split(request.Res, "/")) == 3
 	isCollectionActor := len(strings.Split(request.Res, "/")) == 2
 
 	if isModelActor {
 		id := request.Res[strings.LastIndex(request.Res, "/")+1:]
-		if isFileClass {
-			response.RawBody, err = db.GetFile(id) // get file by id
-		} else {
-			response.Body, err = db.Get(class, id) // get object by id
-		}
+		response.Body, err = db.Get(class, id) // get object by id
 	} else if isCollectionActor {
 		response.Body, err = db.Query(class, request.Parameters) // query collection
 	}
@@ -108,7 +277,24 @@ var handlePut = func(request messages.Message, db dataprovider.Provider) (respon
 
 	class := strings.Split(request.Res, "/")[1]
 	id := request.Res[strings.LastIndex(request.Res, "/")+1:]
-	response.Body, err = db.Update(class, id, request.Body)
+	if !versionedCollections[class] {
+		response.Body, err = db.Update(class, id, request.Body)
+		return
+	}
+
+	// previous state of the object is stored before the update, so applied updates always have their revisions
+	previous, err := db.Get(class, id)
+	if err != nil {
+		return
+	}
+	revisionID, err := saveRevision(class, id, previous, db)
+	if err != nil {
+		return
+	}
+	data := withField(request.Body, VersionField, currentVersion(previous)+1)
+	if response.Body, err = db.Update(class, id, data); err != nil {
+		removeRevision(class, revisionID, db)
+	}
 	return
 }
 
@@ -118,7 +304,11 @@ var handleDelete = func(request messages.Message, db dataprovider.Provider) (res
 		// delete object
 		class := strings.Split(request.Res, "/")[1]
 		id := request.Res[strings.LastIndex(request.Res, "/")+1:]
-		response.Body, err = db.Delete(class, id)
+		if isSoftDeleted(class) {
+			_, err = db.Update(class, id, deletionMark())
+		} else {
+			response.Body, err = db.Delete(class, id)
+		}
 		if err == nil {
 			response.Status = http.StatusNoContent
 		}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"sync"
+	"time"
+	"net/http"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/functions"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+)
+
+// method of the requests of the scheduled functions. http requests with this method are rejected
+const ScheduledMethod = "schedule"
+
+// set to true in the request scope of the scheduled runs
+const ScheduledScopeKey = "isScheduled"
+
+// status of a scheduled function. last run fields are empty until the first run completes
+type ScheduleStatus struct {
+	Path         string        `json:"path"`
+	Expression   string        `json:"expression"`
+	Running      bool          `json:"running"`
+	NextRun      time.Time     `json:"nextRun"`
+	LastRun      time.Time     `json:"lastRun"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastStatus   string        `json:"lastStatus,omitempty"`
+	LastError    string        `json:"lastError,omitempty"`
+	Runs         int           `json:"runs"`
+	Failures     int           `json:"failures"`
+	Skipped      int           `json:"skipped"`
+}
+
+type scheduledFunction struct {
+	status ScheduleStatus
+	cron   *utils.CronSchedule
+}
+
+var schedulesLock sync.Mutex
+var scheduledFunctions []*scheduledFunction
+
+/**
+ * Runs the function at the times of the cron expression. Runs are executed like the
+ * requests with the ScheduledMethod, so the interceptors of the path and the method
+ * are executed as well. A run is skipped if the previous run is still running.
+ *
+ * Ex: core.ScheduleFunction("/tasks/cleanup", "0 3 * * *", cleanup, nil)
+ *     core.Interceptors.Add("/tasks/cleanup", core.ScheduledMethod, interceptors.FINAL, notify, nil)
+ *     stop := core.StartScheduler(time.Minute)
+ */
+func ScheduleFunction(path, expression string, handler functions.FunctionHandler, extras interface{}) (err *utils.Error) {
+
+	cron, parseErr := utils.ParseCron(expression)
+	if parseErr != nil {
+		return &utils.Error{Code: http.StatusBadRequest, Message: parseErr.Error()}
+	}
+
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+	for _, function := range scheduledFunctions {
+		if function.status.Path == path {
+			return &utils.Error{Code: http.StatusConflict, Message: "Function '" + path + "' is already scheduled."}
+		}
+	}
+
+	Functions.Add(path, ScheduledMethod, handler, extras)
+	scheduledFunctions = append(scheduledFunctions, &scheduledFunction{
+		status: ScheduleStatus{Path: path, Expression: expression, NextRun: cron.Next(Clock())},
+		cron:   cron,
+	})
+	return
+}
+
+/**
+ * Checks the scheduled functions periodically and runs the due ones in the background.
+ * Interval should be a minute or less since the schedules have a precision of minutes.
+ * Returns a function stopping the scheduler.
+ *
+ * Ex: stop := core.StartScheduler(time.Minute)
+ */
+func StartScheduler(interval time.Duration) (stop func()) {
+
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				runDueFunctions()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	// stopping more than once is a no op
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func runDueFunctions() {
+
+	current := Clock()
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	for _, function := range scheduledFunctions {
+		if function.status.NextRun.IsZero() || function.status.NextRun.After(current) {
+			continue
+		}
+		function.status.NextRun = function.cron.Next(current)
+		if function.status.Running {
+			function.status.Skipped++
+			log.Warning("Scheduled function '" + function.status.Path + "' is skipped, the previous run is still running.")
+			continue
+		}
+		function.status.Running = true
+		go runScheduled(function)
+	}
+}
+
+// runs the scheduled function immediately unless it's running
+func RunScheduledFunction(path string) (response messages.Message, err *utils.Error) {
+
+	schedulesLock.Lock()
+	var function *scheduledFunction
+	for _, scheduled := range scheduledFunctions {
+		if scheduled.status.Path == path {
+			function = scheduled
+		}
+	}
+	if function == nil {
+		schedulesLock.Unlock()
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Function '" + path + "' is not scheduled."}
+		return
+	}
+	if function.status.Running {
+		schedulesLock.Unlock()
+		err = &utils.Error{Code: http.StatusConflict, Message: "Function '" + path + "' is already running."}
+		return
+	}
+	function.status.Running = true
+	schedulesLock.Unlock()
+
+	return runScheduled(function)
+}
+
+// executes the function as a request and records the result. function must be marked as running
+func runScheduled(function *scheduledFunction) (response messages.Message, err *utils.Error) {
+
+	requestScope := requestscope.Init()
+	requestScope.Set(ScheduledScopeKey, true)
+	request := messages.Message{
+		Res:        function.status.Path,
+		Command:    ScheduledMethod,
+		Headers:    http.Header{},
+		Parameters: map[string][]string{},
+	}
+
+	start := Clock()
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = &utils.Error{Code: http.StatusInternalServerError, Message: "Scheduled function panicked."}
+				log.Error("Scheduled function '"+function.status.Path+"' panicked: ", recovered)
+			}
+		}()
+		response, _, err = HandleRequest(request, requestScope)
+	}()
+
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+	function.status.Running = false
+	function.status.LastRun = start
+	function.status.LastDuration = Clock().Sub(start)
+	function.status.Runs++
+	if err != nil {
+		function.status.LastStatus = "failed"
+		function.status.LastError = err.Message
+		function.status.Failures++
+		log.Error("Scheduled function '" + function.status.Path + "' failed. Reason: " + err.Message)
+	} else {
+		function.status.LastStatus = "succeeded"
+		function.status.LastError = ""
+	}
+	return
+}
+
+// returns the statuses of the scheduled functions in the order they are scheduled
+func ScheduledFunctions() (statuses []ScheduleStatus) {
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+	statuses = make([]ScheduleStatus, len(scheduledFunctions))
+	for i, function := range scheduledFunctions {
+		statuses[i] = function.status
+	}
+	return
+}
+
+/**
+ * Function returning the statuses of the scheduled functions.
+ *
+ * Ex: core.Functions.Add("/schedules", methods.Get, core.ScheduleStatuses, nil)
+ */
+func ScheduleStatuses(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+	statuses := ScheduledFunctions()
+	results := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		results[i] = status
+	}
+	resp.Body = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
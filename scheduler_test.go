@@ -0,0 +1,118 @@
+package core
+
+import (
+	"sync"
+	"time"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/functions"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduler(t *testing.T) {
+
+	Convey("Given a scheduled function", t, func() {
+		current := time.Date(2024, time.January, 31, 10, 0, 0, 0, time.UTC)
+		var clock sync.Mutex
+		Clock = func() time.Time { clock.Lock(); defer clock.Unlock(); return current }
+		advance := func(duration time.Duration) { clock.Lock(); defer clock.Unlock(); current = current.Add(duration) }
+		DataProvider = newMemoryProvider()
+		defer func() {
+			Clock = time.Now
+			DataProvider = nil
+			scheduledFunctions = nil
+			Functions = &functions.CoreFunctionController{}
+			Interceptors = &interceptors.CoreInterceptorController{}
+		}()
+
+		release := make(chan bool, 1)
+		finished := make(chan bool, 1)
+		var failure *utils.Error
+		var scheduled interface{}
+		var provider dataprovider.Provider
+		cleanup := func(req messages.Message, rs requestscope.RequestScope, extras interface{}, dp dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			<-release
+			scheduled, provider = rs.Get(ScheduledScopeKey), dp
+			resp.Body = map[string]interface{}{"purged": 3}
+			finished <- true
+			return resp, rs, failure
+		}
+		So(ScheduleFunction("/tasks/cleanup", "0 * * * *", cleanup, nil), ShouldBeNil)
+
+		intercepted := 0
+		Interceptors.Add("/tasks/cleanup", ScheduledMethod, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			intercepted++
+			return
+		}, nil)
+
+		Convey("Functions should be run through the interceptors at the times of the schedule", func() {
+			release <- true
+			response, err := RunScheduledFunction("/tasks/cleanup")
+			So(err, ShouldBeNil)
+			So(response.Body["purged"], ShouldEqual, 3)
+			So(intercepted, ShouldEqual, 1)
+			So(scheduled, ShouldBeTrue)
+			So(provider, ShouldEqual, DataProvider)
+
+			status := ScheduledFunctions()[0]
+			So(status.LastStatus, ShouldEqual, "succeeded")
+			So(status.Runs, ShouldEqual, 1)
+			So(status.NextRun, ShouldEqual, current.Add(time.Hour))
+		})
+
+		Convey("Runs should be skipped while the previous run is running", func() {
+			advance(time.Hour)
+			runDueFunctions()
+			So(ScheduledFunctions()[0].Running, ShouldBeTrue)
+
+			advance(time.Hour)
+			runDueFunctions()
+			_, err := RunScheduledFunction("/tasks/cleanup")
+			So(err.Code, ShouldEqual, http.StatusConflict)
+
+			release <- true
+			<-finished
+			for ScheduledFunctions()[0].Running {
+				time.Sleep(time.Millisecond)
+			}
+			status := ScheduledFunctions()[0]
+			So(status.Runs, ShouldEqual, 1)
+			So(status.Skipped, ShouldEqual, 1)
+			So(status.NextRun, ShouldEqual, Clock().Add(time.Hour))
+		})
+
+		Convey("Failures should be reported in the status", func() {
+			failure = &utils.Error{Code: http.StatusInternalServerError, Message: "Purging failed."}
+			release <- true
+			RunScheduledFunction("/tasks/cleanup")
+
+			response, _, _ := ScheduleStatuses(messages.Message{}, requestscope.Init(), nil, nil)
+			status := response.Body[dataprovider.ResultsField].([]interface{})[0].(ScheduleStatus)
+			So(status.LastStatus, ShouldEqual, "failed")
+			So(status.LastError, ShouldEqual, "Purging failed.")
+			So(status.Failures, ShouldEqual, 1)
+		})
+
+		Convey("Scheduled functions should not be requested over http", func() {
+			_, err := parseRequest(httptest.NewRequest("SCHEDULE", "/tasks/cleanup", nil))
+			So(err.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("Invalid and duplicate schedules should be rejected", func() {
+			So(ScheduleFunction("/tasks/digest", "0 25 * * *", cleanup, nil).Code, ShouldEqual, http.StatusBadRequest)
+			So(ScheduleFunction("/tasks/cleanup", "0 0 * * *", cleanup, nil).Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("Scheduler should be stopped more than once safely", func() {
+			stop := StartScheduler(time.Hour)
+			stop()
+			So(stop, ShouldNotPanic)
+		})
+	})
+}
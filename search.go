@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/search"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+)
+
+/**
+ * Searches the collection with the 'q' parameter. Providers with native search are
+ * used directly. Otherwise the hits of the search index are fetched from the provider
+ * with the other parameters and ordered by the score. The 'skip' and 'limit'
+ * parameters are applied to the ordered results, so the hits are fetched in the order
+ * of the scores only until the requested page is filled.
+ *
+ * GET /posts?q=running shoes&where={"published":true}&limit=10
+ *
+ * Ex: {"results": [{"_id": "3", "title": "Shoes for running", "_score": 1.38}, ...]}
+ */
+var handleSearch = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	class := strings.Split(request.Res, "/")[1]
+	query, _ := request.GetParameter("q")
+	parameters := withoutParameter(request.Parameters, "q")
+
+	if searcher, isSearcher := db.(search.Searcher); isSearcher {
+		response.Body, err = searcher.Search(class, query, parameters)
+		return
+	}
+	if SearchIndex == nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Search is not enabled."}
+		return
+	}
+
+	skip, _, err := request.GetIntParameter("skip")
+	if err != nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'skip' must be a number."}
+		return
+	}
+	limit, hasLimit, err := request.GetIntParameter("limit")
+	if err != nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'limit' must be a number."}
+		return
+	}
+
+	hits, err := SearchIndex.Search(class, query)
+	if err != nil {
+		return
+	}
+
+	// hits are skipped before fetching if they are not filtered by the 'where' parameter
+	if _, hasWhere := parameters["where"]; !hasWhere {
+		if skip > len(hits) {
+			skip = len(hits)
+		}
+		hits, skip = hits[skip:], 0
+	}
+	isFilled := func(count int) bool {
+		return hasLimit && limit >= 0 && count >= skip+limit
+	}
+
+	objects := make([]map[string]interface{}, 0)
+	for start := 0; start < len(hits) && !isFilled(len(objects)); start += QueryPageSize {
+		end := start + QueryPageSize
+		if end > len(hits) {
+			end = len(hits)
+		}
+		var page []map[string]interface{}
+		if page, err = fetchHits(class, hits[start:end], parameters, db); err != nil {
+			return
+		}
+		objects = append(objects, page...)
+	}
+
+	if skip > len(objects) {
+		skip = len(objects)
+	}
+	objects = objects[skip:]
+	if hasLimit && limit >= 0 && limit < len(objects) {
+		objects = objects[:limit]
+	}
+	results := make([]interface{}, len(objects))
+	for i, object := range objects {
+		results[i] = object
+	}
+	response.Body = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
+
+// returns the objects of the hits matching the parameters in the order of the scores. hits are fetched with a single query
+func fetchHits(class string, hits []search.Hit, parameters map[string][]string, db dataprovider.Provider) (objects []map[string]interface{}, err *utils.Error) {
+
+	ids := make([]interface{}, len(hits))
+	scores := make(map[string]float64, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+		scores[hit.ID] = hit.Score
+	}
+
+	where := make(map[string]interface{})
+	if value, hasWhere := parameters["where"]; hasWhere && len(value) > 0 {
+		if decodeErr := json.Unmarshal([]byte(value[0]), &where); decodeErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'where' must be a json object."}
+			return
+		}
+	}
+	hitFilter := map[string]interface{}{"$in": ids}
+	if _, hasIdFilter := where[dataprovider.IdField]; hasIdFilter {
+		where = map[string]interface{}{"$and": []interface{}{where, map[string]interface{}{dataprovider.IdField: hitFilter}}}
+	} else {
+		where[dataprovider.IdField] = hitFilter
+	}
+	encoded, _ := json.Marshal(where)
+
+	queryParameters := make(map[string][]string, len(parameters))
+	for key, values := range parameters {
+		if key != "skip" && key != "limit" {
+			queryParameters[key] = values
+		}
+	}
+	queryParameters["where"] = []string{string(encoded)}
+	queryParameters["limit"] = []string{strconv.Itoa(len(hits))}
+
+	response, err := db.Query(class, queryParameters)
+	if err != nil {
+		return
+	}
+
+	objects = objectsOf(response, false)
+	for _, object := range objects {
+		object["_score"] = scores[idOf(object)]
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i]["_score"].(float64) > objects[j]["_score"].(float64)
+	})
+	return
+}
+
+// updates the search index with the objects mutated by the request
+func syncSearchIndex(request messages.Message, ids []string, objects []map[string]interface{}) {
+
+	if SearchIndex == nil {
+		return
+	}
+
+	// objects are nil if they are deleted or can't be read
+	class := strings.Split(request.Res, "/")[1]
+	for i, id := range ids {
+		if objects[i] == nil {
+			SearchIndex.Remove(class, id)
+			continue
+		}
+		SearchIndex.Index(class, id, objects[i])
+	}
+}
+
+/**
+ * Indexes all the objects of the collection. Index is updated by the requests after
+ * it's set, so the objects existing before need to be indexed once.
+ *
+ * Ex: core.SearchIndex = search.NewMemoryIndex()
+ *     core.RebuildSearchIndex("posts")
+ */
+func RebuildSearchIndex(collection string) (err *utils.Error) {
+
+	if SearchIndex == nil {
+		return &utils.Error{Code: http.StatusBadRequest, Message: "Search is not enabled."}
+	}
+
+	objects, _, err := queryAll(DataProvider, collection, map[string][]string{}, 0)
+	if err != nil {
+		return
+	}
+	for _, object := range objects {
+		if err = SearchIndex.Index(collection, idOf(object), object); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// returns the ids of the objects created, updated or deleted successfully by the request
+func mutatedIds(request, response messages.Message) (ids []string) {
+
+	parts := strings.Split(request.Res, "/")
+	if len(parts) == 3 {
+		return []string{parts[2]}
+	}
+
+	isBulk := request.Items != nil || !strings.EqualFold(request.Command, methods.Post)
+	if !isBulk {
+		if id := idOf(response.Body); id != "" {
+			ids = append(ids, id)
+		}
+		return
+	}
+
+	for _, item := range objectsOf(response.Body, false) {
+		status, _ := item["status"].(int)
+		if id := idOf(item); id != "" && status < http.StatusMultipleChoices {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+func idOf(object map[string]interface{}) string {
+	if object == nil || object[dataprovider.IdField] == nil {
+		return ""
+	}
+	return fmt.Sprint(object[dataprovider.IdField])
+}
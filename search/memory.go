@@ -0,0 +1,203 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"strings"
+	"github.com/rihtim/core/utils"
+)
+
+/**
+ * In memory inverted index ranking the documents with bm25. String fields of the
+ * documents are indexed unless the fields of the collection are set. Index is empty
+ * on start, existing objects can be indexed with core.RebuildSearchIndex.
+ *
+ * Ex: index := search.NewMemoryIndex()
+ *     index.SetFields("posts", "title", "body")
+ *     core.SearchIndex = index
+ */
+type MemoryIndex struct {
+	// term frequency saturation
+	K1 float64
+	// document length normalization
+	B float64
+
+	lock        sync.RWMutex
+	fields      map[string][]string
+	collections map[string]*collectionIndex
+}
+
+type collectionIndex struct {
+	// term => document id => frequency
+	postings map[string]map[string]int
+	// document id => number of the terms
+	lengths map[string]int
+	// document id => distinct terms of the document, so the removes touch only its postings
+	terms       map[string][]string
+	totalLength int
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		K1:          1.2,
+		B:           0.75,
+		fields:      make(map[string][]string),
+		collections: make(map[string]*collectionIndex),
+	}
+}
+
+// sets the indexed fields of the collection. nested fields are separated by dots
+func (index *MemoryIndex) SetFields(collection string, fields ...string) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.fields[collection] = fields
+}
+
+func (index *MemoryIndex) Index(collection, id string, document map[string]interface{}) (err *utils.Error) {
+
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	index.remove(collection, id)
+
+	terms := make([]string, 0)
+	if fields, hasFields := index.fields[collection]; hasFields {
+		for _, field := range fields {
+			terms = append(terms, termsOf(valueOf(document, field))...)
+		}
+	} else {
+		for field, value := range document {
+			// internal fields like _id are not indexed
+			if !strings.HasPrefix(field, "_") {
+				terms = append(terms, termsOf(value)...)
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return
+	}
+
+	entries := index.collections[collection]
+	if entries == nil {
+		entries = &collectionIndex{postings: make(map[string]map[string]int), lengths: make(map[string]int), terms: make(map[string][]string)}
+		index.collections[collection] = entries
+	}
+	distinct := make([]string, 0)
+	for _, term := range terms {
+		if entries.postings[term] == nil {
+			entries.postings[term] = make(map[string]int)
+		}
+		if entries.postings[term][id] == 0 {
+			distinct = append(distinct, term)
+		}
+		entries.postings[term][id]++
+	}
+	entries.lengths[id] = len(terms)
+	entries.terms[id] = distinct
+	entries.totalLength += len(terms)
+	return
+}
+
+func (index *MemoryIndex) Remove(collection, id string) (err *utils.Error) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+	index.remove(collection, id)
+	return
+}
+
+func (index *MemoryIndex) remove(collection, id string) {
+
+	entries := index.collections[collection]
+	if entries == nil {
+		return
+	}
+	length, exists := entries.lengths[id]
+	if !exists {
+		return
+	}
+
+	for _, term := range entries.terms[id] {
+		documents := entries.postings[term]
+		delete(documents, id)
+		if len(documents) == 0 {
+			delete(entries.postings, term)
+		}
+	}
+	delete(entries.lengths, id)
+	delete(entries.terms, id)
+	entries.totalLength -= length
+}
+
+func (index *MemoryIndex) Search(collection, query string) (hits []Hit, err *utils.Error) {
+
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	hits = make([]Hit, 0)
+	entries := index.collections[collection]
+	if entries == nil || len(entries.lengths) == 0 {
+		return
+	}
+
+	documentCount := float64(len(entries.lengths))
+	averageLength := float64(entries.totalLength) / documentCount
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		documents := entries.postings[term]
+		matching := float64(len(documents))
+		idf := math.Log(1 + (documentCount-matching+0.5)/(matching+0.5))
+		for id, frequency := range documents {
+			tf := float64(frequency)
+			normalization := 1 - index.B + index.B*float64(entries.lengths[id])/averageLength
+			scores[id] += idf * tf * (index.K1 + 1) / (tf + index.K1*normalization)
+		}
+	}
+
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return
+}
+
+// returns the terms of the strings in the value
+func termsOf(value interface{}) (terms []string) {
+	switch typed := value.(type) {
+	case string:
+		return Tokenize(typed)
+	case []interface{}:
+		for _, item := range typed {
+			terms = append(terms, termsOf(item)...)
+		}
+	case []string:
+		for _, item := range typed {
+			terms = append(terms, Tokenize(item)...)
+		}
+	}
+	return
+}
+
+func valueOf(document map[string]interface{}, path string) interface{} {
+	var current interface{} = document
+	for _, part := range strings.Split(path, ".") {
+		object, isObject := current.(map[string]interface{})
+		if !isObject {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
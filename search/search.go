@@ -0,0 +1,29 @@
+package search
+
+import (
+	"github.com/rihtim/core/utils"
+)
+
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+/**
+ * Index of the documents of the collections. Core keeps the index in sync with the
+ * objects created, updated and deleted through the rest handlers.
+ */
+type Index interface {
+	Index(collection, id string, document map[string]interface{}) (err *utils.Error)
+	Remove(collection, id string) (err *utils.Error)
+	// returns the documents matching any of the terms of the query ordered by the score
+	Search(collection, query string) (hits []Hit, err *utils.Error)
+}
+
+/**
+ * Optional interface for the providers with native search. Response has the same
+ * format with the queries. Parameters are the query parameters except 'q'.
+ */
+type Searcher interface {
+	Search(collection, query string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error)
+}
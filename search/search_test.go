@@ -0,0 +1,83 @@
+package search
+
+import (
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStem(t *testing.T) {
+
+	Convey("Words should be stemmed with the porter algorithm", t, func() {
+		words := map[string]string{
+			"caresses":       "caress",
+			"ponies":         "poni",
+			"cats":           "cat",
+			"agreed":         "agre",
+			"plastered":      "plaster",
+			"motoring":       "motor",
+			"sing":           "sing",
+			"hopping":        "hop",
+			"filing":         "file",
+			"happy":          "happi",
+			"relational":     "relat",
+			"generalization": "gener",
+			"hopeful":        "hope",
+			"adjustment":     "adjust",
+			"connections":    "connect",
+			"controll":       "control",
+			"running":        "run",
+		}
+		for word, stem := range words {
+			So(Stem(word), ShouldEqual, stem)
+		}
+	})
+
+	Convey("Text should be tokenized without the stop words", t, func() {
+		So(Tokenize("The Running dogs, and 2 CATS!"), ShouldResemble, []string{"run", "dog", "2", "cat"})
+	})
+}
+
+func TestMemoryIndex(t *testing.T) {
+
+	Convey("Given an index of posts", t, func() {
+		index := NewMemoryIndex()
+		index.SetFields("posts", "title", "author.bio")
+		index.Index("posts", "1", map[string]interface{}{"title": "Running shoes for running on trails"})
+		index.Index("posts", "2", map[string]interface{}{"title": "A long guide to cooking pasta with fresh tomatoes and basil"})
+		index.Index("posts", "3", map[string]interface{}{"title": "Shoes", "author": map[string]interface{}{"bio": "runner"}})
+		index.Index("posts", "4", map[string]interface{}{"body": "running is ignored since body is not indexed"})
+
+		Convey("Documents should be ranked by bm25", func() {
+			hits, err := index.Search("posts", "runs")
+			So(err, ShouldBeNil)
+			So(hits, ShouldHaveLength, 1)
+			So(hits[0].ID, ShouldEqual, "1")
+
+			hits, _ = index.Search("posts", "shoe runner")
+			So(hits, ShouldHaveLength, 2)
+			So(hits[0].ID, ShouldEqual, "3")
+			So(hits[0].Score, ShouldBeGreaterThan, hits[1].Score)
+		})
+
+		Convey("Updated and removed documents should be reindexed", func() {
+			index.Index("posts", "2", map[string]interface{}{"title": "Trail running"})
+			hits, _ := index.Search("posts", "pasta")
+			So(hits, ShouldBeEmpty)
+
+			index.Remove("posts", "1")
+			hits, _ = index.Search("posts", "running")
+			So(hits, ShouldHaveLength, 1)
+			So(hits[0].ID, ShouldEqual, "2")
+
+			entries := index.collections["posts"]
+			So(entries.postings["trail"], ShouldResemble, map[string]int{"2": 1})
+			So(entries.postings["shoe"], ShouldResemble, map[string]int{"3": 1})
+			So(entries.terms, ShouldNotContainKey, "1")
+		})
+
+		Convey("Other collections should not be searched", func() {
+			hits, _ := index.Search("comments", "shoes")
+			So(hits, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,32 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// common english words skipped in the documents and the queries
+var StopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true, "to": true, "was": true,
+	"will": true, "with": true,
+}
+
+// splits the text into the lowercase and stemmed terms. ex: 'The running dogs' => ['run', 'dog']
+func Tokenize(text string) (terms []string) {
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms = make([]string, 0, len(words))
+	for _, word := range words {
+		if StopWords[word] {
+			continue
+		}
+		terms = append(terms, Stem(word))
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/search"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type searchingProvider struct {
+	*memoryProvider
+}
+
+func (p *searchingProvider) Search(collection, query string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	return map[string]interface{}{"results": []interface{}{map[string]interface{}{"native": query}}}, nil
+}
+
+func TestSearch(t *testing.T) {
+
+	Convey("Given a search index", t, func() {
+		provider := newMemoryProvider()
+		provider.Create("posts", map[string]interface{}{"title": "Old running shoes", "draft": true})
+
+		DataProvider = provider
+		SearchIndex = search.NewMemoryIndex()
+		EnableBulk("posts")
+		defer func() {
+			DataProvider = nil
+			SearchIndex = nil
+			bulkCollections = make(map[string]bool)
+		}()
+		So(RebuildSearchIndex("posts"), ShouldBeNil)
+
+		execute := func(request messages.Message) (messages.Message, *utils.Error) {
+			response, _, err := Execute(request, provider)
+			return response, err
+		}
+		searchPosts := func(parameters map[string][]string) []interface{} {
+			response, err := execute(messages.Message{Res: "/posts", Command: methods.Get, Parameters: parameters})
+			So(err, ShouldBeNil)
+			return response.Body["results"].([]interface{})
+		}
+
+		execute(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "Running in the rain", "draft": false}})
+		execute(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "Cooking pasta", "draft": false}})
+
+		Convey("Created objects should be found ordered by the score", func() {
+			results := searchPosts(map[string][]string{"q": {"running shoes"}})
+			So(results, ShouldHaveLength, 2)
+			So(results[0].(map[string]interface{})["_id"], ShouldEqual, "1")
+			So(results[0].(map[string]interface{})["_score"], ShouldBeGreaterThan, results[1].(map[string]interface{})["_score"])
+		})
+
+		Convey("Other parameters should be applied to the hits", func() {
+			results := searchPosts(map[string][]string{"q": {"running"}, "where": {`{"draft":false}`}})
+			So(results, ShouldHaveLength, 1)
+			So(results[0].(map[string]interface{})["_id"], ShouldEqual, "2")
+
+			results = searchPosts(map[string][]string{"q": {"running"}, "skip": {"1"}, "limit": {"5"}})
+			So(results, ShouldHaveLength, 1)
+		})
+
+		Convey("Rebuild should index all the pages of the collection", func() {
+			QueryPageSize = 1
+			defer func() { QueryPageSize = 1000 }()
+			SearchIndex = search.NewMemoryIndex()
+			So(RebuildSearchIndex("posts"), ShouldBeNil)
+			So(searchPosts(map[string][]string{"q": {"running pasta"}}), ShouldHaveLength, 3)
+		})
+
+		Convey("Only the hits of the requested page should be fetched", func() {
+			QueryPageSize = 1
+			defer func() { QueryPageSize = 1000 }()
+			counting := &countingProvider{memoryProvider: provider}
+
+			response, _, err := Execute(messages.Message{Res: "/posts", Command: methods.Get, Parameters: map[string][]string{"q": {"running"}, "skip": {"1"}, "limit": {"1"}}}, counting)
+			So(err, ShouldBeNil)
+			So(response.Body["results"], ShouldHaveLength, 1)
+			So(counting.queries, ShouldEqual, 1)
+
+			counting.queries = 0
+			response, _, err = Execute(messages.Message{Res: "/posts", Command: methods.Get, Parameters: map[string][]string{"q": {"running"}, "where": {`{"draft":false}`}, "limit": {"1"}}}, counting)
+			So(err, ShouldBeNil)
+			So(response.Body["results"].([]interface{})[0].(map[string]interface{})["_id"], ShouldEqual, "2")
+			So(counting.queries, ShouldBeLessThanOrEqualTo, 2)
+		})
+
+		Convey("Updated and deleted objects should be reindexed", func() {
+			execute(messages.Message{Res: "/posts/3", Command: methods.Put, Body: map[string]interface{}{"title": "Running with pasta"}})
+			execute(messages.Message{Res: "/posts/1", Command: methods.Delete})
+			So(searchPosts(map[string][]string{"q": {"shoes"}}), ShouldBeEmpty)
+			So(searchPosts(map[string][]string{"q": {"run"}}), ShouldHaveLength, 2)
+
+			execute(messages.Message{Res: "/posts", Command: methods.Delete, Parameters: map[string][]string{"ids": {"2,3"}}})
+			So(searchPosts(map[string][]string{"q": {"run"}}), ShouldBeEmpty)
+		})
+
+		Convey("Providers with native search should be used directly", func() {
+			response, _, err := Execute(messages.Message{Res: "/posts", Command: methods.Get, Parameters: map[string][]string{"q": {"shoes"}}}, &searchingProvider{provider})
+			So(err, ShouldBeNil)
+			So(response.Body["results"].([]interface{})[0].(map[string]interface{})["native"], ShouldEqual, "shoes")
+		})
+
+		Convey("Search should fail when it's not enabled", func() {
+			SearchIndex = nil
+			_, err := execute(messages.Message{Res: "/posts", Command: methods.Get, Parameters: map[string][]string{"q": {"shoes"}}})
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
@@ -0,0 +1,178 @@
+package signedurl
+
+import (
+	"time"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"net/http"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+)
+
+// request scope key set to true when the request has a valid signature
+const VerifiedKey = "signedUrlVerified"
+
+// query parameters added to the signed urls
+const (
+	ExpiresParam   = "expires"
+	IPParam        = "ip"
+	MethodParam    = "method"
+	SignatureParam = "signature"
+)
+
+type Options struct {
+	// duration that the url is valid for
+	ExpiresIn time.Duration
+	// if set, the url is only accepted from this client ip
+	IP string
+	// if set, the url is only accepted for this method. defaults to get
+	Method string
+}
+
+/**
+ * Generates and verifies the urls signed with HMAC-SHA256. The signature covers
+ * the path, the expiry time, the client ip and the method.
+ *
+ * Ex: /files/1a2b?expires=1700000000&method=get&signature=...
+ */
+type Signer struct {
+	Secret []byte
+	// used to get the current time, for testing purposes
+	Now func() time.Time
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{Secret: []byte(secret)}
+}
+
+// returns the path with the signature parameters
+func (s *Signer) Sign(path string, options Options) (signedURL string, err error) {
+
+	if len(s.Secret) == 0 {
+		err = errors.New("Secret of the signer is not set.")
+		return
+	}
+	if options.ExpiresIn <= 0 {
+		err = errors.New("Expiry duration must be positive.")
+		return
+	}
+
+	method := strings.ToLower(options.Method)
+	if method == "" {
+		method = "get"
+	}
+	expires := strconv.FormatInt(s.now().Add(options.ExpiresIn).Unix(), 10)
+
+	query := url.Values{}
+	query.Set(ExpiresParam, expires)
+	query.Set(MethodParam, method)
+	if options.IP != "" {
+		query.Set(IPParam, options.IP)
+	}
+	query.Set(SignatureParam, s.signature(path, expires, options.IP, method))
+
+	signedURL = path + "?" + query.Encode()
+	return
+}
+
+/**
+ * Verifies the signature parameters of the request. Returns contains false if
+ * the request doesn't have a signature.
+ */
+func (s *Signer) Verify(request messages.Message) (contains bool, err *utils.Error) {
+
+	signature, contains := request.GetParameter(SignatureParam)
+	if !contains {
+		return
+	}
+
+	expires, _ := request.GetParameter(ExpiresParam)
+	method, _ := request.GetParameter(MethodParam)
+	ip, _ := request.GetParameter(IPParam)
+
+	expected := s.signature(request.Res, expires, ip, method)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		err = forbidden("Invalid signature.")
+		return
+	}
+
+	expiresAt, parseErr := strconv.ParseInt(expires, 10, 64)
+	if parseErr != nil || s.now().Unix() > expiresAt {
+		err = forbidden("Signature is expired.")
+		return
+	}
+	if method != strings.ToLower(request.Command) {
+		err = forbidden("Signature is not valid for the method.")
+		return
+	}
+	if ip != "" && ip != request.IP {
+		err = forbidden("Signature is not valid for the client address.")
+		return
+	}
+	return
+}
+
+/**
+ * BEFORE_EXEC interceptor verifying the signed urls. Requests with a valid signature
+ * are marked as verified in the request scope and requests with an invalid signature
+ * are rejected. Requests without a signature are passed to the next interceptors.
+ * Must be added before the authentication interceptors.
+ *
+ * Ex: core.Interceptors.Add("/files/{id}", methods.Get, interceptors.BEFORE_EXEC, signer.Intercept, nil)
+ */
+func (s *Signer) Intercept(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	contains, err := s.Verify(req)
+	if err != nil || !contains {
+		return
+	}
+
+	editedRs = rs.Copy()
+	editedRs.Set(VerifiedKey, true)
+	return
+}
+
+/**
+ * Wraps the interceptor so it's skipped for the requests verified by a signed url.
+ * Used for accepting the signed urls instead of the normal authentication.
+ *
+ * Ex: core.Interceptors.Add("/files/{id}", methods.Get, interceptors.BEFORE_EXEC, signedurl.SkipIfVerified(auth), nil)
+ */
+func SkipIfVerified(interceptor interceptors.Interceptor) interceptors.Interceptor {
+	return func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+		if IsVerified(rs) {
+			return
+		}
+		return interceptor(rs, extras, req, resp, dp)
+	}
+}
+
+func IsVerified(rs requestscope.RequestScope) bool {
+	verified, _ := rs.Get(VerifiedKey).(bool)
+	return verified
+}
+
+func (s *Signer) signature(path, expires, ip, method string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(strings.Join([]string{path, expires, ip, method}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Signer) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func forbidden(message string) *utils.Error {
+	return &utils.Error{Code: http.StatusForbidden, Message: message}
+}
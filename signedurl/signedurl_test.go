@@ -0,0 +1,83 @@
+package signedurl
+
+import (
+	"time"
+	"net/url"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func requestFor(signedURL, method, ip string) messages.Message {
+	parsed, _ := url.Parse(signedURL)
+	return messages.Message{Res: parsed.Path, Command: method, IP: ip, Parameters: parsed.Query()}
+}
+
+func TestSigner(t *testing.T) {
+
+	Convey("Given a signer", t, func() {
+		now := time.Unix(1700000000, 0)
+		signer := NewSigner("secret")
+		signer.Now = func() time.Time { return now }
+
+		signedURL, err := signer.Sign("/files/abc", Options{ExpiresIn: time.Minute, IP: "10.0.0.1"})
+		So(err, ShouldBeNil)
+
+		Convey("Valid requests should be verified", func() {
+			contains, err := signer.Verify(requestFor(signedURL, "get", "10.0.0.1"))
+			So(contains, ShouldBeTrue)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Requests from another ip should be rejected", func() {
+			_, err := signer.Verify(requestFor(signedURL, "get", "10.0.0.2"))
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Requests with another method should be rejected", func() {
+			_, err := signer.Verify(requestFor(signedURL, "delete", "10.0.0.1"))
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Requests for another path should be rejected", func() {
+			request := requestFor(signedURL, "get", "10.0.0.1")
+			request.Res = "/files/other"
+			_, err := signer.Verify(request)
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Expired requests should be rejected", func() {
+			now = now.Add(2 * time.Minute)
+			_, err := signer.Verify(requestFor(signedURL, "get", "10.0.0.1"))
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Requests without signature should not be verified", func() {
+			contains, err := signer.Verify(requestFor("/files/abc", "get", "10.0.0.1"))
+			So(contains, ShouldBeFalse)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Verified requests should skip the wrapped interceptor", func() {
+			authCalled := false
+			auth := func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				authCalled = true
+				return
+			}
+
+			_, _, rs, err := signer.Intercept(requestscope.Init(), nil, requestFor(signedURL, "get", "10.0.0.1"), messages.Message{}, nil)
+			So(err, ShouldBeNil)
+			So(IsVerified(rs), ShouldBeTrue)
+
+			SkipIfVerified(auth)(rs, nil, messages.Message{}, messages.Message{}, nil)
+			So(authCalled, ShouldBeFalse)
+
+			SkipIfVerified(auth)(requestscope.Init(), nil, messages.Message{}, messages.Message{}, nil)
+			So(authCalled, ShouldBeTrue)
+		})
+	})
+}
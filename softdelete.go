@@ -0,0 +1,243 @@
+package core
+
+import (
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+)
+
+// field keeping the deletion time of the soft deleted objects
+var DeletedAtField = "deletedAt"
+
+// request scope key set to true by the interceptors for the users allowed to use the 'includeDeleted' parameter
+var AdminScopeKey = "isAdmin"
+
+// layout of the times stored by core. fixed width, so the times can be compared as strings
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+const restoreCommand = "_restore"
+
+// collection => retention period of the deleted objects. zero keeps them forever
+var softDeletes = make(map[string]time.Duration)
+var softDeletesLock sync.RWMutex
+
+/**
+ * Marks the objects of the collection as deleted instead of deleting them. Deleted
+ * objects are excluded from the requests unless the 'includeDeleted' parameter is set
+ * by an admin, and they can be restored by an admin until they are purged after the
+ * retention. Restores run the interceptors of the updates of the object.
+ *
+ * Ex: core.EnableSoftDelete("posts", 30*24*time.Hour)
+ *
+ * DELETE /posts/1                          sets the deletedAt field
+ * GET    /posts?includeDeleted=true        returns the deleted objects too
+ * POST   /posts/1/_restore                 removes the deletedAt field
+ */
+func EnableSoftDelete(collection string, retention time.Duration) {
+	softDeletesLock.Lock()
+	defer softDeletesLock.Unlock()
+	softDeletes[collection] = retention
+}
+
+func isSoftDeleted(collection string) bool {
+	_, enabled := retentionOf(collection)
+	return enabled
+}
+
+func retentionOf(collection string) (retention time.Duration, enabled bool) {
+	softDeletesLock.RLock()
+	defer softDeletesLock.RUnlock()
+	retention, enabled = softDeletes[collection]
+	return
+}
+
+func softDeletedCollections() (collections []string) {
+	softDeletesLock.RLock()
+	defer softDeletesLock.RUnlock()
+	for collection := range softDeletes {
+		collections = append(collections, collection)
+	}
+	return
+}
+
+func isDeleted(object map[string]interface{}) bool {
+	return object != nil && object[DeletedAtField] != nil
+}
+
+// checks if the request scope allows the 'includeDeleted' parameter and the restores of the request
+func checkIncludeDeleted(request messages.Message, requestScope requestscope.RequestScope) *utils.Error {
+	if requestScope.Get(AdminScopeKey) == true {
+		return nil
+	}
+	if value, _ := request.GetParameter("includeDeleted"); value == "true" {
+		return &utils.Error{Code: http.StatusForbidden, Message: "Deleted objects can be included only by the admins."}
+	}
+	if parts := strings.Split(request.Res, "/"); len(parts) == 4 && parts[3] == restoreCommand {
+		return &utils.Error{Code: http.StatusForbidden, Message: "Deleted objects can be restored only by the admins."}
+	}
+	return nil
+}
+
+/**
+ * Hides the deleted objects from the request. Requests of the deleted objects fail
+ * with not found and the queries of the collections are filtered with the deletedAt
+ * field. Id lists of the bulk operations are converted to filters for excluding the
+ * deleted objects.
+ */
+func excludeDeleted(request messages.Message, db dataprovider.Provider) (filtered messages.Message, err *utils.Error) {
+
+	filtered = request
+	parts := strings.Split(request.Res, "/")
+	if !isSoftDeleted(parts[1]) {
+		return
+	}
+
+	includeDeleted, _ := request.GetParameter("includeDeleted")
+	filtered.Parameters = withoutParameter(request.Parameters, "includeDeleted")
+	if includeDeleted == "true" {
+		return
+	}
+
+	if len(parts) == 3 {
+		var object map[string]interface{}
+		if object, err = db.Get(parts[1], parts[2]); err != nil {
+			return
+		}
+		if isDeleted(object) {
+			err = &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+		}
+		return
+	}
+
+	if strings.EqualFold(request.Command, methods.Post) {
+		return
+	}
+
+	where := make(map[string]interface{})
+	if values, hasWhere := filtered.Parameters["where"]; hasWhere && len(values) > 0 {
+		if decodeErr := json.Unmarshal([]byte(values[0]), &where); decodeErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'where' must be a json object."}
+			return
+		}
+	}
+	// null also matches the objects without the field
+	where[DeletedAtField] = nil
+
+	parameters := make(map[string][]string, len(filtered.Parameters)+1)
+	for key, values := range filtered.Parameters {
+		parameters[key] = values
+	}
+	if values, hasIds := parameters["ids"]; hasIds {
+		ids := make([]interface{}, 0)
+		for _, value := range values {
+			for _, id := range strings.Split(value, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+		where[dataprovider.IdField] = map[string]interface{}{"$in": ids}
+		delete(parameters, "ids")
+	}
+
+	encoded, _ := json.Marshal(where)
+	parameters["where"] = []string{string(encoded)}
+	filtered.Parameters = parameters
+	return
+}
+
+func deletionMark() map[string]interface{} {
+	return map[string]interface{}{DeletedAtField: Clock().UTC().Format(timeLayout)}
+}
+
+// restores the soft deleted object. ex: POST /posts/1/_restore
+var handleRestore = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	parts := strings.Split(request.Res, "/")
+	if !isSoftDeleted(parts[1]) {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Soft delete is not enabled for '" + parts[1] + "'."}
+		return
+	}
+
+	if _, err = db.Get(parts[1], parts[2]); err != nil {
+		return
+	}
+	response.Body, err = db.Update(parts[1], parts[2], map[string]interface{}{DeletedAtField: nil})
+	return
+}
+
+/**
+ * Deletes the objects of the collection deleted before the retention period. Returns
+ * the number of the deleted objects.
+ */
+func PurgeDeleted(collection string) (purged int, err *utils.Error) {
+
+	retention, enabled := retentionOf(collection)
+	if !enabled || retention <= 0 {
+		return
+	}
+
+	cutoff := Clock().Add(-retention).UTC().Format(timeLayout)
+	where, _ := json.Marshal(map[string]interface{}{DeletedAtField: map[string]interface{}{"$lt": cutoff}})
+
+	// matches are read before the deletes, so the pages don't shift while the objects are deleted
+	objects, _, err := queryAll(DataProvider, collection, map[string][]string{"where": {string(where)}}, 0)
+	if err != nil {
+		return
+	}
+	for _, object := range objects {
+		// objects are checked again for the providers ignoring the filter
+		deletedAt, _ := object[DeletedAtField].(string)
+		if deletedAt == "" || deletedAt >= cutoff {
+			continue
+		}
+		id := idOf(object)
+		if _, err = DataProvider.Delete(collection, id); err != nil {
+			return
+		}
+		purged++
+		syncSearchIndex(messages.Message{Res: "/" + collection + "/" + id, Command: methods.Delete}, []string{id}, []map[string]interface{}{nil})
+	}
+	return
+}
+
+/**
+ * Purges the deleted objects of all the collections periodically. Returns a function
+ * stopping the job.
+ *
+ * Ex: stop := core.StartPurgeJob(time.Hour)
+ */
+func StartPurgeJob(interval time.Duration) (stop func()) {
+
+	ticker := time.NewTicker(interval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				for _, collection := range softDeletedCollections() {
+					if purged, err := PurgeDeleted(collection); err != nil {
+						log.Error("Purging deleted objects of '" + collection + "' failed. Reason: " + err.Message)
+					} else if purged > 0 {
+						log.Info("Purged " + strconv.Itoa(purged) + " deleted objects of '" + collection + "'.")
+					}
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	// stopping more than once is a no op
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
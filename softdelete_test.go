@@ -0,0 +1,164 @@
+package core
+
+import (
+	"time"
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSoftDelete(t *testing.T) {
+
+	Convey("Given a collection with soft delete", t, func() {
+		provider := newMemoryProvider()
+		provider.Create("posts", map[string]interface{}{"title": "first"})
+		provider.Create("posts", map[string]interface{}{"title": "second"})
+		provider.Create("posts", map[string]interface{}{"title": "third"})
+
+		DataProvider = provider
+		EnableSoftDelete("posts", time.Hour)
+		EnableBulk("posts")
+		current := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		Clock = func() time.Time { return current }
+		defer func() {
+			DataProvider = nil
+			softDeletes = make(map[string]time.Duration)
+			bulkCollections = make(map[string]bool)
+			Clock = time.Now
+			Interceptors = &interceptors.CoreInterceptorController{}
+		}()
+
+		execute := func(command, res string, parameters map[string][]string) (messages.Message, *utils.Error) {
+			response, _, err := Execute(messages.Message{Res: res, Command: command, Parameters: parameters}, provider)
+			return response, err
+		}
+		titles := func(parameters map[string][]string) (titles []interface{}) {
+			response, err := execute(methods.Get, "/posts", parameters)
+			So(err, ShouldBeNil)
+			for _, object := range objectsOf(response.Body, false) {
+				titles = append(titles, object["title"])
+			}
+			return
+		}
+
+		_, err := execute(methods.Delete, "/posts/1", nil)
+		So(err, ShouldBeNil)
+
+		Convey("Deleted objects should be marked and hidden", func() {
+			So(provider.collections["posts"]["1"]["deletedAt"], ShouldEqual, "2024-01-01T12:00:00.000Z")
+			So(titles(nil), ShouldResemble, []interface{}{"second", "third"})
+
+			_, err := execute(methods.Get, "/posts/1", nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			_, err = execute(methods.Delete, "/posts/1", nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Bulk deletes should mark the objects", func() {
+			response, err := execute(methods.Delete, "/posts", map[string][]string{"ids": {"1,2"}})
+			So(err, ShouldBeNil)
+			So(response.Body["succeeded"], ShouldEqual, 1)
+			So(provider.collections["posts"]["2"]["deletedAt"], ShouldNotBeNil)
+			So(titles(nil), ShouldResemble, []interface{}{"third"})
+		})
+
+		Convey("Deleted objects should be included only for the admins", func() {
+			request := messages.Message{Res: "/posts", Command: methods.Get, Parameters: map[string][]string{"includeDeleted": {"true"}}}
+			_, _, err := HandleRequest(request, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+
+			Interceptors.Add("/posts", methods.Get, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				rs.Set(AdminScopeKey, true)
+				editedRs = rs
+				return
+			}, nil)
+			response, _, err := HandleRequest(request, requestscope.Init())
+			So(err, ShouldBeNil)
+			So(objectsOf(response.Body, false), ShouldHaveLength, 3)
+		})
+
+		Convey("Restored objects should be visible again", func() {
+			_, err := execute(methods.Post, "/posts/1/_restore", nil)
+			So(err, ShouldBeNil)
+			So(titles(nil), ShouldResemble, []interface{}{"first", "second", "third"})
+
+			_, err = execute(methods.Post, "/posts/9/_restore", nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Restores should be allowed only for the admins through the interceptors of the object", func() {
+			DataProvider = provider
+			request := messages.Message{Res: "/posts/1/_restore", Command: methods.Post}
+			_, _, err := HandleRequest(request, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+
+			intercepted := false
+			Interceptors = &interceptors.CoreInterceptorController{}
+			Interceptors.Add(interceptors.AnyPath, methods.Any, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				rs.Set(AdminScopeKey, true)
+				return
+			}, nil)
+			Interceptors.Add("/posts/{id}", methods.Put, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				intercepted = true
+				return
+			}, nil)
+			_, _, err = HandleRequest(request, requestscope.Init())
+			So(err, ShouldBeNil)
+			So(intercepted, ShouldBeTrue)
+			So(provider.collections["posts"]["1"]["deletedAt"], ShouldBeNil)
+		})
+
+		Convey("Commands of the deleted objects should fail", func() {
+			EnableVersioning("posts")
+			defer func() { versionedCollections = make(map[string]bool) }()
+
+			_, err := execute(methods.Get, "/posts/1/_versions", nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			_, err = execute(methods.Post, "/posts/1/_revert", map[string][]string{"version": {"1"}})
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			So(provider.collections["posts"]["1"]["deletedAt"], ShouldNotBeNil)
+
+			_, err = execute(methods.Get, "/posts/2/_versions", nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Deleted objects should be purged after the retention", func() {
+			current = current.Add(30 * time.Minute)
+			execute(methods.Delete, "/posts/2", nil)
+
+			purged, err := PurgeDeleted("posts")
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 0)
+
+			current = current.Add(45 * time.Minute)
+			purged, _ = PurgeDeleted("posts")
+			So(purged, ShouldEqual, 1)
+			So(provider.collections["posts"], ShouldNotContainKey, "1")
+			So(provider.collections["posts"], ShouldContainKey, "2")
+		})
+
+		Convey("Purges should delete the matches of all the pages", func() {
+			QueryPageSize = 1
+			defer func() { QueryPageSize = 1000 }()
+			execute(methods.Delete, "/posts/2", nil)
+
+			current = current.Add(2 * time.Hour)
+			purged, err := PurgeDeleted("posts")
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 2)
+			So(provider.collections["posts"], ShouldHaveLength, 1)
+		})
+
+		Convey("Purge job should be stopped more than once safely", func() {
+			stop := StartPurgeJob(time.Hour)
+			stop()
+			So(stop, ShouldNotPanic)
+		})
+	})
+}
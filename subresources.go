@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+)
+
+type SubResource struct {
+	Parent     string
+	Child      string
+	ForeignKey string
+}
+
+var subResources = make(map[string]SubResource)
+
+/**
+ * Serves the objects of the child collection referencing the parent object by the
+ * foreign key under the parent object. Requests are executed on the child collection
+ * restricted to the objects of the parent, through the interceptors of the child
+ * collection as well.
+ *
+ * Ex: core.AddSubResource("users", "posts", "userId")
+ *
+ * GET  /users/{id}/posts           queries the posts with {"userId": id}
+ * POST /users/{id}/posts           creates a post with the userId field set
+ * GET  /users/{id}/posts/{postId}  gets the post if its userId is id
+ */
+func AddSubResource(parent, child, foreignKey string) {
+	subResources[parent+"/"+child] = SubResource{parent, child, foreignKey}
+}
+
+// returns true if the resource is in a sub resource. ex: /users/1/posts, /users/1/posts/2
+func isSubResource(res string) bool {
+	parts := strings.Split(res, "/")
+	if (len(parts) != 4 && len(parts) != 5) || isAggregation(res) {
+		return false
+	}
+	if _, _, isFileResource := fileResource(res); isFileResource {
+		return false
+	}
+	_, isCommand := objectCommandOf(res)
+	return !isCommand
+}
+
+// converts the request of a sub resource to the request of the child collection
+func resolveSubResource(request messages.Message, db dataprovider.Provider) (resolved messages.Message, err *utils.Error) {
+
+	parts := strings.Split(request.Res, "/")
+	parentID := parts[2]
+	subResource, exists := subResources[parts[1]+"/"+parts[3]]
+	if !exists {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Sub resource doesn't exist."}
+		return
+	}
+
+	if _, err = db.Get(subResource.Parent, parentID); err != nil {
+		return
+	}
+
+	resolved = request
+	resolved.Res = "/" + subResource.Child
+	if len(parts) == 5 {
+		resolved.Res += "/" + parts[4]
+		err = resolveSubResourceModel(&resolved, subResource, parentID, db)
+	} else {
+		err = resolveSubResourceCollection(&resolved, subResource, parentID)
+	}
+	return
+}
+
+func resolveSubResourceModel(request *messages.Message, subResource SubResource, parentID string, db dataprovider.Provider) (err *utils.Error) {
+
+	id := request.Res[strings.LastIndex(request.Res, "/")+1:]
+	object, err := db.Get(subResource.Child, id)
+	if err != nil {
+		return
+	}
+	if fmt.Sprint(object[subResource.ForeignKey]) != parentID {
+		err = &utils.Error{Code: http.StatusNotFound, Message: "Object doesn't exist in the sub resource."}
+		return
+	}
+
+	// objects can't be moved to another parent
+	if request.Body != nil {
+		request.Body = withField(request.Body, subResource.ForeignKey, parentID)
+	}
+	return
+}
+
+func resolveSubResourceCollection(request *messages.Message, subResource SubResource, parentID string) (err *utils.Error) {
+
+	if strings.EqualFold(request.Command, methods.Post) {
+		if request.Body != nil {
+			request.Body = withField(request.Body, subResource.ForeignKey, parentID)
+		}
+		if request.Items != nil {
+			items := make([]map[string]interface{}, len(request.Items))
+			for i, item := range request.Items {
+				items[i] = withField(item, subResource.ForeignKey, parentID)
+			}
+			request.Items = items
+		}
+		return
+	}
+
+	// queries and bulk operations are restricted by the foreign key
+	where := make(map[string]interface{})
+	if values, hasWhere := request.Parameters["where"]; hasWhere && len(values) > 0 {
+		if decodeErr := json.Unmarshal([]byte(values[0]), &where); decodeErr != nil {
+			err = &utils.Error{Code: http.StatusBadRequest, Message: "Parameter 'where' must be a json object."}
+			return
+		}
+	}
+	where[subResource.ForeignKey] = parentID
+
+	parameters := make(map[string][]string, len(request.Parameters)+1)
+	for key, values := range request.Parameters {
+		parameters[key] = values
+	}
+
+	// id lists of the bulk operations are converted to filters, so the objects of the other parents are excluded
+	if values, hasIds := parameters["ids"]; hasIds {
+		ids := make([]interface{}, 0)
+		for _, value := range values {
+			for _, id := range strings.Split(value, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+		where[dataprovider.IdField] = map[string]interface{}{"$in": ids}
+		delete(parameters, "ids")
+	}
+
+	encoded, _ := json.Marshal(where)
+	parameters["where"] = []string{string(encoded)}
+	request.Parameters = parameters
+	return
+}
+
+// returns a copy of the object with the field
+func withField(object map[string]interface{}, field string, value interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(object)+1)
+	for key, fieldValue := range object {
+		copied[key] = fieldValue
+	}
+	copied[field] = value
+	return copied
+}
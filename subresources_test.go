@@ -0,0 +1,112 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSubResources(t *testing.T) {
+
+	Convey("Given posts as a sub resource of users", t, func() {
+		provider := newMemoryProvider()
+		provider.Create("users", map[string]interface{}{"name": "alice"})
+		provider.Create("users", map[string]interface{}{"name": "bob"})
+		provider.Create("posts", map[string]interface{}{"userId": "1", "title": "first"})
+		provider.Create("posts", map[string]interface{}{"userId": "2", "title": "second"})
+
+		AddSubResource("users", "posts", "userId")
+		defer func() { subResources = make(map[string]SubResource) }()
+
+		execute := func(command, res string, parameters map[string][]string, body map[string]interface{}) (messages.Message, int) {
+			response, _, err := Execute(messages.Message{Res: res, Command: command, Parameters: parameters, Body: body}, provider)
+			if err != nil {
+				return response, err.Code
+			}
+			return response, 0
+		}
+
+		Convey("Queries should return the objects of the parent", func() {
+			response, code := execute(methods.Get, "/users/1/posts", nil, nil)
+			So(code, ShouldEqual, 0)
+			results := response.Body["results"].([]interface{})
+			So(results, ShouldHaveLength, 1)
+			So(results[0].(map[string]interface{})["title"], ShouldEqual, "first")
+		})
+
+		Convey("Created objects should reference the parent", func() {
+			response, code := execute(methods.Post, "/users/2/posts", nil, map[string]interface{}{"title": "new", "userId": "1"})
+			So(code, ShouldEqual, 0)
+			So(response.Body["userId"], ShouldEqual, "2")
+		})
+
+		Convey("Objects of the other parents should not be found", func() {
+			_, code := execute(methods.Get, "/users/1/posts/4", nil, nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+
+			_, code = execute(methods.Delete, "/users/1/posts/4", nil, nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+			So(provider.collections["posts"], ShouldContainKey, "4")
+		})
+
+		Convey("Objects should not be moved to another parent", func() {
+			response, code := execute(methods.Put, "/users/1/posts/3", nil, map[string]interface{}{"userId": "2"})
+			So(code, ShouldEqual, 0)
+			So(response.Body["userId"], ShouldEqual, "1")
+		})
+
+		Convey("Bulk deletes should only remove the objects of the parent", func() {
+			EnableBulk("posts")
+			defer func() { bulkCollections = make(map[string]bool) }()
+			response, code := execute(methods.Delete, "/users/1/posts", map[string][]string{"ids": {"3,4"}}, nil)
+			So(code, ShouldEqual, 0)
+			So(response.Body["succeeded"], ShouldEqual, 1)
+			So(provider.collections["posts"], ShouldNotContainKey, "3")
+			So(provider.collections["posts"], ShouldContainKey, "4")
+		})
+
+		Convey("Interceptors of the child collection should be executed", func() {
+			DataProvider = provider
+			var intercepted []string
+			intercept := func(name string) interceptors.Interceptor {
+				return func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+					intercepted = append(intercepted, name+" "+req.Res)
+					if name == "model" && rs.Get("id") == "3" {
+						err = &utils.Error{Code: http.StatusForbidden, Message: "Post is locked."}
+					}
+					return
+				}
+			}
+			Interceptors = &interceptors.CoreInterceptorController{}
+			Interceptors.Add("/posts/{id}", methods.Delete, interceptors.BEFORE_EXEC, intercept("model"), nil)
+			Interceptors.Add("/posts", methods.Get, interceptors.BEFORE_EXEC, intercept("collection"), nil)
+			Interceptors.Add(interceptors.AnyPath, methods.Any, interceptors.BEFORE_EXEC, intercept("any"), nil)
+			defer func() {
+				DataProvider = nil
+				Interceptors = &interceptors.CoreInterceptorController{}
+			}()
+
+			_, _, err := HandleRequest(messages.Message{Res: "/users/1/posts/3", Command: methods.Delete}, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+			So(provider.collections["posts"], ShouldContainKey, "3")
+
+			_, _, err = HandleRequest(messages.Message{Res: "/users/1/posts", Command: methods.Get}, requestscope.Init())
+			So(err, ShouldBeNil)
+			So(intercepted, ShouldResemble, []string{"any /users/1/posts/3", "model /posts/3", "any /users/1/posts", "collection /posts"})
+		})
+
+		Convey("Missing parents and unknown sub resources should not be found", func() {
+			_, code := execute(methods.Get, "/users/9/posts", nil, nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+
+			_, code = execute(methods.Get, "/users/1/comments", nil, nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
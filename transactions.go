@@ -0,0 +1,66 @@
+package core
+
+import (
+	"regexp"
+	"net/http"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+var transactionalPaths []*regexp.Regexp
+
+/**
+ * Executes the requests of the paths matching the rich url in a transaction of the
+ * data provider. BEFORE_EXEC and AFTER_EXEC interceptors and the functions get the
+ * provider bound to the transaction. Transaction is committed if the request
+ * completes without error and rolled back otherwise. ON_ERROR and FINAL interceptors
+ * get the data provider, so their changes are not rolled back. Requests fail with 500
+ * if the data provider doesn't support transactions.
+ *
+ * Ex: core.EnableTransactions("/orders")
+ */
+func EnableTransactions(path string) {
+	transactionalPaths = append(transactionalPaths, regexp.MustCompile(utils.ConvertRichUrlToRegex(path, true)))
+}
+
+func isTransactional(res string) bool {
+	for _, regex := range transactionalPaths {
+		if regex.MatchString(res) {
+			return true
+		}
+	}
+	return false
+}
+
+// begins a transaction if enabled for the resource. returns the data provider otherwise
+func beginTransaction(res string) (db dataprovider.Provider, tx dataprovider.Transaction, err *utils.Error) {
+
+	db = DataProvider
+	if !isTransactional(res) {
+		return
+	}
+
+	// requests are not executed without the transaction they expect
+	transactional, supported := DataProvider.(dataprovider.TransactionalProvider)
+	if !supported {
+		log.Error("Transactions are enabled for '" + res + "' but the data provider doesn't support transactions.")
+		err = &utils.Error{Code: http.StatusInternalServerError, Message: "Data provider doesn't support transactions."}
+		return
+	}
+
+	if tx, err = transactional.Begin(); err == nil {
+		db = tx
+	}
+	return
+}
+
+func endTransaction(tx dataprovider.Transaction, err *utils.Error) (commitErr *utils.Error) {
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Error("Rolling back transaction failed. Reason: " + rollbackErr.Message)
+		}
+		return
+	}
+	return tx.Commit()
+}
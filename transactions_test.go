@@ -0,0 +1,119 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/functions"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingTransaction struct {
+	dataprovider.Provider
+	provider *transactionalProvider
+	created  []string
+}
+
+func (t *recordingTransaction) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	t.created = append(t.created, collection)
+	return map[string]interface{}{"_id": "1"}, nil
+}
+
+func (t *recordingTransaction) Commit() (err *utils.Error) {
+	t.provider.committed = append(t.provider.committed, t.created...)
+	return
+}
+
+func (t *recordingTransaction) Rollback() (err *utils.Error) {
+	t.provider.rollbacks++
+	return
+}
+
+type transactionalProvider struct {
+	dataprovider.Provider
+	committed []string
+	rollbacks int
+}
+
+func (p *transactionalProvider) Begin() (tx dataprovider.Transaction, err *utils.Error) {
+	return &recordingTransaction{provider: p}, nil
+}
+
+// memory provider applying the changes immediately. commits fail with commitErr
+type memoryTransactions struct {
+	*memoryProvider
+	commitErr *utils.Error
+}
+
+type memoryTransaction struct {
+	*memoryProvider
+	provider *memoryTransactions
+}
+
+func (p *memoryTransactions) Begin() (tx dataprovider.Transaction, err *utils.Error) {
+	return &memoryTransaction{p.memoryProvider, p}, nil
+}
+
+func (t *memoryTransaction) Commit() (err *utils.Error) {
+	return t.provider.commitErr
+}
+
+func (t *memoryTransaction) Rollback() (err *utils.Error) {
+	return
+}
+
+func TestTransactions(t *testing.T) {
+
+	Convey("Given a function in a transactional path", t, func() {
+		provider := &transactionalProvider{}
+		DataProvider = provider
+		Functions = &functions.CoreFunctionController{}
+		EnableTransactions("/checkout")
+		defer func() {
+			DataProvider = nil
+			Functions = &functions.CoreFunctionController{}
+			transactionalPaths = nil
+		}()
+
+		outOfStock := false
+		Functions.Add("/checkout", methods.Post, func(req messages.Message, rs requestscope.RequestScope, extras interface{}, db dataprovider.Provider) (resp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+			db.Create("orders", req.Body)
+			if outOfStock {
+				err = &utils.Error{Code: http.StatusConflict, Message: "Out of stock."}
+				return
+			}
+			db.Create("stock", req.Body)
+			resp.Body = map[string]interface{}{"ok": true}
+			return
+		}, nil)
+
+		request := messages.Message{Res: "/checkout", Command: methods.Post, Body: map[string]interface{}{}}
+
+		Convey("Successful requests should be committed", func() {
+			_, _, err := HandleRequest(request, requestscope.Init())
+			So(err, ShouldBeNil)
+			So(provider.committed, ShouldResemble, []string{"orders", "stock"})
+			So(provider.rollbacks, ShouldEqual, 0)
+		})
+
+		Convey("Failed requests should be rolled back", func() {
+			outOfStock = true
+			_, _, err := HandleRequest(request, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusConflict)
+			So(provider.committed, ShouldBeEmpty)
+			So(provider.rollbacks, ShouldEqual, 1)
+		})
+
+		Convey("Requests should fail if the data provider doesn't support transactions", func() {
+			memory := newMemoryProvider()
+			DataProvider = memory
+			_, _, err := HandleRequest(request, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusInternalServerError)
+			So(memory.collections["orders"], ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"net"
+	"errors"
+	"strings"
+	"net/http"
+)
+
+/**
+ * Resolves the client address of a request.
+ *
+ * Forwarding headers are only taken into account when the request is received
+ * from one of the trusted proxies. The headers are walked right-to-left and the
+ * first address which is not a trusted proxy is accepted as the client address.
+ * 'Forwarded' (RFC 7239) is preferred over 'X-Forwarded-For', which is preferred
+ * over 'X-Real-IP'.
+ */
+type IPResolver struct {
+	TrustedProxies []*net.IPNet
+}
+
+var DefaultIPResolver = &IPResolver{}
+
+/**
+ * Creates a resolver which trusts the given proxies. Proxies can be given
+ * either as CIDR blocks or single addresses.
+ *
+ * Ex: NewIPResolver("10.0.0.0/8", "fd00::/8", "192.168.1.10")
+ */
+func NewIPResolver(trustedProxies ...string) (resolver *IPResolver, err error) {
+
+	resolver = &IPResolver{TrustedProxies: make([]*net.IPNet, 0, len(trustedProxies))}
+	for _, proxy := range trustedProxies {
+		network, parseErr := ParseCIDR(proxy)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		resolver.TrustedProxies = append(resolver.TrustedProxies, network)
+	}
+	return
+}
+
+/**
+ * Parses a CIDR block. Single addresses are converted into a block that
+ * contains only that address.
+ */
+func ParseCIDR(value string) (network *net.IPNet, err error) {
+
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		_, network, err = net.ParseCIDR(value)
+		return
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		err = errors.New("Invalid IP address or CIDR block: " + value)
+		return
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		network = &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	} else {
+		network = &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+	return
+}
+
+func (r *IPResolver) IsTrusted(ip net.IP) bool {
+	if r == nil || ip == nil {
+		return false
+	}
+	for _, network := range r.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns the client address and the hop chain of the request. The chain is
+ * ordered from the client to the peer that is directly connected to the server.
+ * Forwarding headers are only included in the chain if the peer is trusted.
+ */
+func (r *IPResolver) Resolve(req *http.Request) (ip string, chain []string, err error) {
+
+	peer := ParseAddress(req.RemoteAddr)
+	if peer == nil {
+		err = errors.New("error: Could not parse the remote address of the request")
+		return
+	}
+
+	ip = peer.String()
+	chain = []string{ip}
+	if !r.IsTrusted(peer) {
+		return
+	}
+
+	hops := forwardedHops(req.Header)
+	chain = append(hops, ip)
+
+	// walk right-to-left and stop at the first address that is not a trusted proxy
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := ParseAddress(hops[i])
+		if hop == nil {
+			// the chain can't be trusted beyond an invalid or obfuscated entry
+			break
+		}
+		chain[i] = hop.String()
+		ip = chain[i]
+		if !r.IsTrusted(hop) {
+			break
+		}
+	}
+	return
+}
+
+/**
+ * Parses an address that may contain a port, brackets or quotes.
+ *
+ * Ex: "10.0.0.1", "10.0.0.1:8080", "2001:db8::1", "[2001:db8::1]:8080", "\"[2001:db8::1]\""
+ */
+func ParseAddress(value string) net.IP {
+
+	value = strings.Trim(strings.TrimSpace(value), "\"")
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+		if end == -1 {
+			return nil
+		}
+		value = value[1:end]
+	} else if strings.Count(value, ":") == 1 {
+		value = value[:strings.Index(value, ":")]
+	}
+
+	// drop the ipv6 zone if exists
+	if zone := strings.Index(value, "%"); zone != -1 {
+		value = value[:zone]
+	}
+
+	ip := net.ParseIP(value)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func forwardedHops(header http.Header) (hops []string) {
+
+	hops = make([]string, 0)
+
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range splitQuoted(strings.Join(values, ","), ',') {
+			hop := "unknown"
+			for _, pair := range splitQuoted(element, ';') {
+				keyValue := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(keyValue) == 2 && strings.EqualFold(keyValue[0], "for") {
+					hop = strings.Trim(keyValue[1], "\"")
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return
+	}
+
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, hop := range strings.Split(strings.Join(values, ","), ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+		return
+	}
+
+	if value := strings.TrimSpace(header.Get("X-Real-IP")); value != "" {
+		hops = append(hops, value)
+	}
+	return
+}
+
+// splits the value by the separator, ignoring the separators in quoted strings
+func splitQuoted(value string, separator rune) (parts []string) {
+
+	quoted := false
+	start := 0
+	for i, c := range value {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == separator && !quoted {
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, value[start:])
+	return
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"testing"
+	"net/http"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIPResolver(t *testing.T) {
+
+	Convey("Given a resolver trusting the internal networks", t, func() {
+		resolver, err := NewIPResolver("10.0.0.0/8", "fd00::/8", "192.168.1.10")
+		So(err, ShouldBeNil)
+
+		req, _ := http.NewRequest(http.MethodGet, "/users", nil)
+
+		Convey("When the peer is not trusted", func() {
+			req.RemoteAddr = "203.0.113.5:4000"
+			req.Header.Set("X-Forwarded-For", "1.1.1.1")
+
+			ip, chain, err := resolver.Resolve(req)
+
+			Convey("Forwarding headers should be ignored", func() {
+				So(err, ShouldBeNil)
+				So(ip, ShouldEqual, "203.0.113.5")
+				So(chain, ShouldResemble, []string{"203.0.113.5"})
+			})
+		})
+
+		Convey("When the request is forwarded by trusted proxies", func() {
+			req.RemoteAddr = "10.0.0.2:4000"
+			req.Header.Set("X-Forwarded-For", "1.1.1.1, 198.51.100.7, 10.0.0.3")
+
+			ip, chain, _ := resolver.Resolve(req)
+
+			Convey("The right-most untrusted address should be the client", func() {
+				So(ip, ShouldEqual, "198.51.100.7")
+			})
+
+			Convey("The chain should contain all hops", func() {
+				So(chain, ShouldResemble, []string{"1.1.1.1", "198.51.100.7", "10.0.0.3", "10.0.0.2"})
+			})
+		})
+
+		Convey("When the request has a Forwarded header with ipv6 addresses", func() {
+			req.RemoteAddr = "[fd00::1]:4000"
+			req.Header.Set("Forwarded", `for="[2001:db8::17]:4711";proto=https, for=192.168.1.10`)
+			req.Header.Set("X-Forwarded-For", "1.1.1.1")
+
+			ip, chain, _ := resolver.Resolve(req)
+
+			Convey("Forwarded should be preferred", func() {
+				So(ip, ShouldEqual, "2001:db8::17")
+				So(chain, ShouldResemble, []string{"2001:db8::17", "192.168.1.10", "fd00::1"})
+			})
+		})
+
+		Convey("When the chain contains an invalid entry", func() {
+			req.RemoteAddr = "10.0.0.2:4000"
+			req.Header.Set("X-Forwarded-For", "1.1.1.1, garbage, 10.0.0.3")
+
+			ip, _, _ := resolver.Resolve(req)
+
+			Convey("The last valid trusted hop should be returned", func() {
+				So(ip, ShouldEqual, "10.0.0.3")
+			})
+		})
+
+		Convey("When only X-Real-IP is set", func() {
+			req.RemoteAddr = "10.0.0.2:4000"
+			req.Header.Set("X-Real-IP", "198.51.100.9")
+
+			ip, _, _ := resolver.Resolve(req)
+
+			Convey("X-Real-IP should be used", func() {
+				So(ip, ShouldEqual, "198.51.100.9")
+			})
+		})
+	})
+
+	Convey("Given an invalid proxy definition", t, func() {
+		_, err := NewIPResolver("not-an-ip")
+
+		Convey("Creating the resolver should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
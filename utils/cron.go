@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Schedule parsed from a cron expression with the fields minute, hour, day of month,
+ * month and day of week. Fields accept '*', numbers, ranges, lists and steps. Months
+ * and days of week accept the names. Day of month and day of week match either one
+ * when both are restricted.
+ *
+ * Ex: "0,30 9-17 * * mon-fri", "5/15 * * * *", "0 0 1 jan,jul *", "@daily"
+ */
+type CronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	anyDay, anyWeekday                     bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func ParseCron(expression string) (schedule *CronSchedule, err error) {
+
+	expression = strings.TrimSpace(expression)
+	if descriptor, isDescriptor := cronDescriptors[strings.ToLower(expression)]; isDescriptor {
+		expression = descriptor
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("Cron expression must have 5 fields.")
+	}
+
+	schedule = &CronSchedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	// 7 is sunday as well
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, err
+	}
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	return
+}
+
+func parseCronField(field string, min, max int, names []string) (bits uint64, err error) {
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if slash := strings.Index(part, "/"); slash != -1 {
+			rangePart = part[:slash]
+			if step, err = strconv.Atoi(part[slash+1:]); err != nil || step < 1 {
+				return 0, errors.New("Invalid step in cron field '" + field + "'.")
+			}
+		}
+
+		var from, to int
+		if rangePart == "*" {
+			from, to = min, max
+		} else if dash := strings.Index(rangePart, "-"); dash != -1 {
+			if from, err = cronValue(rangePart[:dash], names, min); err != nil {
+				return 0, err
+			}
+			if to, err = cronValue(rangePart[dash+1:], names, min); err != nil {
+				return 0, err
+			}
+		} else {
+			if from, err = cronValue(rangePart, names, min); err != nil {
+				return 0, err
+			}
+			to = from
+			// steps of the single values continue until the end. ex: 5/15
+			if step > 1 {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, errors.New("Cron field '" + field + "' is out of range.")
+		}
+		for value := from; value <= to; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return
+}
+
+func cronValue(value string, names []string, offset int) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return i + offset, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Invalid cron value '" + value + "'.")
+	}
+	return number, nil
+}
+
+// returns the first time matching the schedule after the time, in the location of the time
+func (s *CronSchedule) Next(after time.Time) time.Time {
+
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// schedules like 'feb 30' never match
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"time"
+	"testing"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCron(t *testing.T) {
+
+	Convey("Given a time", t, func() {
+		start := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC)
+
+		next := func(expression string) string {
+			schedule, err := ParseCron(expression)
+			So(err, ShouldBeNil)
+			return schedule.Next(start).Format("2006-01-02 15:04 Mon")
+		}
+
+		Convey("Next times should match the fields", func() {
+			So(next("* * * * *"), ShouldEqual, "2024-01-31 10:08 Wed")
+			So(next("0,30 9-17 * * *"), ShouldEqual, "2024-01-31 10:30 Wed")
+			So(next("5/15 * * * *"), ShouldEqual, "2024-01-31 10:20 Wed")
+			So(next("0 6 * * *"), ShouldEqual, "2024-02-01 06:00 Thu")
+			So(next("0 0 1 jul *"), ShouldEqual, "2024-07-01 00:00 Mon")
+			So(next("0 0 * * sat,sun"), ShouldEqual, "2024-02-03 00:00 Sat")
+			So(next("0 0 * * 7"), ShouldEqual, "2024-02-04 00:00 Sun")
+			So(next("0 0 29 feb *"), ShouldEqual, "2024-02-29 00:00 Thu")
+			So(next("@monthly"), ShouldEqual, "2024-02-01 00:00 Thu")
+		})
+
+		Convey("Restricted day of month and day of week should match either one", func() {
+			So(next("0 0 15 * fri"), ShouldEqual, "2024-02-02 00:00 Fri")
+		})
+
+		Convey("Schedules never matching should return the zero time", func() {
+			schedule, _ := ParseCron("0 0 30 feb *")
+			So(schedule.Next(start).IsZero(), ShouldBeTrue)
+		})
+
+		Convey("Invalid expressions should be rejected", func() {
+			for _, expression := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-2 * * * *", "* * * foo *"} {
+				_, err := ParseCron(expression)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
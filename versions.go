@@ -0,0 +1,231 @@
+package core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"net/http"
+	"encoding/json"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+)
+
+// revisions of a collection are kept in the collection with this suffix. ex: posts_versions
+var VersionsCollectionSuffix = "_versions"
+
+// version of the object. set by the updates, objects without it are at the first version
+const VersionField = "_version"
+
+const versionsCommand = "_versions"
+const revertCommand = "_revert"
+
+var versionedCollections = make(map[string]bool)
+
+/**
+ * Keeps the previous revisions of the objects of the collection. Each update stores
+ * the object before the update as a revision with its version and increments the
+ * version in the _version field of the object. The first version is the object as
+ * created and the last version is the current object.
+ *
+ * Ex: core.EnableVersioning("posts")
+ *
+ * GET  /posts/1/_versions          lists the versions
+ * GET  /posts/1?version=2          returns the object at the version
+ * POST /posts/1/_revert?version=2  updates the object to the version
+ */
+func EnableVersioning(collection string) {
+	versionedCollections[collection] = true
+}
+
+// stores the object before the update as a revision with the version of the object
+func saveRevision(class, id string, previous map[string]interface{}, db dataprovider.Provider) (revisionID string, err *utils.Error) {
+	revision, err := db.Create(class+VersionsCollectionSuffix, map[string]interface{}{
+		"objectId":  id,
+		"version":   currentVersion(previous),
+		"object":    previous,
+		"createdAt": Clock().UTC().Format(timeLayout),
+	})
+	if err == nil {
+		revisionID = idOf(revision)
+	}
+	return
+}
+
+// removes the revision saved for an update failed
+func removeRevision(class, revisionID string, db dataprovider.Provider) {
+	if revisionID == "" {
+		return
+	}
+	if _, err := db.Delete(class+VersionsCollectionSuffix, revisionID); err != nil {
+		log.Error("Removing revision '" + revisionID + "' of the failed update failed. Reason: " + err.Message)
+	}
+}
+
+// returns all the stored revisions of the object ordered by the version
+func revisionsOf(class, id string, db dataprovider.Provider) (revisions []map[string]interface{}, err *utils.Error) {
+
+	where, _ := json.Marshal(map[string]interface{}{"objectId": id})
+	if revisions, _, err = queryAll(db, class+VersionsCollectionSuffix, map[string][]string{"where": {string(where)}}, 0); err != nil {
+		return
+	}
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return versionOf(revisions[i]["version"]) < versionOf(revisions[j]["version"])
+	})
+	return
+}
+
+// returns the version in the _version field of the object
+func currentVersion(object map[string]interface{}) int {
+	if version := versionOf(object[VersionField]); version > 1 {
+		return version
+	}
+	return 1
+}
+
+func versionOf(value interface{}) int {
+	switch version := value.(type) {
+	case int:
+		return version
+	case int64:
+		return int(version)
+	case float64:
+		return int(version)
+	case json.Number:
+		number, _ := version.Int64()
+		return int(number)
+	}
+	return 0
+}
+
+// returns the versions of the object including the current one
+func versionsOf(class, id string, db dataprovider.Provider) (versions []map[string]interface{}, err *utils.Error) {
+
+	current, err := db.Get(class, id)
+	if err != nil {
+		return
+	}
+	revisions, err := revisionsOf(class, id, db)
+	if err != nil {
+		return
+	}
+
+	versions = make([]map[string]interface{}, 0, len(revisions)+1)
+	for _, revision := range revisions {
+		object, _ := revision["object"].(map[string]interface{})
+		versions = append(versions, map[string]interface{}{
+			"version":   versionOf(revision["version"]),
+			"createdAt": revision["createdAt"],
+			"object":    object,
+		})
+	}
+	versions = append(versions, map[string]interface{}{
+		"version": currentVersion(current),
+		"current": true,
+		"object":  current,
+	})
+	return
+}
+
+// returns the object at the version in the 'version' parameter or the body
+func objectAtVersion(request messages.Message, class, id string, db dataprovider.Provider) (object map[string]interface{}, err *utils.Error) {
+
+	if !versionedCollections[class] {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Versioning is not enabled for '" + class + "'."}
+		return
+	}
+
+	value, hasVersion := request.GetParameter("version")
+	if !hasVersion && request.Body != nil && request.Body["version"] != nil {
+		value, hasVersion = jsonString(request.Body["version"]), true
+	}
+	version, convertErr := strconv.Atoi(value)
+	if !hasVersion || convertErr != nil {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Version must be a number."}
+		return
+	}
+
+	versions, err := versionsOf(class, id, db)
+	if err != nil {
+		return
+	}
+	for _, stored := range versions {
+		if stored["version"] == version {
+			object, _ = stored["object"].(map[string]interface{})
+			return
+		}
+	}
+	err = &utils.Error{Code: http.StatusNotFound, Message: "Version " + strconv.Itoa(version) + " doesn't exist."}
+	return
+}
+
+func jsonString(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// returns the object at the version. ex: GET /posts/1?version=2
+var handleGetVersion = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	parts := strings.Split(request.Res, "/")
+	response.Body, err = objectAtVersion(request, parts[1], parts[2], db)
+	return
+}
+
+// lists the versions of the object. ex: GET /posts/1/_versions
+var handleVersions = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	parts := strings.Split(request.Res, "/")
+	if !versionedCollections[parts[1]] {
+		err = &utils.Error{Code: http.StatusBadRequest, Message: "Versioning is not enabled for '" + parts[1] + "'."}
+		return
+	}
+
+	versions, err := versionsOf(parts[1], parts[2], db)
+	if err != nil {
+		return
+	}
+	results := make([]interface{}, len(versions))
+	for i, version := range versions {
+		results[i] = version
+	}
+	response.Body = map[string]interface{}{dataprovider.ResultsField: results}
+	return
+}
+
+/**
+ * Updates the object to the version. Fields added after the version are removed. The
+ * object before the revert is stored as a new version, so reverts can be reverted.
+ *
+ * Ex: POST /posts/1/_revert {"version": 2}
+ */
+var handleRevert = func(request messages.Message, db dataprovider.Provider) (response messages.Message, err *utils.Error) {
+
+	parts := strings.Split(request.Res, "/")
+	object, err := objectAtVersion(request, parts[1], parts[2], db)
+	if err != nil {
+		return
+	}
+	current, err := db.Get(parts[1], parts[2])
+	if err != nil {
+		return
+	}
+
+	data := make(map[string]interface{}, len(object))
+	for field := range current {
+		if field != dataprovider.IdField {
+			data[field] = nil
+		}
+	}
+	for field, value := range object {
+		if field != dataprovider.IdField {
+			data[field] = value
+		}
+	}
+
+	putRequest := request
+	putRequest.Res = "/" + parts[1] + "/" + parts[2]
+	putRequest.Body = data
+	return handlePut(putRequest, db)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"testing"
+	"net/http"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/interceptors"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// memory provider failing the updates
+type readOnlyProvider struct {
+	*memoryProvider
+}
+
+func (p *readOnlyProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	return nil, &utils.Error{Code: http.StatusServiceUnavailable, Message: "Provider is read only."}
+}
+
+func TestVersions(t *testing.T) {
+
+	Convey("Given a versioned collection", t, func() {
+		provider := newMemoryProvider()
+		provider.Create("posts", map[string]interface{}{"title": "first"})
+
+		EnableVersioning("posts")
+		defer func() { versionedCollections = make(map[string]bool) }()
+
+		execute := func(command, res string, parameters map[string][]string, body map[string]interface{}) (messages.Message, *utils.Error) {
+			response, _, err := Execute(messages.Message{Res: res, Command: command, Parameters: parameters, Body: body}, provider)
+			return response, err
+		}
+
+		execute(methods.Put, "/posts/1", nil, map[string]interface{}{"title": "second"})
+		execute(methods.Put, "/posts/1", nil, map[string]interface{}{"title": "third", "tag": "news"})
+
+		Convey("Updates should store the previous versions", func() {
+			response, err := execute(methods.Get, "/posts/1/_versions", nil, nil)
+			So(err, ShouldBeNil)
+
+			versions := objectsOf(response.Body, false)
+			So(versions, ShouldHaveLength, 3)
+			So(versions[0]["object"].(map[string]interface{})["title"], ShouldEqual, "first")
+			So(versions[1]["object"].(map[string]interface{})["title"], ShouldEqual, "second")
+			So(versions[2]["current"], ShouldBeTrue)
+			So(versions[2]["object"].(map[string]interface{})["title"], ShouldEqual, "third")
+		})
+
+		Convey("Objects should be read at the version", func() {
+			response, err := execute(methods.Get, "/posts/1", map[string][]string{"version": {"2"}}, nil)
+			So(err, ShouldBeNil)
+			So(response.Body["title"], ShouldEqual, "second")
+
+			_, err = execute(methods.Get, "/posts/1", map[string][]string{"version": {"4"}}, nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+
+			_, err = execute(methods.Get, "/posts/1", map[string][]string{"version": {"latest"}}, nil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Reverts should restore the version as a new version", func() {
+			response, err := execute(methods.Post, "/posts/1/_revert", nil, map[string]interface{}{"version": 1.0})
+			So(err, ShouldBeNil)
+			So(response.Body["title"], ShouldEqual, "first")
+			So(provider.collections["posts"]["1"]["tag"], ShouldBeNil)
+
+			response, _ = execute(methods.Get, "/posts/1/_versions", nil, nil)
+			So(objectsOf(response.Body, false), ShouldHaveLength, 4)
+		})
+
+		Convey("Versions should be read from the stored counter", func() {
+			QueryPageSize = 1
+			defer func() { QueryPageSize = 1000 }()
+			So(provider.collections["posts"]["1"][VersionField], ShouldEqual, 3)
+
+			for id, revision := range provider.collections["posts_versions"] {
+				if revision["version"] == 1 {
+					delete(provider.collections["posts_versions"], id)
+				}
+			}
+			response, err := execute(methods.Get, "/posts/1/_versions", nil, nil)
+			So(err, ShouldBeNil)
+			versions := objectsOf(response.Body, false)
+			So(versions, ShouldHaveLength, 2)
+			So(versions[0]["version"], ShouldEqual, 2)
+			So(versions[1]["version"], ShouldEqual, 3)
+
+			execute(methods.Put, "/posts/1", nil, map[string]interface{}{"title": "fourth"})
+			response, err = execute(methods.Get, "/posts/1", map[string][]string{"version": {"3"}}, nil)
+			So(err, ShouldBeNil)
+			So(response.Body["title"], ShouldEqual, "third")
+		})
+
+		Convey("Revisions of the failed updates should be removed", func() {
+			_, _, err := Execute(messages.Message{Res: "/posts/1", Command: methods.Put, Body: map[string]interface{}{"title": "fourth"}}, &readOnlyProvider{provider})
+			So(err.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(provider.collections["posts_versions"], ShouldHaveLength, 2)
+		})
+
+		Convey("Commands should run the interceptors of the object", func() {
+			DataProvider = provider
+			var intercepted []string
+			Interceptors = &interceptors.CoreInterceptorController{}
+			Interceptors.Add("/posts/{id}", methods.Any, interceptors.BEFORE_EXEC, func(rs requestscope.RequestScope, extras interface{}, req, resp messages.Message, dp dataprovider.Provider) (editedReq, editedResp messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+				intercepted = append(intercepted, req.Command+" "+rs.Get("id").(string))
+				if req.Command == methods.Put {
+					err = &utils.Error{Code: http.StatusForbidden, Message: "Post is locked."}
+				}
+				return
+			}, nil)
+			defer func() {
+				DataProvider = nil
+				Interceptors = &interceptors.CoreInterceptorController{}
+			}()
+
+			_, _, err := HandleRequest(messages.Message{Res: "/posts/1/_versions", Command: methods.Get}, requestscope.Init())
+			So(err, ShouldBeNil)
+			_, _, err = HandleRequest(messages.Message{Res: "/posts/1/_revert", Command: methods.Post, Body: map[string]interface{}{"version": 1.0}}, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusForbidden)
+			So(provider.collections["posts"]["1"]["title"], ShouldEqual, "third")
+			So(intercepted, ShouldResemble, []string{"get 1", "put 1"})
+		})
+
+		Convey("Revisions should be hidden from the requests", func() {
+			_, err := execute(methods.Get, "/posts_versions", nil, nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			_, err = execute(methods.Delete, "/posts_versions/2", nil, nil)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			So(provider.collections["posts_versions"], ShouldHaveLength, 2)
+		})
+
+		Convey("Commands should be rejected with the other methods and collections", func() {
+			_, err := execute(methods.Delete, "/posts/1/_versions", nil, nil)
+			So(err.Code, ShouldEqual, http.StatusMethodNotAllowed)
+
+			provider.Create("users", map[string]interface{}{"name": "alice"})
+			_, err = execute(methods.Get, "/users/2/_versions", nil, nil)
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
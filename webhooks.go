@@ -0,0 +1,29 @@
+package core
+
+import (
+	"strings"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/webhooks"
+)
+
+// subscriptions are notified of the objects created, updated and deleted by the requests
+var Webhooks *webhooks.Dispatcher
+
+// publishes an event for each object mutated by the request
+func publishWebhooks(request messages.Message, ids []string, objects []map[string]interface{}) {
+
+	if Webhooks == nil || len(ids) == 0 {
+		return
+	}
+
+	class := strings.Split(request.Res, "/")[1]
+	command := strings.ToLower(request.Command)
+
+	published := make([]webhooks.Event, len(ids))
+	for i, id := range ids {
+		published[i] = webhooks.Event{Collection: class, Command: command, Resource: "/" + class + "/" + id, Object: objects[i]}
+	}
+	if len(published) > 0 {
+		Webhooks.Publish(published...)
+	}
+}
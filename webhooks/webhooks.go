@@ -0,0 +1,489 @@
+package webhooks
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+	"net/url"
+	"net/http"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+/**
+ * Subscription of an endpoint to the events. Empty filters match all the
+ * collections or the commands. Secret is stored but never encoded to json, so
+ * it's not exposed by the responses containing the subscriptions.
+ *
+ * Ex: {"url": "https://partner.com/hooks", "collections": ["orders"], "commands": ["post", "put"]}
+ */
+type Subscription struct {
+	ID          string   `json:"-"`
+	URL         string   `json:"url"`
+	Collections []string `json:"collections,omitempty"`
+	Commands    []string `json:"commands,omitempty"`
+	Secret      string   `json:"-"`
+	Disabled    bool     `json:"disabled,omitempty"`
+}
+
+// field of the secret in the stored subscriptions
+const secretField = "secret"
+
+// change of an object. object is nil for the deleted objects
+type Event struct {
+	ID         string                 `json:"id"`
+	Collection string                 `json:"collection"`
+	Command    string                 `json:"command"`
+	Resource   string                 `json:"resource"`
+	Object     map[string]interface{} `json:"object"`
+	Timestamp  time.Time              `json:"timestamp"`
+}
+
+func (s Subscription) Matches(event Event) bool {
+	return !s.Disabled && contains(s.Collections, event.Collection) && contains(s.Commands, event.Command)
+}
+
+func contains(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, item := range filter {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Delivers the events to the subscriptions stored in the data provider. Each event
+ * is posted to the matching subscriptions in the background. Failed deliveries are
+ * retried with exponential backoff and moved to the dead letters after the last
+ * attempt. Each attempt is written to the delivery log.
+ *
+ * Ex: core.Webhooks = webhooks.NewDispatcher(core.DataProvider)
+ */
+type Dispatcher struct {
+	Provider dataprovider.Provider
+	Client   *http.Client
+	// collections of the subscriptions, the delivery log and the dead letters
+	SubscriptionsCollection string
+	DeliveriesCollection    string
+	DeadLettersCollection   string
+	// number of the attempts including the first one
+	MaxAttempts int
+	// delay before the first retry. doubled for each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// used to get the current time, for testing purposes
+	Now func() time.Time
+	// allows the urls on the loopback, private and link local addresses, for testing purposes
+	AllowPrivateHosts bool
+	// page size of the queries reading the subscriptions. 1000 if not set
+	PageSize int
+
+	deliveries sync.WaitGroup
+	// subscriptions are cached until they are changed
+	lock          sync.Mutex
+	subscriptions []Subscription
+	loaded        bool
+}
+
+func NewDispatcher(provider dataprovider.Provider) *Dispatcher {
+	d := &Dispatcher{
+		Provider:                provider,
+		SubscriptionsCollection: "webhooks",
+		DeliveriesCollection:    "webhookDeliveries",
+		DeadLettersCollection:   "webhookDeadLetters",
+		MaxAttempts:             6,
+		BaseDelay:               time.Second,
+		MaxDelay:                10 * time.Minute,
+	}
+
+	// addresses are checked when connecting as well, so the hosts resolving to internal addresses later are not reached
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: d.checkAddress}
+	d.Client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+	}
+	return d
+}
+
+/**
+ * Stores the subscription. Secret is generated if it's empty. Urls of the hosts
+ * resolving to the loopback, private, link local or unspecified addresses are
+ * rejected, so the subscriptions can't reach the internal services.
+ *
+ * Ex: dispatcher.Subscribe(webhooks.Subscription{URL: "https://partner.com/hooks", Collections: []string{"orders"}})
+ */
+func (d *Dispatcher) Subscribe(subscription Subscription) (created Subscription, err *utils.Error) {
+
+	if err = d.validateURL(subscription.URL); err != nil {
+		return
+	}
+	if subscription.Secret == "" {
+		subscription.Secret = randomID()
+	}
+
+	object := dataprovider.ToObject(subscription)
+	object[secretField] = subscription.Secret
+	response, err := d.Provider.Create(d.SubscriptionsCollection, object)
+	if err != nil {
+		return
+	}
+	d.ReloadSubscriptions()
+	created = subscription
+	created.ID = fmt.Sprint(response[dataprovider.IdField])
+	return
+}
+
+func (d *Dispatcher) validateURL(rawURL string) *utils.Error {
+
+	parsed, parseErr := url.Parse(rawURL)
+	if parseErr != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return &utils.Error{Code: http.StatusBadRequest, Message: "Webhook url must be an http or https url."}
+	}
+	if d.AllowPrivateHosts {
+		return nil
+	}
+
+	addresses, lookupErr := net.LookupIP(parsed.Hostname())
+	if lookupErr != nil || len(addresses) == 0 {
+		return &utils.Error{Code: http.StatusBadRequest, Message: "Webhook host '" + parsed.Hostname() + "' can't be resolved."}
+	}
+	for _, address := range addresses {
+		if isInternal(address) {
+			return &utils.Error{Code: http.StatusBadRequest, Message: "Webhook url must not point to an internal address."}
+		}
+	}
+	return nil
+}
+
+// rejects the connections to the internal addresses
+func (d *Dispatcher) checkAddress(network, address string, connection syscall.RawConn) error {
+	if d.AllowPrivateHosts {
+		return nil
+	}
+	host, _, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		return splitErr
+	}
+	if ip := net.ParseIP(host); ip == nil || isInternal(ip) {
+		return errors.New("connecting to the internal address " + host + " is not allowed")
+	}
+	return nil
+}
+
+// carrier grade nat addresses are shared by the internal networks of the providers
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isInternal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || sharedAddressSpace.Contains(ip)
+}
+
+// returns the subscriptions matching the event
+func (d *Dispatcher) subscriptionsOf(event Event) (subscriptions []Subscription, err *utils.Error) {
+
+	all, err := d.allSubscriptions()
+	if err != nil {
+		return
+	}
+	for _, subscription := range all {
+		if subscription.Matches(event) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return
+}
+
+// returns the cached subscriptions, reads all the pages of the collection if they are not loaded
+func (d *Dispatcher) allSubscriptions() (subscriptions []Subscription, err *utils.Error) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.loaded {
+		return d.subscriptions, nil
+	}
+
+	pageSize := d.PageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	objects, _, err := dataprovider.QueryAll(d.Provider, d.SubscriptionsCollection, map[string][]string{}, pageSize, 0)
+	if err != nil {
+		return
+	}
+	for _, object := range objects {
+		if subscription, isValid := subscriptionOf(object); isValid {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	d.subscriptions = subscriptions
+	d.loaded = true
+	return
+}
+
+/**
+ * Drops the cached subscriptions, so they are read again for the next event. Called
+ * by Subscribe. Needs to be called after the subscriptions are changed through the
+ * data provider directly.
+ */
+func (d *Dispatcher) ReloadSubscriptions() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.subscriptions = nil
+	d.loaded = false
+}
+
+// delivers the events to the matching subscriptions in the background
+func (d *Dispatcher) Publish(events ...Event) {
+
+	d.deliveries.Add(1)
+	go func() {
+		defer d.deliveries.Done()
+		for _, event := range events {
+			if event.ID == "" {
+				event.ID = randomID()
+			}
+			if event.Timestamp.IsZero() {
+				event.Timestamp = d.now()
+			}
+
+			subscriptions, err := d.subscriptionsOf(event)
+			if err != nil {
+				log.Error("Reading webhook subscriptions failed. Reason: " + err.Message)
+				continue
+			}
+			for _, subscription := range subscriptions {
+				d.deliveries.Add(1)
+				go func(subscription Subscription, event Event) {
+					defer d.deliveries.Done()
+					d.Deliver(subscription, event)
+				}(subscription, event)
+			}
+		}
+	}()
+}
+
+// waits for the deliveries in the background to complete
+func (d *Dispatcher) Wait() {
+	d.deliveries.Wait()
+}
+
+/**
+ * Posts the event to the subscription until it succeeds or the attempts run out.
+ * Client errors except 408 and 429 are not retried. Returns true if delivered.
+ */
+func (d *Dispatcher) Deliver(subscription Subscription, event Event) (delivered bool) {
+
+	body, encodeErr := json.Marshal(event)
+	if encodeErr != nil {
+		log.Error("Encoding webhook event failed. Reason: " + encodeErr.Error())
+		return
+	}
+	deliveryID := randomID()
+
+	var lastError string
+	for attempt := 1; attempt <= d.maxAttempts(); attempt++ {
+		if attempt > 1 {
+			time.Sleep(d.Backoff(attempt - 1))
+		}
+
+		status, err := d.post(subscription, event, deliveryID, body)
+		d.log(subscription, event, deliveryID, attempt, status, err)
+		if err == nil && status >= 200 && status < 300 {
+			return true
+		}
+
+		if err != nil {
+			lastError = err.Error()
+		} else {
+			lastError = "Endpoint responded with status " + strconv.Itoa(status) + "."
+			if status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+				break
+			}
+		}
+	}
+
+	d.deadLetter(subscription, event, lastError)
+	return
+}
+
+// returns the delay before the retry
+func (d *Dispatcher) Backoff(retry int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < retry && (d.MaxDelay <= 0 || delay < d.MaxDelay); i++ {
+		delay *= 2
+	}
+	if d.MaxDelay > 0 && delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	return delay
+}
+
+func (d *Dispatcher) post(subscription Subscription, event Event, deliveryID string, body []byte) (status int, err error) {
+
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, event.Collection+"."+event.Command)
+	request.Header.Set(DeliveryHeader, deliveryID)
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(SignatureHeader, Sign(subscription.Secret, timestamp, body))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+func (d *Dispatcher) log(subscription Subscription, event Event, deliveryID string, attempt, status int, err error) {
+
+	entry := map[string]interface{}{
+		"deliveryId":     deliveryID,
+		"subscriptionId": subscription.ID,
+		"eventId":        event.ID,
+		"url":            subscription.URL,
+		"attempt":        attempt,
+		"status":         status,
+		"timestamp":      d.now().UTC().Format(time.RFC3339Nano),
+	}
+	if err != nil {
+		entry["error"] = err.Error()
+	}
+	if _, logErr := d.Provider.Create(d.DeliveriesCollection, entry); logErr != nil {
+		log.Error("Writing webhook delivery log failed. Reason: " + logErr.Message)
+	}
+}
+
+func (d *Dispatcher) deadLetter(subscription Subscription, event Event, reason string) {
+
+	log.Warning("Webhook delivery of event '" + event.ID + "' to '" + subscription.URL + "' failed. Reason: " + reason)
+	_, err := d.Provider.Create(d.DeadLettersCollection, map[string]interface{}{
+		"subscriptionId": subscription.ID,
+		"event":          dataprovider.ToObject(event),
+		"reason":         reason,
+		"timestamp":      d.now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		log.Error("Writing webhook dead letter failed. Reason: " + err.Message)
+	}
+}
+
+/**
+ * Delivers the dead letter again. Dead letter is removed if the delivery succeeds,
+ * otherwise a new dead letter is added.
+ */
+func (d *Dispatcher) Redeliver(deadLetterID string) (err *utils.Error) {
+
+	deadLetter, err := d.Provider.Get(d.DeadLettersCollection, deadLetterID)
+	if err != nil {
+		return
+	}
+
+	var event Event
+	eventObject, _ := deadLetter["event"].(map[string]interface{})
+	if eventObject == nil || dataprovider.FromObject(eventObject, &event) != nil {
+		return &utils.Error{Code: http.StatusInternalServerError, Message: "Dead letter has an invalid event."}
+	}
+	subscriptionObject, err := d.Provider.Get(d.SubscriptionsCollection, fmt.Sprint(deadLetter["subscriptionId"]))
+	if err != nil {
+		return
+	}
+	subscription, isValid := subscriptionOf(subscriptionObject)
+	if !isValid {
+		return &utils.Error{Code: http.StatusInternalServerError, Message: "Subscription of the dead letter is invalid."}
+	}
+
+	if _, err = d.Provider.Delete(d.DeadLettersCollection, deadLetterID); err != nil {
+		return
+	}
+	if !d.Deliver(subscription, event) {
+		err = &utils.Error{Code: http.StatusBadGateway, Message: "Webhook delivery failed again."}
+	}
+	return
+}
+
+/**
+ * Returns the signature of the body sent in the X-Webhook-Signature header. Receivers
+ * compute the same value with the secret for verifying the request.
+ *
+ * Ex: "sha256=" + hex(hmac_sha256(secret, timestamp + "." + body))
+ */
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// checks the signature and rejects the timestamps older than the tolerance
+func Verify(secret, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	if tolerance > 0 {
+		seconds, parseErr := strconv.ParseInt(timestamp, 10, 64)
+		if parseErr != nil {
+			return false
+		}
+		if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+			return false
+		}
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts < 1 {
+		return 1
+	}
+	return d.MaxAttempts
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+func randomID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+func subscriptionOf(object map[string]interface{}) (subscription Subscription, isValid bool) {
+	if object == nil || dataprovider.FromObject(object, &subscription) != nil {
+		return
+	}
+	// ids are not decoded since they may not be strings in the providers. secrets are not encoded to json
+	if object[dataprovider.IdField] != nil {
+		subscription.ID = fmt.Sprint(object[dataprovider.IdField])
+	}
+	subscription.Secret, _ = object[secretField].(string)
+	return subscription, true
+}
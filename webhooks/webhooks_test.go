@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"io"
+	"sync"
+	"time"
+	"strconv"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/dataprovider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type collectionProvider struct {
+	dataprovider.Provider
+	lock        sync.Mutex
+	collections map[string][]map[string]interface{}
+	queries     int
+}
+
+func (p *collectionProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	object := map[string]interface{}{"_id": strconv.Itoa(len(p.collections[collection]) + 1)}
+	for key, value := range data {
+		object[key] = value
+	}
+	p.collections[collection] = append(p.collections[collection], object)
+	return object, nil
+}
+
+func (p *collectionProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, object := range p.collections[collection] {
+		if object["_id"] == id {
+			return object, nil
+		}
+	}
+	return nil, &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+}
+
+func (p *collectionProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.queries++
+	objects := p.collections[collection]
+	if values := parameters["skip"]; len(values) > 0 {
+		if skip, _ := strconv.Atoi(values[0]); skip < len(objects) {
+			objects = objects[skip:]
+		} else {
+			objects = nil
+		}
+	}
+	if values := parameters["limit"]; len(values) > 0 {
+		if limit, _ := strconv.Atoi(values[0]); limit < len(objects) {
+			objects = objects[:limit]
+		}
+	}
+	results := make([]interface{}, 0)
+	for _, object := range objects {
+		results = append(results, object)
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+func (p *collectionProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, object := range p.collections[collection] {
+		if object["_id"] == id {
+			p.collections[collection] = append(p.collections[collection][:i], p.collections[collection][i+1:]...)
+			return
+		}
+	}
+	return nil, &utils.Error{Code: http.StatusNotFound, Message: "Object not found."}
+}
+
+func TestDispatcher(t *testing.T) {
+
+	Convey("Given a dispatcher and an endpoint", t, func() {
+		var lock sync.Mutex
+		statuses := []int{}
+		received := []*http.Request{}
+		bodies := [][]byte{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			body, _ := io.ReadAll(r.Body)
+			received = append(received, r)
+			bodies = append(bodies, body)
+			status := http.StatusOK
+			if len(statuses) > 0 {
+				status, statuses = statuses[0], statuses[1:]
+			}
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+
+		provider := &collectionProvider{collections: make(map[string][]map[string]interface{})}
+		dispatcher := NewDispatcher(provider)
+		dispatcher.MaxAttempts = 3
+		dispatcher.BaseDelay = time.Millisecond
+		dispatcher.AllowPrivateHosts = true
+
+		subscription, err := dispatcher.Subscribe(Subscription{URL: server.URL, Collections: []string{"orders"}, Secret: "secret"})
+		So(err, ShouldBeNil)
+		dispatcher.Subscribe(Subscription{URL: server.URL, Collections: []string{"users"}})
+
+		event := Event{Collection: "orders", Command: "post", Resource: "/orders/1", Object: map[string]interface{}{"_id": "1", "total": 10}}
+
+		Convey("Events should be delivered signed to the matching subscriptions", func() {
+			dispatcher.Publish(event)
+			dispatcher.Wait()
+
+			So(received, ShouldHaveLength, 1)
+			request := received[0]
+			So(request.Header.Get(EventHeader), ShouldEqual, "orders.post")
+			So(Verify("secret", request.Header.Get(TimestampHeader), bodies[0], request.Header.Get(SignatureHeader), time.Minute), ShouldBeTrue)
+			So(Verify("other", request.Header.Get(TimestampHeader), bodies[0], request.Header.Get(SignatureHeader), time.Minute), ShouldBeFalse)
+
+			var delivered Event
+			json.Unmarshal(bodies[0], &delivered)
+			So(delivered.ID, ShouldNotBeEmpty)
+			So(delivered.Object["total"], ShouldEqual, 10)
+
+			So(provider.collections["webhookDeliveries"], ShouldHaveLength, 1)
+			So(provider.collections["webhookDeliveries"][0]["status"], ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Server errors should be retried", func() {
+			statuses = []int{http.StatusServiceUnavailable, http.StatusInternalServerError}
+			So(dispatcher.Deliver(subscription, event), ShouldBeTrue)
+
+			So(received, ShouldHaveLength, 3)
+			So(received[0].Header.Get(DeliveryHeader), ShouldEqual, received[2].Header.Get(DeliveryHeader))
+			So(provider.collections["webhookDeliveries"], ShouldHaveLength, 3)
+			So(provider.collections["webhookDeadLetters"], ShouldBeEmpty)
+		})
+
+		Convey("Failed deliveries should be moved to the dead letters", func() {
+			statuses = []int{http.StatusBadRequest}
+			So(dispatcher.Deliver(subscription, event), ShouldBeFalse)
+
+			So(received, ShouldHaveLength, 1)
+			deadLetters := provider.collections["webhookDeadLetters"]
+			So(deadLetters, ShouldHaveLength, 1)
+			So(deadLetters[0]["reason"], ShouldContainSubstring, "400")
+
+			So(dispatcher.Redeliver(deadLetters[0]["_id"].(string)), ShouldBeNil)
+			So(received, ShouldHaveLength, 2)
+			So(provider.collections["webhookDeadLetters"], ShouldBeEmpty)
+		})
+
+		Convey("Retry delays should grow exponentially up to the maximum", func() {
+			dispatcher.BaseDelay = time.Second
+			dispatcher.MaxDelay = 5 * time.Second
+			So(dispatcher.Backoff(1), ShouldEqual, time.Second)
+			So(dispatcher.Backoff(3), ShouldEqual, 4*time.Second)
+			So(dispatcher.Backoff(4), ShouldEqual, 5*time.Second)
+		})
+
+		Convey("Invalid urls should not be subscribed", func() {
+			_, err := dispatcher.Subscribe(Subscription{URL: "ftp://partner.com"})
+			So(err.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Urls of the internal addresses should not be subscribed or delivered", func() {
+			dispatcher.AllowPrivateHosts = false
+			for _, internal := range []string{"http://127.0.0.1/hooks", "http://localhost:8080", "http://169.254.169.254/latest/meta-data", "http://10.0.0.5", "http://[::1]/hooks", "http://0.0.0.0"} {
+				_, err := dispatcher.Subscribe(Subscription{URL: internal})
+				So(err.Code, ShouldEqual, http.StatusBadRequest)
+			}
+
+			dispatcher.MaxAttempts = 1
+			So(dispatcher.Deliver(subscription, event), ShouldBeFalse)
+			So(received, ShouldBeEmpty)
+			So(provider.collections["webhookDeadLetters"][0]["reason"], ShouldContainSubstring, "internal address")
+		})
+
+		Convey("Subscriptions should be read page by page and cached until they change", func() {
+			dispatcher.PageSize = 1
+			subscriptions, _ := dispatcher.subscriptionsOf(Event{Collection: "users", Command: "put"})
+			So(subscriptions, ShouldHaveLength, 1)
+			So(provider.queries, ShouldEqual, 3)
+
+			dispatcher.subscriptionsOf(event)
+			So(provider.queries, ShouldEqual, 3)
+
+			dispatcher.Subscribe(Subscription{URL: server.URL, Collections: []string{"orders"}})
+			subscriptions, _ = dispatcher.subscriptionsOf(event)
+			So(subscriptions, ShouldHaveLength, 2)
+			So(provider.queries, ShouldEqual, 7)
+		})
+
+		Convey("Secrets should be stored but not encoded", func() {
+			So(provider.collections["webhooks"][0]["secret"], ShouldEqual, "secret")
+			encoded, _ := json.Marshal(subscription)
+			So(string(encoded), ShouldNotContainSubstring, "secret")
+
+			subscriptions, _ := dispatcher.subscriptionsOf(event)
+			So(subscriptions[0].Secret, ShouldEqual, "secret")
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/webhooks"
+	"github.com/rihtim/core/requestscope"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhooks(t *testing.T) {
+
+	Convey("Given a webhook subscription", t, func() {
+		var lock sync.Mutex
+		events := []webhooks.Event{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			var event webhooks.Event
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &event)
+			events = append(events, event)
+		}))
+		defer server.Close()
+
+		provider := newMemoryProvider()
+		Webhooks = webhooks.NewDispatcher(provider)
+		Webhooks.AllowPrivateHosts = true
+		defer func() { Webhooks = nil }()
+		Webhooks.Subscribe(webhooks.Subscription{URL: server.URL, Collections: []string{"posts"}})
+
+		Convey("Mutations should be published with the objects", func() {
+			Execute(messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "first"}}, provider)
+			Webhooks.Wait()
+			Execute(messages.Message{Res: "/posts/2", Command: methods.Delete}, provider)
+			Webhooks.Wait()
+
+			So(events, ShouldHaveLength, 2)
+			So(events[0].Command, ShouldEqual, "post")
+			So(events[0].Object["title"], ShouldEqual, "first")
+			So(events[1].Resource, ShouldEqual, "/posts/2")
+			So(events[1].Object, ShouldBeNil)
+		})
+
+		Convey("Mutations in transactions should be published after the commit", func() {
+			transactional := &memoryTransactions{memoryProvider: provider}
+			DataProvider = transactional
+			EnableTransactions("/posts")
+			defer func() {
+				DataProvider = nil
+				transactionalPaths = nil
+			}()
+			request := messages.Message{Res: "/posts", Command: methods.Post, Body: map[string]interface{}{"title": "first"}}
+
+			transactional.commitErr = &utils.Error{Code: http.StatusInternalServerError, Message: "Commit failed."}
+			_, _, err := HandleRequest(request, requestscope.Init())
+			So(err.Code, ShouldEqual, http.StatusInternalServerError)
+			Webhooks.Wait()
+			So(events, ShouldBeEmpty)
+
+			transactional.commitErr = nil
+			_, _, err = HandleRequest(request, requestscope.Init())
+			So(err, ShouldBeNil)
+			Webhooks.Wait()
+			So(events, ShouldHaveLength, 1)
+		})
+
+		Convey("Collections of the webhooks should not be served", func() {
+			for _, res := range []string{"/webhooks", "/webhooks/1", "/webhookDeliveries", "/webhookDeadLetters"} {
+				_, _, err := Execute(messages.Message{Res: res, Command: methods.Get}, provider)
+				So(err.Code, ShouldEqual, http.StatusNotFound)
+			}
+			_, _, err := Execute(messages.Message{Res: "/webhooks", Command: methods.Post, Body: map[string]interface{}{"url": "http://10.0.0.1"}}, provider)
+			So(err.Code, ShouldEqual, http.StatusNotFound)
+			So(provider.collections["webhooks"], ShouldHaveLength, 1)
+		})
+
+		Convey("Reads should not be published", func() {
+			Execute(messages.Message{Res: "/posts", Command: methods.Get}, provider)
+			Webhooks.Wait()
+			So(events, ShouldBeEmpty)
+		})
+	})
+}